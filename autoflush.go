@@ -0,0 +1,116 @@
+package daytripper
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// autoFlusher flushes the receiver on a schedule and after a number of entries (see: WithFlushInterval and
+// WithFlushAfterEntries). Flushes requested while one is running are coalesced into a single flush.
+type autoFlusher struct {
+	interval time.Duration
+	entries  int64
+	onError  func(error)
+
+	count    atomic.Int64
+	request  chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	errMutex sync.Mutex
+	lastErr  error
+}
+
+func (d *DayTripper) flusher() *autoFlusher {
+	if d.autoFlush == nil {
+		d.autoFlush = &autoFlusher{}
+	}
+
+	return d.autoFlush
+}
+
+// start starts flushing in the background if a flush interval or entry count is configured.
+func (f *autoFlusher) start(d *DayTripper) {
+	if f.interval <= 0 && f.entries <= 0 {
+		return
+	}
+
+	f.request = make(chan struct{}, 1)
+	f.stop = make(chan struct{})
+	f.done = make(chan struct{})
+
+	go f.run(d)
+}
+
+// entrySent counts an entry and requests a flush once enough entries have been sent.
+func (f *autoFlusher) entrySent() {
+	if f.entries <= 0 || f.count.Add(1) < f.entries {
+		return
+	}
+	f.count.Store(0)
+
+	select {
+	case f.request <- struct{}{}:
+	default:
+		// A flush is already pending.
+	}
+}
+
+// close stops flushing and waits for a running flush to finish. It returns the last flush error if there's no error
+// handler.
+func (f *autoFlusher) close() error {
+	if f.done == nil {
+		return nil
+	}
+
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+	<-f.done
+
+	f.errMutex.Lock()
+	defer f.errMutex.Unlock()
+
+	err := f.lastErr
+	f.lastErr = nil
+
+	return err
+}
+
+func (f *autoFlusher) run(d *DayTripper) {
+	defer close(f.done)
+
+	var tick <-chan time.Time
+	if f.interval > 0 {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-tick:
+		case <-f.request:
+		}
+
+		f.count.Store(0)
+		if err := d.Flush(); err != nil {
+			f.report(err)
+		}
+	}
+}
+
+func (f *autoFlusher) report(err error) {
+	if f.onError != nil {
+		f.onError(err)
+		return
+	}
+
+	f.errMutex.Lock()
+	f.lastErr = err
+	f.errMutex.Unlock()
+}
@@ -0,0 +1,157 @@
+package daytripper_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+type flushCountingReceiver struct {
+	*receiver.MemoryReceiver
+	flushes atomic.Int32
+	err     error
+}
+
+func (f *flushCountingReceiver) Flush() error {
+	f.flushes.Add(1)
+	return f.err
+}
+
+func waitForFlushes(t *testing.T, recv *flushCountingReceiver, want int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for recv.flushes.Load() < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d flushes, want at least %d", recv.flushes.Load(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	t.Parallel()
+
+	recv := &flushCountingReceiver{MemoryReceiver: receiver.NewMemoryReceiver()}
+	dt, err := daytripper.New(daytripper.WithReceiver(recv), daytripper.WithFlushInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForFlushes(t, recv, 2)
+
+	if err := dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// No flushes happen after Close.
+	flushes := recv.flushes.Load()
+	time.Sleep(10 * time.Millisecond)
+	if got := recv.flushes.Load(); got != flushes {
+		t.Errorf("got %d flushes after Close, want %d", got, flushes)
+	}
+}
+
+func TestFlushAfterEntries(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	recv := &flushCountingReceiver{MemoryReceiver: receiver.NewMemoryReceiver()}
+	client := &http.Client{}
+	dt, err := daytripper.New(
+		daytripper.WithReceiver(recv),
+		daytripper.WithClient(client),
+		daytripper.WithFlushAfterEntries(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dt.Close() //nolint:errcheck
+
+	doRequest := func() {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, svr.URL, nil)
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.ReadAll(rsp.Body)
+		_ = rsp.Body.Close()
+	}
+
+	doRequest()
+	time.Sleep(10 * time.Millisecond)
+	if got := recv.flushes.Load(); got != 0 {
+		t.Fatalf("got %d flushes after one entry, want 0", got)
+	}
+
+	doRequest()
+	waitForFlushes(t, recv, 1)
+}
+
+func TestFlushErrors(t *testing.T) {
+	t.Parallel()
+
+	flushErr := errors.New("disk full")
+
+	t.Run("handler", func(t *testing.T) {
+		t.Parallel()
+
+		reported := make(chan error, 1)
+		recv := &flushCountingReceiver{MemoryReceiver: receiver.NewMemoryReceiver(), err: flushErr}
+		dt, err := daytripper.New(
+			daytripper.WithReceiver(recv),
+			daytripper.WithFlushInterval(time.Millisecond),
+			daytripper.WithFlushErrorHandler(func(err error) {
+				select {
+				case reported <- err:
+				default:
+				}
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-reported:
+			if !errors.Is(err, flushErr) {
+				t.Errorf("got %v, want the flush error", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("flush error wasn't reported")
+		}
+
+		if err := dt.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		t.Parallel()
+
+		recv := &flushCountingReceiver{MemoryReceiver: receiver.NewMemoryReceiver(), err: flushErr}
+		dt, err := daytripper.New(daytripper.WithReceiver(recv), daytripper.WithFlushInterval(time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waitForFlushes(t, recv, 1)
+
+		if err := dt.Close(); !errors.Is(err, flushErr) {
+			t.Errorf("Close: got %v, want the flush error", err)
+		}
+	})
+}
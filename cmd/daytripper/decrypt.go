@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/swedishborgie/daytripper/encrypted"
+)
+
+// runDecrypt decrypts the output of a receiver using encryption. Compressed output is decompressed as well.
+func runDecrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	keyFile := flags.String("key", "", "read the key from `file`, either raw or hex encoded (required)")
+	keyID := flags.String("key-id", "", "only accept input encrypted with the key with this `id`")
+	output := flags.String("o", "", "write the decrypted file to `file` instead of standard output")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: daytripper decrypt -key file [-key-id id] [-o file] [input]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Decrypts the output of a receiver using encryption (from input, or standard input), and")
+		fmt.Fprintln(stderr, "decompresses it if it's compressed.")
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+	if *keyFile == "" {
+		flags.Usage()
+		return fmt.Errorf("no key given")
+	}
+
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	in := stdin
+	if flags.NArg() == 1 {
+		fp, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer fp.Close() //nolint:errcheck
+		in = fp
+	}
+
+	in, err = maybeDecompress(encrypted.NewReader(in, &cliKey{id: *keyID, key: key}))
+	if err != nil {
+		return err
+	}
+
+	out := stdout
+	if *output != "" {
+		fp, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer fp.Close() //nolint:errcheck
+		out = fp
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		if errors.Is(err, encrypted.ErrTruncated) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w, the output ends where the input was cut short (see the repair command)", err)
+		}
+		return err
+	}
+
+	if fp, ok := out.(*os.File); ok && *output != "" {
+		return fp.Close()
+	}
+
+	return nil
+}
+
+// readKey reads a key file containing either the raw key or the hex encoded key. A raw key that happens to be valid
+// hex is only decoded if the result is a valid AES key.
+func readKey(fileName string) ([]byte, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := hex.DecodeString(string(bytes.TrimSpace(data))); err == nil && validKeySize(len(key)) {
+		return key, nil
+	}
+
+	if !validKeySize(len(data)) {
+		return nil, fmt.Errorf("key in %q must be 16, 24 or 32 bytes long, raw or hex encoded", fileName)
+	}
+
+	return data, nil
+}
+
+func validKeySize(size int) bool {
+	return size == 16 || size == 24 || size == 32
+}
+
+// cliKey decrypts with the key given on the command line. If no key ID is given, the key is used for any input.
+type cliKey struct {
+	id  string
+	key []byte
+}
+
+func (k *cliKey) EncryptionKey() (string, []byte, error) {
+	return k.id, k.key, nil
+}
+
+func (k *cliKey) DecryptionKey(id string) ([]byte, error) {
+	if k.id != "" && id != k.id {
+		return nil, fmt.Errorf("input is encrypted with key %q, not %q", id, k.id)
+	}
+
+	return k.key, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/encrypted"
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/streaming"
+)
+
+func TestDecrypt(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte{7}, 32)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	recv := streaming.New(buf,
+		streaming.WithCompression(gzip.BestSpeed),
+		streaming.WithEncryption(encrypted.StaticKey("key-1", key)),
+	)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Entry(&har.Entry{Comment: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	flushed := buf.Len()
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := filepath.Join(dir, "log.har.gz.enc")
+	if err := os.WriteFile(input, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	if err := execute([]string{"decrypt", "-key", keyFile, input}, nil, stdout, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(stdout.Bytes(), archive); err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 1 || archive.Log.Entries[0].Comment != "first" {
+		t.Errorf("unexpected entries: %+v", archive.Log.Entries)
+	}
+
+	// The process died before Close.
+	stdout.Reset()
+	err := execute([]string{"decrypt", "-key", keyFile}, bytes.NewReader(buf.Bytes()[:flushed]), stdout, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "repair") {
+		t.Errorf("got %v, want an error pointing to the repair command", err)
+	}
+	if !strings.Contains(stdout.String(), `"comment":"first"`) {
+		t.Errorf("got %q, want the flushed entry", stdout.String())
+	}
+
+	err = execute([]string{"decrypt", "-key", keyFile, "-key-id", "key-2", input}, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if err == nil {
+		t.Error("expected an error for a different key ID")
+	}
+
+	if err := execute([]string{"decrypt", input}, nil, &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error without a key")
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "hex", data: strings.Repeat("61", 16) + "\n", want: strings.Repeat("a", 16)},
+		// Valid hex, but decoding it doesn't give a valid key size, so it's a raw key.
+		{name: "raw hex digits", data: "0123456789abcdef", want: "0123456789abcdef"},
+		{name: "raw", data: strings.Repeat("k", 24), want: strings.Repeat("k", 24)},
+		{name: "invalid size", data: strings.Repeat("k", 20), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			keyFile := filepath.Join(dir, tc.name)
+			if err := os.WriteFile(keyFile, []byte(tc.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := readKey(keyFile)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got key %q, want an error", key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(key) != tc.want {
+				t.Errorf("got key %q, want %q", key, tc.want)
+			}
+		})
+	}
+}
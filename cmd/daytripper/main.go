@@ -0,0 +1,68 @@
+// Command daytripper contains tools for working with the output of daytripper receivers.
+//
+// Usage:
+//
+//	daytripper <command> [arguments]
+//
+// The commands are:
+//
+//	decrypt   decrypt the output of a receiver using encryption
+//	repair    make a HAR file that was cut short readable again
+//	verify    verify the hash chain over the entries of a HAR file
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// command runs a subcommand with its arguments.
+type command struct {
+	usage string
+	run   func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+var commands = map[string]command{
+	"decrypt": {usage: "decrypt the output of a receiver using encryption", run: runDecrypt},
+	"repair":  {usage: "make a HAR file that was cut short readable again", run: runRepair},
+	"verify":  {usage: "verify the hash chain over the entries of a HAR file", run: runVerify},
+}
+
+func main() {
+	if err := execute(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "daytripper:", err)
+		os.Exit(1)
+	}
+}
+
+func execute(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return fmt.Errorf("no command given")
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printUsage(stderr)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return cmd.run(args[1:], stdin, stdout, stderr)
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Usage: daytripper <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s%s\n", name, commands[name].usage)
+	}
+}
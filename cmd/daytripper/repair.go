@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+// runRepair reads a HAR file that may have been cut short and writes a valid HAR file containing every complete entry.
+// Gzip compressed input is detected automatically.
+func runRepair(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("o", "", "write the repaired file to `file` instead of standard output")
+	compress := flags.Bool("z", false, "gzip compress the repaired file")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: daytripper repair [-o file] [-z] [input]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Reads a HAR file that was cut short (from input, or standard input) and writes a valid HAR")
+		fmt.Fprintln(stderr, "file with every complete entry.")
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+
+	in := stdin
+	if flags.NArg() == 1 {
+		fp, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer fp.Close() //nolint:errcheck
+		in = fp
+	}
+
+	in, err := maybeDecompress(in)
+	if err != nil {
+		return err
+	}
+
+	archive, err := har.Repair(in)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		if err := writeArchive(stdout, archive, *compress); err != nil {
+			return err
+		}
+	} else {
+		fp, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+
+		if err := writeArchive(fp, archive, *compress); err != nil {
+			_ = fp.Close()
+			return err
+		}
+
+		if err := fp.Close(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(stderr, "kept %d entries and %d pages\n", len(archive.Log.Entries), len(archive.Log.Pages))
+
+	return nil
+}
+
+// maybeDecompress returns a reader decompressing r if it starts with the gzip magic number.
+func maybeDecompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		// Too short or not compressed, let the caller deal with it.
+		return br, nil
+	}
+
+	return gzip.NewReader(br)
+}
+
+func writeArchive(w io.Writer, archive *har.HTTPArchive, compress bool) error {
+	if !compress {
+		return json.NewEncoder(w).Encode(archive)
+	}
+
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(archive); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
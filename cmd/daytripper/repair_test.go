@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/streaming"
+)
+
+func TestRepairCompressed(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	recv := streaming.New(buf, streaming.WithCompression(gzip.BestSpeed))
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []string{"first", "second"} {
+		if err := recv.Entry(&har.Entry{Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process died before Close, the gzip stream is incomplete as well.
+	dir := t.TempDir()
+	input := filepath.Join(dir, "broken.har.gz")
+	if err := os.WriteFile(input, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "repaired.har")
+	stderr := &bytes.Buffer{}
+	if err := execute([]string{"repair", "-o", output, input}, nil, nil, stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(data, archive); err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 2 || archive.Log.Entries[1].Comment != "second" {
+		t.Errorf("unexpected entries: %+v", archive.Log.Entries)
+	}
+	if !strings.Contains(stderr.String(), "kept 2 entries") {
+		t.Errorf("unexpected output: %q", stderr.String())
+	}
+}
+
+func TestRepairStdin(t *testing.T) {
+	t.Parallel()
+
+	stdout := &bytes.Buffer{}
+	stdin := strings.NewReader(`{"log":{"version":"1.2","creator":{"name":"test","version":"1"},"entries":[{"comment":"a"},{"comm`)
+	if err := execute([]string{"repair"}, stdin, stdout, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(stdout.Bytes(), archive); err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 1 || archive.Log.Pages == nil {
+		t.Errorf("unexpected log: %+v", archive.Log)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	t.Parallel()
+
+	if err := execute([]string{"nope"}, nil, nil, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error for an unknown command")
+	}
+}
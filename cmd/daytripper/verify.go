@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+// runVerify verifies the hash chain over the entries of a HAR file recorded with middleware.HashChain. Gzip compressed
+// input is detected automatically.
+func runVerify(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	keyFile := flags.String("key", "", "read the HMAC key from `file`, trailing whitespace is ignored")
+	keyHex := flags.Bool("key-hex", false, "the key file contains the hex encoded key rather than the raw key")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: daytripper verify [-key file [-key-hex]] [input]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Verifies the hash chain over the entries of a HAR file (from input, or standard input) and")
+		fmt.Fprintln(stderr, "prints the last link, or reports the first entry that was altered, inserted or removed.")
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+
+	verifier := &har.ChainVerifier{}
+	if *keyFile != "" {
+		key, err := readHMACKey(*keyFile, *keyHex)
+		if err != nil {
+			return err
+		}
+		verifier.Key = key
+	}
+
+	in := stdin
+	if flags.NArg() == 1 {
+		fp, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer fp.Close() //nolint:errcheck
+		in = fp
+	}
+
+	in, err := maybeDecompress(in)
+	if err != nil {
+		return err
+	}
+
+	head, err := verifier.Verify(in)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "verified %d entries, last link %s\n", head.Seq, head.Hash)
+
+	return nil
+}
+
+// readHMACKey reads the key passed to middleware.HashChain from a file, ignoring trailing whitespace. The file contains
+// the raw key, or the hex encoded key if hexEncoded is set; raw keys are never decoded, even if they look like hex.
+func readHMACKey(fileName string, hexEncoded bool) ([]byte, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	key := bytes.TrimRight(data, " \t\r\n")
+	if hexEncoded {
+		if key, err = hex.DecodeString(string(bytes.TrimSpace(key))); err != nil {
+			return nil, fmt.Errorf("key in %q isn't hex encoded: %w", fileName, err)
+		}
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key in %q is empty", fileName)
+	}
+
+	return key, nil
+}
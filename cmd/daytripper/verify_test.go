@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/middleware"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/streaming"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("736563726574\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	input := filepath.Join(dir, "log.har")
+	recv := receiver.NewHARFileReceiver(input)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+	send := middleware.HashChain([]byte("secret"))(recv.Entry)
+	for _, comment := range []string{"first", "second"} {
+		if err := send(&har.Entry{Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	err := execute([]string{"verify", "-key", keyFile, "-key-hex", input}, nil, stdout, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stdout.String(), "verified 2 entries") {
+		t.Errorf("unexpected output: %q", stdout.String())
+	}
+
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte(`"first"`), []byte(`"forged"`), 1)
+
+	err = execute([]string{"verify", "-key", keyFile, "-key-hex"}, bytes.NewReader(tampered), &bytes.Buffer{}, &bytes.Buffer{})
+	if !errors.Is(err, har.ErrChainBroken) || !strings.Contains(err.Error(), "entry 0 was altered") {
+		t.Errorf("got %v, want entry 0 reported as altered", err)
+	}
+}
+
+func TestVerifyRawKey(t *testing.T) {
+	t.Parallel()
+
+	// A raw key made of hex digits, written with a trailing newline.
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("deadbeef\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	recv := streaming.New(buf)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := middleware.HashChain([]byte("deadbeef"))(recv.Entry)(&har.Entry{Comment: "entry"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := execute([]string{"verify", "-key", keyFile}, bytes.NewReader(buf.Bytes()), &bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		t.Errorf("raw key: %v", err)
+	}
+
+	err = execute([]string{"verify", "-key", keyFile, "-key-hex"}, bytes.NewReader(buf.Bytes()), &bytes.Buffer{},
+		&bytes.Buffer{})
+	if !errors.Is(err, har.ErrChainBroken) {
+		t.Errorf("hex decoded key: got %v, want %v", err, har.ErrChainBroken)
+	}
+}
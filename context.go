@@ -14,8 +14,14 @@ const (
 	contextKeyPage      contextKey = "page"
 	contextKeyEndPage   contextKey = "end_page"
 	contextKeyInclude   contextKey = "include"
+
+	contextKeyPageHandle contextKey = "page_handle"
 )
 
+// StartPage returns a context that starts a new page with the given ID when a request is made with it. The page will
+// be sent to the receiver once it has been ended with EndPage and all of its requests have finished.
+//
+// Deprecated: Use DayTripper.StartPage instead, which doesn't require a request to start or end the page.
 func StartPage(ctx context.Context, id, title, comment string) context.Context {
 	page := &har.Page{
 		ID:              id,
@@ -27,14 +33,21 @@ func StartPage(ctx context.Context, id, title, comment string) context.Context {
 	return Page(context.WithValue(ctx, contextKeyStartPage, page), id)
 }
 
+// Page returns a context that associates requests with the page with the given ID.
 func Page(ctx context.Context, pageID string) context.Context {
 	return context.WithValue(ctx, contextKeyPage, pageID)
 }
 
+// EndPage returns a context that ends the page with the given ID when a request is made with it. The page is sent to
+// the receiver once this request, and any other requests belonging to the page, have finished.
+//
+// Deprecated: Use DayTripper.StartPage and PageHandle.End instead.
 func EndPage(ctx context.Context, pageID string) context.Context {
 	return Page(context.WithValue(ctx, contextKeyEndPage, pageID), pageID)
 }
 
+// IncludeContext returns a context that indicates requests made with it should be recorded. This is only needed when
+// WithIncludeAll has been set to false.
 func IncludeContext(ctx context.Context) context.Context {
 	return context.WithValue(ctx, contextKeyInclude, true)
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptrace"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/swedishborgie/daytripper/har"
@@ -28,17 +29,27 @@ type BodyDecoder func(contentEncoding string, src io.Reader, dst io.Writer, maxS
 type DayTripper struct {
 	wrapped     http.RoundTripper
 	version     *receiver.Version
-	receiver    receiver.Receiver
-	pageMWs     []receiver.PageMiddleware
-	entryMWs    []receiver.EntryMiddleware
-	pageMap     map[string]*har.Page
-	pageMutex   sync.RWMutex
+	pageMap     map[string]*PageHandle
+	pageMutex   sync.Mutex
+	pageCounter atomic.Uint64
 	includeAll  bool
 	bodyDecoder BodyDecoder
-	maxBodySize int64
+	maxBodySize atomic.Int64
+	paused      atomic.Bool
 
-	sendEntry receiver.EntryReceiver
-	sendPage  receiver.PageReceiver
+	pageKeyFunc     PageKeyFunc
+	pageIdleTimeout time.Duration
+	autoPages       map[string]*PageHandle
+	autoFlush       *autoFlusher
+
+	// configMutex protects the receiver and middleware chains, which can be replaced while requests are in flight.
+	// Sending to the receiver holds a read lock so a receiver is never closed while it's in use.
+	configMutex sync.RWMutex
+	receiver    receiver.Receiver
+	pageMWs     []receiver.PageMiddleware
+	entryMWs    []receiver.EntryMiddleware
+	sendEntry   receiver.EntryReceiver
+	sendPage    receiver.PageReceiver
 }
 
 // New creates a new DayTripper instance.
@@ -50,7 +61,8 @@ func New(opts ...Option) (*DayTripper, error) {
 			Creator:    "daytripper",
 		},
 		includeAll:  true,
-		pageMap:     make(map[string]*har.Page),
+		pageMap:     make(map[string]*PageHandle),
+		autoPages:   make(map[string]*PageHandle),
 		wrapped:     http.DefaultTransport,
 		bodyDecoder: DecodeBody,
 	}
@@ -63,38 +75,67 @@ func New(opts ...Option) (*DayTripper, error) {
 		return nil, ErrNoReceiver
 	}
 
+	if dt.pageKeyFunc != nil && dt.pageIdleTimeout <= 0 {
+		return nil, errors.New("page grouping requires a positive idle timeout")
+	}
+
 	if err := dt.receiver.Start(dt.version); err != nil {
 		return nil, err
 	}
 
-	// Apply middlewares
-	dt.sendEntry = dt.receiver.Entry
-	for _, mw := range dt.entryMWs {
-		dt.sendEntry = mw(dt.sendEntry)
-	}
+	dt.applyMiddleware()
 
-	dt.sendPage = dt.receiver.Page
-	for _, mw := range dt.pageMWs {
-		dt.sendPage = mw(dt.sendPage)
+	if dt.autoFlush != nil {
+		dt.autoFlush.start(dt)
 	}
 
 	return dt, nil
 }
 
-// RoundTrip will
+// applyMiddleware rebuilds the entry and page middleware chains in front of the receiver. The caller must hold
+// configMutex for writing, unless the DayTripper hasn't been shared yet.
+func (d *DayTripper) applyMiddleware() {
+	d.sendEntry = d.receiver.Entry
+	for _, mw := range d.entryMWs {
+		d.sendEntry = mw(d.sendEntry)
+	}
+
+	d.sendPage = d.receiver.Page
+	for _, mw := range d.pageMWs {
+		d.sendPage = mw(d.sendPage)
+	}
+}
+
+// RoundTrip will record the request and its response, forwarding it to the wrapped http.RoundTripper. The entry is
+// sent to the receiver once the response body has been read or closed.
 func (d *DayTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	if !d.shouldInclude(req.Context()) {
+	page := d.handleStartPage(req.Context())
+	// Pages are ended after this request has been tracked, so the page waits for the response body to be read.
+	defer d.handleEndPage(req.Context())
+
+	if d.paused.Load() || !d.shouldInclude(req.Context()) {
 		// Skip and forward along.
 		return d.wrapped.RoundTrip(req)
 	}
 
-	d.handleStartPage(req.Context())
+	pageRef := pageFromCtx(req.Context())
+	switch {
+	case page != nil:
+		if !page.begin() {
+			page = nil
+		}
+	case pageRef == "" && d.pageKeyFunc != nil:
+		if page = d.autoPage(req); page != nil {
+			pageRef = page.ID()
+		}
+	}
 
 	report := &tripReport{
-		req: req,
+		req:         req,
+		maxBodySize: d.maxBodySize.Load(),
 		entry: &har.Entry{
 			Cache:   &har.Cache{}, // Firefox requires this to be present and not null.
-			PageRef: pageFromCtx(req.Context()),
+			PageRef: pageRef,
 		},
 	}
 
@@ -102,7 +143,7 @@ func (d *DayTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timer.GetTracker()))
 	if req.Body != nil {
-		reqBodyCopier := newStreamCopier(req.Body, nil, d.maxBodySize)
+		reqBodyCopier := newStreamCopier(req.Body, nil, report.maxBodySize)
 		req.Body = reqBodyCopier
 		report.reqBody = reqBodyCopier
 	}
@@ -111,6 +152,9 @@ func (d *DayTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	report.rspErr = err
 
 	doneFunc := func() error {
+		if page != nil {
+			defer page.finish()
+		}
 		timer.responseRead()
 		if err := d.recordTrip(report); err != nil {
 			return err
@@ -121,7 +165,7 @@ func (d *DayTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	var rspBodyCopier *streamCopier
 	if rsp != nil {
 		if rsp.Body != nil {
-			rspBodyCopier = newStreamCopier(rsp.Body, doneFunc, d.maxBodySize)
+			rspBodyCopier = newStreamCopier(rsp.Body, doneFunc, report.maxBodySize)
 			rsp.Body = rspBodyCopier
 		} else {
 			if err := doneFunc(); err != nil {
@@ -136,12 +180,14 @@ func (d *DayTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 	}
 
-	d.handleEndPage(req.Context())
-
 	return rsp, err
 }
 
+// Flush asks the receiver to store everything it has received durably.
 func (d *DayTripper) Flush() error {
+	d.configMutex.RLock()
+	defer d.configMutex.RUnlock()
+
 	if d.receiver == nil {
 		return nil
 	}
@@ -149,12 +195,124 @@ func (d *DayTripper) Flush() error {
 	return d.receiver.Flush()
 }
 
+// Close sends any pages that haven't been sent yet to the receiver and closes it. Automatic flushes are stopped first,
+// if no flush error handler is set the error of the last failed automatic flush is returned as well.
 func (d *DayTripper) Close() error {
+	var flushErr error
+	if d.autoFlush != nil {
+		flushErr = d.autoFlush.close()
+	}
+
+	d.pageMutex.Lock()
+	pages := make([]*PageHandle, 0, len(d.pageMap))
+	for _, page := range d.pageMap {
+		pages = append(pages, page)
+	}
+	d.pageMutex.Unlock()
+
+	for _, page := range pages {
+		page.emit()
+	}
+
+	d.configMutex.RLock()
+	defer d.configMutex.RUnlock()
+
 	if d.receiver == nil {
+		return flushErr
+	}
+
+	return errors.Join(flushErr, d.receiver.Close())
+}
+
+// Pause stops recording new requests, they're forwarded to the wrapped http.RoundTripper untouched. Requests that are
+// already in flight are still recorded.
+func (d *DayTripper) Pause() {
+	d.paused.Store(true)
+}
+
+// Resume resumes recording after Pause.
+func (d *DayTripper) Resume() {
+	d.paused.Store(false)
+}
+
+// Paused returns whether recording is currently paused.
+func (d *DayTripper) Paused() bool {
+	return d.paused.Load()
+}
+
+// SetMaxBodySize changes the maximum number of bytes to buffer from request and response bodies (see:
+// WithMaxBodySize). The new limit applies to requests started after this call.
+func (d *DayTripper) SetMaxBodySize(maxBodySize int64) {
+	d.maxBodySize.Store(maxBodySize)
+}
+
+// SetEntryMiddleware replaces the entry middleware set with WithEntryMiddleware. Entries that finish after this call
+// are passed through the new middleware.
+func (d *DayTripper) SetEntryMiddleware(entryMWs ...receiver.EntryMiddleware) {
+	d.configMutex.Lock()
+	defer d.configMutex.Unlock()
+
+	d.entryMWs = entryMWs
+	d.applyMiddleware()
+}
+
+// SetPageMiddleware replaces the page middleware set with WithPageMiddleware. Pages that are sent after this call
+// are passed through the new middleware.
+func (d *DayTripper) SetPageMiddleware(pageMWs ...receiver.PageMiddleware) {
+	d.configMutex.Lock()
+	defer d.configMutex.Unlock()
+
+	d.pageMWs = pageMWs
+	d.applyMiddleware()
+}
+
+// SetReceiver starts recv and replaces the current receiver with it. Entries and pages that are sent after this call
+// go to the new receiver. Once nothing is being sent to the old receiver anymore it's closed, and any error from
+// closing it is returned. If recv fails to start, the current receiver is kept.
+func (d *DayTripper) SetReceiver(recv receiver.Receiver) error {
+	if recv == nil {
+		return ErrNoReceiver
+	}
+
+	if err := recv.Start(d.version); err != nil {
+		return err
+	}
+
+	d.configMutex.Lock()
+	old := d.receiver
+	d.receiver = recv
+	d.applyMiddleware()
+	d.configMutex.Unlock()
+
+	if old == nil {
 		return nil
 	}
 
-	return d.receiver.Close()
+	return old.Close()
+}
+
+// emitEntry sends an entry through the middleware chain to the receiver.
+func (d *DayTripper) emitEntry(entry *har.Entry) error {
+	d.configMutex.RLock()
+	defer d.configMutex.RUnlock()
+
+	if err := d.sendEntry(entry); err != nil {
+		return err
+	}
+
+	if d.autoFlush != nil {
+		d.autoFlush.entrySent()
+	}
+
+	return nil
+}
+
+// emitPage sends a page through the middleware chain to the receiver.
+func (d *DayTripper) emitPage(page *har.Page) {
+	d.configMutex.RLock()
+	defer d.configMutex.RUnlock()
+
+	d.sendPage(page)
 }
 
 func (d *DayTripper) shouldInclude(ctx context.Context) bool {
@@ -165,41 +323,48 @@ func (d *DayTripper) shouldInclude(ctx context.Context) bool {
 	return ctx.Value(contextKeyInclude) != nil
 }
 
-func (d *DayTripper) handleStartPage(ctx context.Context) {
-	pgInf := ctx.Value(contextKeyStartPage)
-	if pgInf == nil {
-		return
+// handleStartPage starts a page if the context was created with StartPage and returns the page the request belongs
+// to, if any.
+func (d *DayTripper) handleStartPage(ctx context.Context) *PageHandle {
+	if page := pageHandleFromCtx(ctx); page != nil {
+		return page
 	}
-	pg, ok := pgInf.(*har.Page)
-	if !ok {
-		return
+
+	pg, ok := ctx.Value(contextKeyStartPage).(*har.Page)
+	if ok {
+		d.pageMutex.Lock()
+		existing := d.pageMap[pg.ID]
+		d.pageMutex.Unlock()
+
+		// The same context may be used for several requests, only start the page once.
+		if existing == nil || existing.page != pg {
+			page := d.wrapPage(ctx, pg)
+
+			d.pageMutex.Lock()
+			d.pageMap[pg.ID] = page
+			d.pageMutex.Unlock()
+
+			return page
+		}
 	}
 
 	d.pageMutex.Lock()
-	d.pageMap[pg.ID] = pg
-	d.pageMutex.Unlock()
+	defer d.pageMutex.Unlock()
+
+	return d.pageMap[pageFromCtx(ctx)]
 }
 
 func (d *DayTripper) handleEndPage(ctx context.Context) {
-	if ctx.Value(contextKeyEndPage) == nil {
-		return
-	}
-
 	pageID, ok := ctx.Value(contextKeyEndPage).(string)
 	if !ok {
 		return
 	}
 
 	d.pageMutex.Lock()
-	defer d.pageMutex.Unlock()
-
 	page := d.pageMap[pageID]
-	if page == nil {
-		return
-	}
-
-	page.PageTimings.OnLoad = har.DurationMS(time.Since(page.StartedDateTime))
+	d.pageMutex.Unlock()
 
-	d.sendPage(page)
-	delete(d.pageMap, pageID)
+	if page != nil {
+		page.End()
+	}
 }
@@ -1261,3 +1261,186 @@ func TestDayTripperContentEncodingDeflate(t *testing.T) {
 		})
 	}
 }
+
+func TestPauseResume(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	recv := receiver.NewMemoryReceiver()
+	client := &http.Client{}
+	dt, err := daytripper.New(daytripper.WithReceiver(recv), daytripper.WithClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dt.Close() //nolint:errcheck
+
+	doRequest := func() {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, svr.URL, nil)
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.ReadAll(rsp.Body)
+		_ = rsp.Body.Close()
+	}
+
+	dt.Pause()
+	if !dt.Paused() {
+		t.Fatal("Paused() = false after Pause")
+	}
+	doRequest()
+
+	dt.Resume()
+	if dt.Paused() {
+		t.Fatal("Paused() = true after Resume")
+	}
+	doRequest()
+
+	if len(recv.Entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(recv.Entries))
+	}
+}
+
+type closeTrackingReceiver struct {
+	*receiver.MemoryReceiver
+	closed bool
+}
+
+func (c *closeTrackingReceiver) Close() error {
+	c.closed = true
+	return c.MemoryReceiver.Close()
+}
+
+func TestSetReceiverInFlight(t *testing.T) {
+	t.Parallel()
+
+	first := &closeTrackingReceiver{MemoryReceiver: receiver.NewMemoryReceiver()}
+	second := &closeTrackingReceiver{MemoryReceiver: receiver.NewMemoryReceiver()}
+
+	mt := &mockTripper{
+		resp: &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("hello")),
+		},
+	}
+	dt, err := daytripper.New(daytripper.WithReceiver(first), daytripper.WithTripper(mt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com/", nil)
+	rsp, err := dt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+
+	// Swap the receiver while the response body hasn't been read yet.
+	if err := dt.SetReceiver(second); err != nil {
+		t.Fatalf("SetReceiver: %v", err)
+	}
+	if !first.closed {
+		t.Error("old receiver wasn't closed")
+	}
+	if second.Version.Creator != "daytripper" {
+		t.Errorf("new receiver wasn't started, creator = %q", second.Version.Creator)
+	}
+
+	_, _ = io.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+
+	if len(first.Entries) != 0 {
+		t.Errorf("old receiver got %d entries, want 0", len(first.Entries))
+	}
+	if len(second.Entries) != 1 {
+		t.Errorf("new receiver got %d entries, want 1", len(second.Entries))
+	}
+
+	if err := dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !second.closed {
+		t.Error("new receiver wasn't closed")
+	}
+
+	if err := dt.SetReceiver(nil); !errors.Is(err, daytripper.ErrNoReceiver) {
+		t.Errorf("SetReceiver(nil) = %v, want %v", err, daytripper.ErrNoReceiver)
+	}
+}
+
+func TestLiveReconfiguration(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer svr.Close()
+
+	recv := receiver.NewMemoryReceiver()
+	client := &http.Client{}
+	dt, err := daytripper.New(daytripper.WithReceiver(recv), daytripper.WithClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dt.Close() //nolint:errcheck
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, svr.URL, nil)
+			rsp, err := client.Do(req)
+			if err != nil {
+				t.Errorf("request: %v", err)
+				return
+			}
+			_, _ = io.ReadAll(rsp.Body)
+			_ = rsp.Body.Close()
+		}()
+	}
+
+	dt.SetMaxBodySize(4)
+	dt.SetEntryMiddleware(func(next receiver.EntryReceiver) receiver.EntryReceiver {
+		return func(entry *har.Entry) error {
+			entry.Comment = "filtered"
+			return next(entry)
+		}
+	})
+	dt.SetPageMiddleware(func(next receiver.PageReceiver) receiver.PageReceiver {
+		return func(page *har.Page) {
+			page.Comment = "filtered"
+			next(page)
+		}
+	})
+	wg.Wait()
+
+	req, _ := http.NewRequestWithContext(dt.StartPage(context.Background(), "Page").Context(), http.MethodGet, svr.URL, nil)
+	rsp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+
+	if err := dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	last := recv.Entries[len(recv.Entries)-1]
+	if last.Comment != "filtered" {
+		t.Errorf("entry comment = %q, want %q", last.Comment, "filtered")
+	}
+	if last.Response.Content.Text != "0123" {
+		t.Errorf("Content.Text = %q, want %q", last.Response.Content.Text, "0123")
+	}
+	if len(recv.Pages) != 1 || recv.Pages[0].Comment != "filtered" {
+		t.Errorf("pages = %v, want a single filtered page", recv.Pages)
+	}
+}
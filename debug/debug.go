@@ -0,0 +1,150 @@
+// Package debug provides HTTP handlers to inspect and control a running DayTripper, similar to net/http/pprof.
+//
+// To use it, wrap your receiver with NewReceiver and register the handlers on a mux:
+//
+//	recv := debug.NewReceiver(receiver.NewHARFileReceiver("log.har"))
+//	dt, _ := daytripper.New(daytripper.WithReceiver(recv))
+//	debug.Register(mux, dt, recv)
+//
+// The following handlers are registered under /debug/daytripper/:
+//
+//	GET  /debug/daytripper/             Shows the recorder status.
+//	GET  /debug/daytripper/capture      Records for the given number of seconds (default 30), or until the given
+//	                                    number of requests was recorded within them, then downloads the result as
+//	                                    a HAR. e.g. ?seconds=10 or ?requests=50
+//	GET  /debug/daytripper/recent       Downloads the most recent entries kept in memory as a HAR.
+//	POST /debug/daytripper/pause        Pauses recording.
+//	POST /debug/daytripper/resume       Resumes recording.
+//
+// These handlers expose recorded traffic, don't register them on a publicly reachable mux.
+package debug
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/swedishborgie/daytripper"
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver/streaming"
+)
+
+// Prefix is the path the debug handlers are registered under.
+const Prefix = "/debug/daytripper/"
+
+const defaultCaptureTimeout = 30 * time.Second
+
+// Register registers the debug handlers for dt and recv on mux. recv should be the receiver (or wrap the receiver)
+// that dt was created with.
+func Register(mux *http.ServeMux, dt *daytripper.DayTripper, recv *Receiver) {
+	h := &handler{dt: dt, recv: recv, defaultTimeout: defaultCaptureTimeout}
+
+	mux.HandleFunc("GET "+Prefix+"{$}", h.index)
+	mux.HandleFunc("GET "+Prefix+"capture", h.capture)
+	mux.HandleFunc("GET "+Prefix+"recent", h.recent)
+	mux.HandleFunc("POST "+Prefix+"pause", h.pause)
+	mux.HandleFunc("POST "+Prefix+"resume", h.resume)
+}
+
+type handler struct {
+	dt   *daytripper.DayTripper
+	recv *Receiver
+	// defaultTimeout is how long a capture runs without a seconds parameter, this also bounds captures waiting for a
+	// number of requests that may never arrive (e.g. while paused).
+	defaultTimeout time.Duration
+}
+
+func (h *handler) index(w http.ResponseWriter, _ *http.Request) {
+	pages, entries := h.recv.Recent()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintf(w, "paused: %t\nrecent entries: %d\nrecent pages: %d\n", h.dt.Paused(), len(entries), len(pages))
+}
+
+func (h *handler) capture(w http.ResponseWriter, r *http.Request) {
+	seconds, err := queryInt(r, "seconds")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requests, err := queryInt(r, "requests")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timeout := h.defaultTimeout
+	if seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	c := h.recv.startCapture(requests)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+	case <-timer.C:
+	case <-r.Context().Done():
+		h.recv.stopCapture(c)
+		return
+	}
+
+	pages, entries := h.recv.stopCapture(c)
+	h.writeHAR(w, "capture.har", pages, entries)
+}
+
+func (h *handler) recent(w http.ResponseWriter, _ *http.Request) {
+	pages, entries := h.recv.Recent()
+	h.writeHAR(w, "recent.har", pages, entries)
+}
+
+func (h *handler) pause(w http.ResponseWriter, _ *http.Request) {
+	h.dt.Pause()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) resume(w http.ResponseWriter, _ *http.Request) {
+	h.dt.Resume()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeHAR streams the pages and entries to the client as a HAR file.
+func (h *handler) writeHAR(w http.ResponseWriter, fileName string, pages []*har.Page, entries []*har.Entry) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	out := streaming.New(w)
+	if err := out.Start(h.recv.currentVersion()); err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if err := out.Entry(entry); err != nil {
+			return
+		}
+	}
+
+	for _, page := range pages {
+		out.Page(page)
+	}
+
+	_ = out.Close()
+}
+
+func queryInt(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,39 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+func TestCaptureRequestsTimeout(t *testing.T) {
+	t.Parallel()
+
+	h := &handler{recv: NewReceiver(nil), defaultTimeout: 50 * time.Millisecond}
+
+	// No requests are recorded, the capture has to end after the default timeout anyway.
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.capture(rec, httptest.NewRequest(http.MethodGet, Prefix+"capture?requests=5", nil))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("capture without seconds didn't end after the default timeout")
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(rec.Body.Bytes(), archive); err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 0 {
+		t.Errorf("got %d entries, want 0", len(archive.Log.Entries))
+	}
+}
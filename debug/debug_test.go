@@ -0,0 +1,220 @@
+package debug_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/swedishborgie/daytripper"
+	"github.com/swedishborgie/daytripper/debug"
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+type debugTest struct {
+	dt     *daytripper.DayTripper
+	next   *receiver.MemoryReceiver
+	client *http.Client
+	target *httptest.Server
+	debug  *httptest.Server
+}
+
+func newDebugTest(t *testing.T, opts ...debug.Option) *debugTest {
+	t.Helper()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(target.Close)
+
+	next := receiver.NewMemoryReceiver()
+	recv := debug.NewReceiver(next, opts...)
+	client := &http.Client{}
+	dt, err := daytripper.New(daytripper.WithReceiver(recv), daytripper.WithClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = dt.Close()
+	})
+
+	mux := http.NewServeMux()
+	debug.Register(mux, dt, recv)
+	debugSvr := httptest.NewServer(mux)
+	t.Cleanup(debugSvr.Close)
+
+	return &debugTest{dt: dt, next: next, client: client, target: target, debug: debugSvr}
+}
+
+func (dbg *debugTest) doRequest(t *testing.T, path string) {
+	t.Helper()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, dbg.target.URL+path, nil)
+	rsp, err := dbg.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+}
+
+func (dbg *debugTest) call(t *testing.T, method, path string) (int, []byte) {
+	t.Helper()
+
+	req, _ := http.NewRequestWithContext(context.Background(), method, dbg.debug.URL+debug.Prefix+path, nil)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close() //nolint:errcheck
+
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rsp.StatusCode, body
+}
+
+func decodeHAR(t *testing.T, body []byte) *har.HTTPArchive {
+	t.Helper()
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(body, archive); err != nil {
+		t.Fatalf("invalid HAR: %v\n%s", err, body)
+	}
+
+	return archive
+}
+
+func TestCaptureRequests(t *testing.T) {
+	t.Parallel()
+
+	dbg := newDebugTest(t)
+	dbg.doRequest(t, "/before")
+
+	type result struct {
+		status int
+		body   []byte
+	}
+	done := make(chan result)
+	go func() {
+		rsp, err := http.Get(dbg.debug.URL + debug.Prefix + "capture?requests=2")
+		if err != nil {
+			t.Error(err)
+			done <- result{status: -1}
+			return
+		}
+		defer rsp.Body.Close() //nolint:errcheck
+
+		body, _ := io.ReadAll(rsp.Body)
+		done <- result{rsp.StatusCode, body}
+	}()
+
+	// Keep making requests until the capture has seen two of them.
+	var res result
+	for res.status == 0 {
+		select {
+		case res = <-done:
+		default:
+			dbg.doRequest(t, "/during")
+		}
+	}
+
+	if res.status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.status, http.StatusOK)
+	}
+
+	archive := decodeHAR(t, res.body)
+	if len(archive.Log.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(archive.Log.Entries))
+	}
+	for _, entry := range archive.Log.Entries {
+		if !strings.HasSuffix(entry.Request.URL, "/during") {
+			t.Errorf("captured unexpected request %s", entry.Request.URL)
+		}
+	}
+	if archive.Log.Creator.Name != "daytripper" {
+		t.Errorf("creator = %q, want %q", archive.Log.Creator.Name, "daytripper")
+	}
+}
+
+func TestCaptureInvalidParameters(t *testing.T) {
+	t.Parallel()
+
+	dbg := newDebugTest(t)
+
+	for _, query := range []string{"seconds=abc", "requests=-1"} {
+		if status, _ := dbg.call(t, http.MethodGet, "capture?"+query); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRecent(t *testing.T) {
+	t.Parallel()
+
+	dbg := newDebugTest(t, debug.WithRingSize(2))
+	for _, path := range []string{"/1", "/2", "/3"} {
+		dbg.doRequest(t, path)
+	}
+
+	status, body := dbg.call(t, http.MethodGet, "recent")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+
+	archive := decodeHAR(t, body)
+	if len(archive.Log.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(archive.Log.Entries))
+	}
+	if !strings.HasSuffix(archive.Log.Entries[0].Request.URL, "/2") ||
+		!strings.HasSuffix(archive.Log.Entries[1].Request.URL, "/3") {
+		t.Errorf("got %s and %s, want the two most recent requests",
+			archive.Log.Entries[0].Request.URL, archive.Log.Entries[1].Request.URL)
+	}
+
+	// The wrapped receiver still gets everything.
+	if len(dbg.next.Entries) != 3 {
+		t.Errorf("wrapped receiver got %d entries, want 3", len(dbg.next.Entries))
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	t.Parallel()
+
+	dbg := newDebugTest(t)
+
+	if status, _ := dbg.call(t, http.MethodPost, "pause"); status != http.StatusNoContent {
+		t.Fatalf("pause status = %d, want %d", status, http.StatusNoContent)
+	}
+	if !dbg.dt.Paused() {
+		t.Fatal("recorder isn't paused")
+	}
+
+	_, body := dbg.call(t, http.MethodGet, "")
+	if !strings.Contains(string(body), "paused: true") {
+		t.Errorf("index = %q, want it to report paused", body)
+	}
+
+	dbg.doRequest(t, "/paused")
+
+	if status, _ := dbg.call(t, http.MethodPost, "resume"); status != http.StatusNoContent {
+		t.Fatalf("resume status = %d, want %d", status, http.StatusNoContent)
+	}
+	if dbg.dt.Paused() {
+		t.Fatal("recorder is still paused")
+	}
+
+	if status, _ := dbg.call(t, http.MethodGet, "pause"); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET pause status = %d, want %d", status, http.StatusMethodNotAllowed)
+	}
+
+	if len(dbg.next.Entries) != 0 {
+		t.Errorf("got %d entries while paused, want 0", len(dbg.next.Entries))
+	}
+}
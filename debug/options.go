@@ -0,0 +1,11 @@
+package debug
+
+type Option func(r *Receiver)
+
+// WithRingSize sets the number of recent entries and pages kept in memory. The default is 100. If set to 0, nothing
+// is kept.
+func WithRingSize(size int) Option {
+	return func(r *Receiver) {
+		r.ringSize = size
+	}
+}
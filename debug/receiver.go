@@ -0,0 +1,177 @@
+package debug
+
+import (
+	"sync"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// Receiver passes everything it receives to an optional wrapped receiver, while keeping the most recent entries and
+// pages in memory and recording on-demand captures for the debug handlers.
+type Receiver struct {
+	next     receiver.Receiver
+	ringSize int
+
+	mutex    sync.Mutex
+	version  *receiver.Version
+	entries  []*har.Entry
+	pages    []*har.Page
+	captures map[*capture]struct{}
+}
+
+// capture collects entries and pages for a single capture request until it's done.
+type capture struct {
+	recv  *receiver.MemoryReceiver
+	limit int
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (c *capture) finish() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+// NewReceiver creates a new Receiver wrapping next. If next is nil, entries and pages are only kept in memory.
+func NewReceiver(next receiver.Receiver, opts ...Option) *Receiver {
+	r := &Receiver{
+		next:     next,
+		ringSize: 100,
+		version:  &receiver.Version{},
+		captures: make(map[*capture]struct{}),
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+// Start starts the wrapped receiver.
+func (r *Receiver) Start(version *receiver.Version) error {
+	r.mutex.Lock()
+	r.version = version
+	r.mutex.Unlock()
+
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.Start(version)
+}
+
+// Entry records the entry in the ring buffer and any running captures, and passes it to the wrapped receiver.
+func (r *Receiver) Entry(entry *har.Entry) error {
+	r.mutex.Lock()
+	r.entries = appendRing(r.entries, entry, r.ringSize)
+	for c := range r.captures {
+		_ = c.recv.Entry(entry)
+		if c.limit > 0 && len(c.recv.Entries) >= c.limit {
+			c.finish()
+		}
+	}
+	r.mutex.Unlock()
+
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.Entry(entry)
+}
+
+// Page records the page in the ring buffer and any running captures, and passes it to the wrapped receiver.
+func (r *Receiver) Page(page *har.Page) {
+	r.mutex.Lock()
+	r.pages = appendRing(r.pages, page, r.ringSize)
+	for c := range r.captures {
+		c.recv.Page(page)
+	}
+	r.mutex.Unlock()
+
+	if r.next != nil {
+		r.next.Page(page)
+	}
+}
+
+// Flush flushes the wrapped receiver.
+func (r *Receiver) Flush() error {
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.Flush()
+}
+
+// Close ends any running captures and closes the wrapped receiver.
+func (r *Receiver) Close() error {
+	r.mutex.Lock()
+	for c := range r.captures {
+		c.finish()
+	}
+	r.mutex.Unlock()
+
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.Close()
+}
+
+// Recent returns a copy of the entries and pages currently held in the ring buffer, oldest first.
+func (r *Receiver) Recent() ([]*har.Page, []*har.Entry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]*har.Page(nil), r.pages...), append([]*har.Entry(nil), r.entries...)
+}
+
+// startCapture starts recording entries and pages into a new capture. The capture is done once limit entries have
+// been recorded (if limit is greater than zero) or the receiver is closed.
+func (r *Receiver) startCapture(limit int) *capture {
+	c := &capture{
+		recv:  receiver.NewMemoryReceiver(),
+		limit: limit,
+		done:  make(chan struct{}),
+	}
+
+	r.mutex.Lock()
+	r.captures[c] = struct{}{}
+	r.mutex.Unlock()
+
+	return c
+}
+
+// stopCapture stops recording into c and returns the recorded pages and entries.
+func (r *Receiver) stopCapture(c *capture) ([]*har.Page, []*har.Entry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.captures, c)
+	c.finish()
+
+	return c.recv.Pages, c.recv.Entries
+}
+
+func (r *Receiver) currentVersion() *receiver.Version {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.version
+}
+
+// appendRing appends v to ring, dropping the oldest values to keep at most size values.
+func appendRing[T any](ring []T, v T, size int) []T {
+	if size <= 0 {
+		return ring
+	}
+
+	if len(ring) >= size {
+		copy(ring, ring[len(ring)-size+1:])
+		ring = ring[:size-1]
+	}
+
+	return append(ring, v)
+}
@@ -0,0 +1,128 @@
+// Package encrypted implements an authenticated, chunked AES-GCM stream format for encrypting receiver output at rest.
+//
+// Receivers support it the same way they support compression (see: streaming.WithEncryption,
+// receiver.WithEncryption and checkpoint.WithEncryption), so no plaintext is ever written to disk. Use Reader or the
+// "daytripper decrypt" command to read the output.
+//
+// A stream starts with a header containing a random stream ID and the ID of the key, followed by a series of chunks.
+// Every stream is encrypted with a key of its own, derived from the key of the KeyProvider and the stream ID with
+// HKDF-SHA256. Every chunk is sealed with a random nonce, and authenticated together with the header, its position in
+// the stream and whether it's the last chunk. Chunks can't be altered, reordered, removed or moved to another stream
+// without detection, and a stream that was cut short (e.g. by a crash) is reported with ErrTruncated after all
+// complete chunks have been read.
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ChunkSize is the maximum amount of plaintext sealed into a single chunk.
+const ChunkSize = 64 * 1024
+
+const (
+	version       = 1
+	flagFinal     = 1
+	streamIDSize  = 16
+	nonceSize     = 12
+	chunkOverhead = 1 + 4 + nonceSize // flags, ciphertext length and nonce
+)
+
+var magic = []byte("DTENC")
+
+var (
+	// ErrTruncated is returned by Reader when the stream ends without its final chunk, which typically happens when the
+	// writing process crashed. Everything read before the error was authenticated.
+	ErrTruncated = errors.New("encrypted stream is truncated")
+	// ErrInvalid is returned by Reader when the stream isn't in the expected format or fails authentication, e.g.
+	// because it was tampered with or the wrong key was used.
+	ErrInvalid = errors.New("invalid encrypted stream")
+)
+
+// KeyProvider supplies the keys used to encrypt and decrypt streams. Keys must be 16, 24 or 32 bytes long to select
+// AES-128, AES-192 or AES-256. Implementations should be safe for concurrent use.
+type KeyProvider interface {
+	// EncryptionKey returns the key used to encrypt new streams along with its ID. The ID is stored unencrypted in the
+	// stream header, so it must not reveal anything about the key.
+	EncryptionKey() (id string, key []byte, err error)
+	// DecryptionKey returns the key with the given ID.
+	DecryptionKey(id string) ([]byte, error)
+}
+
+// StaticKey returns a KeyProvider that always encrypts with the given key, and only decrypts streams encrypted with
+// the same key ID.
+func StaticKey(id string, key []byte) KeyProvider {
+	return &staticKey{id: id, key: key}
+}
+
+type staticKey struct {
+	id  string
+	key []byte
+}
+
+func (k *staticKey) EncryptionKey() (string, []byte, error) {
+	return k.id, k.key, nil
+}
+
+func (k *staticKey) DecryptionKey(id string) ([]byte, error) {
+	if id != k.id {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	return k.key, nil
+}
+
+// newAEAD returns the cipher for the stream with the given ID, using a key derived from the key of the KeyProvider.
+func newAEAD(key, streamID []byte) (cipher.AEAD, error) {
+	streamKey, err := hkdf.Key(sha256.New, key, streamID, "daytripper encrypted stream", len(key))
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(streamKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// newStream returns the header and the cipher of a new stream with a random stream ID.
+func newStream(keyID string, key []byte) ([]byte, cipher.AEAD, error) {
+	if len(keyID) > 255 {
+		return nil, nil, fmt.Errorf("key ID %q is longer than 255 bytes", keyID)
+	}
+
+	streamID := make([]byte, streamIDSize)
+	if _, err := rand.Read(streamID); err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newAEAD(key, streamID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := append([]byte{}, magic...)
+	header = append(header, version)
+	header = append(header, streamID...)
+	header = append(header, byte(len(keyID)))
+
+	return append(header, keyID...), aead, nil
+}
+
+// additionalData authenticates a chunk together with the stream header, the offset of the chunk in the stream and its
+// flags.
+func additionalData(header []byte, offset int64, flags byte) []byte {
+	ad := make([]byte, 0, len(header)+9)
+	ad = append(ad, header...)
+	ad = binary.BigEndian.AppendUint64(ad, uint64(offset))
+
+	return append(ad, flags)
+}
@@ -0,0 +1,239 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/encrypted"
+)
+
+var testKeys = encrypted.StaticKey("test", bytes.Repeat([]byte{1}, 32))
+
+func encrypt(t *testing.T, chunks ...string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w, err := encrypted.NewWriter(buf, testKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range chunks {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func decrypt(data []byte, keys encrypted.KeyProvider) (string, error) {
+	plain, err := io.ReadAll(encrypted.NewReader(bytes.NewReader(data), keys))
+	return string(plain), err
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat("0123456789", encrypted.ChunkSize/4)
+
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{"empty", nil},
+		{"one chunk", []string{"hello"}},
+		{"several flushes", []string{"hello", " ", "world"}},
+		{"larger than a chunk", []string{large}},
+	}
+	for _, tt := range tests {
+		data := encrypt(t, tt.chunks...)
+
+		if bytes.Contains(data, []byte("hello")) || bytes.Contains(data, []byte("0123456789")) {
+			t.Errorf("%s: output contains plaintext", tt.name)
+		}
+
+		got, err := decrypt(data, testKeys)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if want := strings.Join(tt.chunks, ""); got != want {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, len(got), len(want))
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	w, err := encrypted.NewWriter(buf, testKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	flushed := buf.Len()
+	if _, err := w.Write([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process died before Close, or while writing the final chunk.
+	for _, size := range []int{flushed, buf.Len() - 1} {
+		got, err := decrypt(buf.Bytes()[:size], testKeys)
+		if !errors.Is(err, encrypted.ErrTruncated) {
+			t.Errorf("size %d: got %v, want %v", size, err, encrypted.ErrTruncated)
+		}
+		if got != "first" {
+			t.Errorf("size %d: got %q, want the flushed data", size, got)
+		}
+	}
+}
+
+func TestTampering(t *testing.T) {
+	t.Parallel()
+
+	data := encrypt(t, "first", "second")
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)-1] ^= 1
+
+	// Swap the first two chunks, each chunk is 5 + 12 + 16 bytes plus the plaintext.
+	header := len("DTENC") + 1 + 16 + 1 + len("test")
+	first := data[header : header+33+len("first")]
+	second := data[header+len(first) : header+len(first)+33+len("second")]
+	swapped := append(append(append(bytes.Clone(data[:header]), second...), first...), data[header+len(first)+len(second):]...)
+
+	// Drop the middle chunk.
+	dropped := append(bytes.Clone(data[:header+len(first)]), data[header+len(first)+len(second):]...)
+
+	// Replace the first chunk with the first chunk of another stream encrypted with the same key.
+	other := encrypt(t, "other", "second")
+	foreign := append(append(bytes.Clone(data[:header]), other[header:header+len(first)]...), data[header+len(first):]...)
+
+	for name, tampered := range map[string][]byte{
+		"flipped bit":     flipped,
+		"swapped chunks":  swapped,
+		"dropped chunk":   dropped,
+		"foreign chunk":   foreign,
+		"trailing data":   append(bytes.Clone(data), 0),
+		"not encrypted":   []byte(`{"log":{}}`),
+		"too short":       []byte("DT"),
+		"wrong magic":     append([]byte("XXXXX"), data[5:]...),
+		"unknown version": append(append([]byte("DTENC"), 9), data[6:]...),
+	} {
+		if _, err := decrypt(tampered, testKeys); !errors.Is(err, encrypted.ErrInvalid) {
+			t.Errorf("%s: got %v, want %v", name, err, encrypted.ErrInvalid)
+		}
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	t.Parallel()
+
+	data := encrypt(t, "secret")
+
+	if _, err := decrypt(data, encrypted.StaticKey("other", bytes.Repeat([]byte{1}, 32))); err == nil {
+		t.Error("expected an error for an unknown key ID")
+	}
+
+	if _, err := decrypt(data, encrypted.StaticKey("test", bytes.Repeat([]byte{2}, 32))); !errors.Is(err, encrypted.ErrInvalid) {
+		t.Errorf("got %v, want %v for the wrong key", err, encrypted.ErrInvalid)
+	}
+
+	if _, err := encrypted.NewWriter(io.Discard, encrypted.StaticKey("test", []byte("short"))); err == nil {
+		t.Error("expected an error for an invalid key size")
+	}
+}
+
+func TestReset(t *testing.T) {
+	t.Parallel()
+
+	// Overwrite the final chunk, as receivers do when rewriting the end of their output.
+	var buf []byte
+	out := &sliceWriter{buf: &buf}
+
+	w, err := encrypted.NewWriter(out, testKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("entries")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	offset := w.Offset()
+
+	if _, err := w.Write([]byte(" end")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf = buf[:offset]
+	w.Reset(out, offset)
+	if _, err := w.Write([]byte(" more entries end")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decrypt(buf, testKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "entries more entries end" {
+		t.Errorf("got %q", got)
+	}
+
+	// Starting over begins a new stream, the chunks of the earlier one can't be spliced into it.
+	previous := bytes.Clone(buf)
+	buf = buf[:0]
+	w.Reset(out, 0)
+	if _, err := w.Write([]byte("entries")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(" end")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spliced := append(bytes.Clone(buf[:offset]), previous[offset:]...)
+	if _, err := decrypt(spliced, testKeys); !errors.Is(err, encrypted.ErrInvalid) {
+		t.Errorf("got %v, want %v for chunks of an earlier stream", err, encrypted.ErrInvalid)
+	}
+}
+
+type sliceWriter struct {
+	buf *[]byte
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	*w.buf = append(*w.buf, p...)
+	return len(p), nil
+}
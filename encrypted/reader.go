@@ -0,0 +1,141 @@
+package encrypted
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reader decrypts a stream written by Writer.
+type Reader struct {
+	br     *bufio.Reader
+	keys   KeyProvider
+	aead   cipher.AEAD
+	header []byte
+	offset int64
+	plain  []byte
+	final  bool
+	err    error
+}
+
+// NewReader creates a new Reader decrypting r with the key returned by keys.DecryptionKey for the key ID in the
+// stream header. The header is read on the first call to Read.
+func NewReader(r io.Reader, keys KeyProvider) *Reader {
+	return &Reader{
+		br:   bufio.NewReaderSize(r, ChunkSize+chunkOverhead+64),
+		keys: keys,
+	}
+}
+
+// Read reads decrypted data. Only authenticated data is returned. It returns io.EOF after the final chunk,
+// ErrTruncated if the stream ends before it and an error wrapping ErrInvalid if a chunk fails authentication.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		r.err = r.next()
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+
+	return n, nil
+}
+
+// next reads the header if needed and decrypts the next chunk.
+func (r *Reader) next() error {
+	if r.header == nil {
+		if err := r.readHeader(); err != nil {
+			return err
+		}
+	}
+
+	if r.final {
+		if _, err := r.br.ReadByte(); !errors.Is(err, io.EOF) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: data after the final chunk", ErrInvalid)
+		}
+
+		return io.EOF
+	}
+
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(r.br, prefix); err != nil {
+		return truncated(err)
+	}
+
+	flags := prefix[0]
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if flags&^flagFinal != 0 || length < uint32(r.aead.Overhead()) || length > ChunkSize+uint32(r.aead.Overhead()) {
+		return fmt.Errorf("%w: malformed chunk at offset %d", ErrInvalid, r.offset)
+	}
+
+	data := make([]byte, nonceSize+int(length))
+	if _, err := io.ReadFull(r.br, data); err != nil {
+		return truncated(err)
+	}
+
+	plain, err := r.aead.Open(nil, data[:nonceSize], data[nonceSize:], additionalData(r.header, r.offset, flags))
+	if err != nil {
+		return fmt.Errorf("%w: chunk at offset %d failed authentication", ErrInvalid, r.offset)
+	}
+
+	r.plain = plain
+	r.offset += int64(chunkOverhead) + int64(length)
+	r.final = flags&flagFinal != 0
+
+	return nil
+}
+
+func (r *Reader) readHeader() error {
+	// The magic, the version, the stream ID and the length of the key ID.
+	fixed := make([]byte, len(magic)+1+streamIDSize+1)
+	if _, err := io.ReadFull(r.br, fixed); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: missing header", ErrInvalid)
+		}
+		return err
+	}
+
+	if string(fixed[:len(magic)]) != string(magic) {
+		return fmt.Errorf("%w: not an encrypted stream", ErrInvalid)
+	}
+	if fixed[len(magic)] != version {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalid, fixed[len(magic)])
+	}
+
+	id := make([]byte, fixed[len(fixed)-1])
+	if _, err := io.ReadFull(r.br, id); err != nil {
+		return truncated(err)
+	}
+
+	key, err := r.keys.DecryptionKey(string(id))
+	if err != nil {
+		return err
+	}
+
+	streamID := fixed[len(magic)+1 : len(magic)+1+streamIDSize]
+	if r.aead, err = newAEAD(key, streamID); err != nil {
+		return err
+	}
+
+	r.header = append(fixed, id...)
+	r.offset = int64(len(r.header))
+
+	return nil
+}
+
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrTruncated
+	}
+
+	return err
+}
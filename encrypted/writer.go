@@ -0,0 +1,155 @@
+package encrypted
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Writer encrypts everything written to it. Plaintext is buffered until a chunk is full or Flush or Close is called.
+//
+// Like gzip.Writer, a Writer can be reset to continue a stream at an earlier position. Receivers that rewrite the end
+// of their output use this to replace the final chunk.
+type Writer struct {
+	w      io.Writer
+	keyID  string
+	key    []byte
+	aead   cipher.AEAD
+	header []byte // nil if a new stream is started when the next chunk is sealed
+	offset int64  // offset of the next chunk in the stream, 0 if the header hasn't been written yet
+	buf    []byte
+	closed bool
+}
+
+// NewWriter creates a new Writer encrypting to w with the key returned by keys.EncryptionKey. Nothing is written to w
+// until the first chunk is sealed.
+func NewWriter(w io.Writer, keys KeyProvider) (*Writer, error) {
+	id, key, err := keys.EncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header, aead, err := newStream(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:      w,
+		keyID:  id,
+		key:    key,
+		aead:   aead,
+		header: header,
+		buf:    make([]byte, 0, ChunkSize),
+	}, nil
+}
+
+// Write buffers p, sealing a chunk whenever ChunkSize bytes have been buffered.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypted.Writer")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		count := min(len(p), ChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:count]...)
+		p = p[count:]
+		n += count
+
+		if len(w.buf) == ChunkSize {
+			if err := w.seal(0); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Flush seals the buffered plaintext into a chunk and writes it to the underlying writer. The stream isn't complete
+// until Close is called.
+func (w *Writer) Flush() error {
+	if w.closed || len(w.buf) == 0 {
+		return nil
+	}
+
+	return w.seal(0)
+}
+
+// Close seals the buffered plaintext into the final chunk and writes it to the underlying writer. It doesn't close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	if err := w.seal(flagFinal); err != nil {
+		return err
+	}
+	w.closed = true
+
+	return nil
+}
+
+// Offset returns the position in the stream the next chunk will be written to. The position includes the header.
+func (w *Writer) Offset() int64 {
+	return w.offset
+}
+
+// Reset discards the buffered plaintext and continues the stream at offset, writing to dst. The offset must be a value
+// returned by Offset after sealing a chunk that wasn't final, the output written after it is replaced. The replaced
+// chunks stay valid as the end of the earlier version of the stream: authentication detects chunks moved between
+// streams, not a stream rolled back to an earlier version of itself.
+//
+// An offset of 0 starts a new stream with a new stream ID, so none of the chunks written before are valid in it.
+func (w *Writer) Reset(dst io.Writer, offset int64) {
+	w.w = dst
+	w.offset = offset
+	w.buf = w.buf[:0]
+	w.closed = false
+
+	if offset == 0 {
+		w.header = nil
+	}
+}
+
+// seal encrypts the buffered plaintext into a chunk and writes it, preceded by the header at the start of the stream.
+func (w *Writer) seal(flags byte) error {
+	var out []byte
+	if w.offset == 0 {
+		if w.header == nil {
+			header, aead, err := newStream(w.keyID, w.key)
+			if err != nil {
+				return err
+			}
+			w.header, w.aead = header, aead
+		}
+
+		out = append(out, w.header...)
+		w.offset = int64(len(w.header))
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	ciphertext := w.aead.Seal(nil, nonce, w.buf, additionalData(w.header, w.offset, flags))
+
+	out = append(out, flags)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(ciphertext)))
+	out = append(out, nonce...)
+	out = append(out, ciphertext...)
+
+	if _, err := w.w.Write(out); err != nil {
+		return err
+	}
+
+	w.offset += int64(chunkOverhead + len(ciphertext))
+	w.buf = w.buf[:0]
+
+	return nil
+}
@@ -39,16 +39,15 @@ func execute() error {
 	defer dt.Close() //nolint:errcheck // This is an example
 
 	for i := range 10 {
-		pageID := fmt.Sprintf("page_%d", i)
-		ctx := context.Background()
-		ctx = daytripper.IncludeContext(ctx)                                   // Indicate to the recorder we should record this request.
-		ctx = daytripper.StartPage(ctx, pageID, fmt.Sprintf("Page %d", i), "") // Start the page.
-		ctx = daytripper.Page(ctx, pageID)                                     // Associate this request with the page.
-		ctx = daytripper.EndPage(ctx, pageID)                                  // End the page.
-
-		if err := doRequest(ctx, svr.Addr, client); err != nil {
+		// Start the page, requests made with the page's context are associated with it.
+		page := dt.StartPage(daytripper.IncludeContext(context.Background()), fmt.Sprintf("Page %d", i))
+
+		if err := doRequest(page.Context(), svr.Addr, client); err != nil {
 			return err
 		}
+
+		// End the page, it will be recorded once all of its requests have finished.
+		page.End()
 	}
 
 	if err := svr.Close(); err != nil {
@@ -0,0 +1,121 @@
+package daytripper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PageKeyFunc returns the key of the page a request should automatically be grouped into. Requests that return the
+// same key share a page, which is created by the recorder the first time the key is seen. The key is also used as
+// the page title. Returning an empty string leaves the request without a page.
+type PageKeyFunc func(req *http.Request) string
+
+// GroupByTraceID groups requests by the trace ID of their W3C traceparent header. Requests without a valid
+// traceparent header aren't grouped.
+func GroupByTraceID() PageKeyFunc {
+	return func(req *http.Request) string {
+		// traceparent is formatted as version-traceid-parentid-flags, e.g.:
+		// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+		parts := strings.Split(req.Header.Get("traceparent"), "-")
+		if len(parts) < 4 || len(parts[1]) != 32 || parts[1] == strings.Repeat("0", 32) {
+			return ""
+		}
+
+		return parts[1]
+	}
+}
+
+// GroupByIdleGap groups requests into time windows. A new window is started whenever more than gap has passed
+// between two consecutive requests.
+func GroupByIdleGap(gap time.Duration) PageKeyFunc {
+	var (
+		mutex  sync.Mutex
+		last   time.Time
+		window int
+	)
+
+	return func(*http.Request) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		now := time.Now()
+		if last.IsZero() || now.Sub(last) > gap {
+			window++
+		}
+		last = now
+
+		return fmt.Sprintf("window_%d", window)
+	}
+}
+
+// GroupByCallSite groups requests by the function and line that made them. The call site is the first frame on the
+// calling goroutine's stack outside the Go runtime, net/http and the recorder itself.
+func GroupByCallSite() PageKeyFunc {
+	return func(*http.Request) string {
+		pcs := make([]uintptr, 64)
+		frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+		for {
+			frame, more := frames.Next()
+			if !isInternalFrame(frame.Function) {
+				return fmt.Sprintf("%s (%s:%d)", frame.Function, filepath.Base(frame.File), frame.Line)
+			}
+			if !more {
+				return ""
+			}
+		}
+	}
+}
+
+// internalFrames are the prefixes of the functions between the caller and GroupByCallSite. Functions of this package
+// are listed individually, a prefix covering the whole package would match callers within it (e.g. its tests).
+var internalFrames = []string{
+	"runtime.",
+	"net/http.",
+	"github.com/swedishborgie/daytripper.GroupByCallSite.",
+	"github.com/swedishborgie/daytripper.(*DayTripper).autoPage",
+	"github.com/swedishborgie/daytripper.(*DayTripper).RoundTrip",
+}
+
+func isInternalFrame(function string) bool {
+	for _, prefix := range internalFrames {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// autoPage returns the automatically managed page for the request, starting a new one if needed.
+func (d *DayTripper) autoPage(req *http.Request) *PageHandle {
+	key := d.pageKeyFunc(req)
+	if key == "" {
+		return nil
+	}
+
+	d.pageMutex.Lock()
+	defer d.pageMutex.Unlock()
+
+	if page := d.autoPages[key]; page != nil && page.begin() {
+		return page
+	}
+
+	// Either there's no page for this key yet, or the previous one has already been sent. Automatic pages outlive the
+	// request that started them, so they don't keep its context.
+	page := d.newPage(context.Background(), key)
+	page.autoKey = key
+	page.idleTimeout = d.pageIdleTimeout
+	page.begin()
+
+	d.pageMap[page.ID()] = page
+	d.autoPages[key] = page
+
+	return page
+}
@@ -0,0 +1,18 @@
+package daytripper
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGroupByCallSiteWithinPackage(t *testing.T) {
+	t.Parallel()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+
+	// Callers in this package (e.g. its tests) are call sites like any other.
+	if key := GroupByCallSite()(req); !strings.Contains(key, "TestGroupByCallSiteWithinPackage") {
+		t.Errorf("key = %q, want it to contain the calling function", key)
+	}
+}
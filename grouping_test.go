@@ -0,0 +1,188 @@
+package daytripper_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+func newGroupingTest(
+	t *testing.T, opts ...daytripper.Option,
+) (*daytripper.DayTripper, *receiver.MemoryReceiver, func(req *http.Request)) {
+	t.Helper()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(svr.Close)
+
+	recv := receiver.NewMemoryReceiver()
+	client := &http.Client{}
+	dt, err := daytripper.New(append(opts, daytripper.WithReceiver(recv), daytripper.WithClient(client))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doRequest := func(req *http.Request) {
+		t.Helper()
+		req.URL.Host = strings.TrimPrefix(svr.URL, "http://")
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.ReadAll(rsp.Body)
+		_ = rsp.Body.Close()
+	}
+
+	return dt, recv, doRequest
+}
+
+func TestGroupByTraceID(t *testing.T) {
+	t.Parallel()
+
+	dt, recv, doRequest := newGroupingTest(t, daytripper.WithPageGrouping(daytripper.GroupByTraceID(), time.Minute))
+
+	for _, traceParent := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-01",
+		"",
+	} {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/", nil)
+		if traceParent != "" {
+			req.Header.Set("traceparent", traceParent)
+		}
+		doRequest(req)
+	}
+
+	if err := dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recv.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(recv.Pages))
+	}
+
+	titles := make(map[string]string)
+	for _, page := range recv.Pages {
+		titles[page.ID] = page.Title
+	}
+
+	want := []string{
+		"4bf92f3577b34da6a3ce929d0e0e4736",
+		"4bf92f3577b34da6a3ce929d0e0e4736",
+		"0af7651916cd43dd8448eb211c80319c",
+		"",
+	}
+	for i, entry := range recv.Entries {
+		if titles[entry.PageRef] != want[i] {
+			t.Errorf("entry %d: page title = %q, want %q", i, titles[entry.PageRef], want[i])
+		}
+	}
+}
+
+func TestGroupByIdleGap(t *testing.T) {
+	t.Parallel()
+
+	dt, recv, doRequest := newGroupingTest(t,
+		daytripper.WithPageGrouping(daytripper.GroupByIdleGap(50*time.Millisecond), time.Minute))
+
+	for i := range 3 {
+		if i == 2 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/", nil)
+		doRequest(req)
+	}
+
+	if err := dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recv.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(recv.Pages))
+	}
+	if recv.Entries[0].PageRef != recv.Entries[1].PageRef {
+		t.Errorf("first two entries are in different pages: %q, %q", recv.Entries[0].PageRef, recv.Entries[1].PageRef)
+	}
+	if recv.Entries[1].PageRef == recv.Entries[2].PageRef {
+		t.Errorf("entries separated by a gap share page %q", recv.Entries[1].PageRef)
+	}
+}
+
+func TestGroupByCallSite(t *testing.T) {
+	t.Parallel()
+
+	dt, recv, doRequest := newGroupingTest(t, daytripper.WithPageGrouping(daytripper.GroupByCallSite(), time.Minute))
+
+	for range 2 {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/", nil)
+		doRequest(req)
+	}
+
+	if err := dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recv.Pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(recv.Pages))
+	}
+	if !strings.Contains(recv.Pages[0].Title, "newGroupingTest") {
+		t.Errorf("page title = %q, want it to contain the calling function", recv.Pages[0].Title)
+	}
+}
+
+func TestPageGroupingIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	keyFunc := func(*http.Request) string {
+		return "tenant"
+	}
+	dt, recv, doRequest := newGroupingTest(t, daytripper.WithPageGrouping(keyFunc, 20*time.Millisecond))
+	defer dt.Close() //nolint:errcheck
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/", nil)
+	doRequest(req)
+	time.Sleep(100 * time.Millisecond)
+
+	req, _ = http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/", nil)
+	doRequest(req)
+
+	// Explicit pages take precedence over automatic grouping.
+	page := dt.StartPage(context.Background(), "Explicit")
+	req, _ = http.NewRequestWithContext(page.Context(), http.MethodGet, "http://localhost/", nil)
+	doRequest(req)
+	page.End()
+
+	if len(recv.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(recv.Pages))
+	}
+	if recv.Pages[0].Title != "tenant" {
+		t.Errorf("page title = %q, want %q", recv.Pages[0].Title, "tenant")
+	}
+	if recv.Entries[0].PageRef == recv.Entries[1].PageRef {
+		t.Errorf("entries separated by the idle timeout share page %q", recv.Entries[0].PageRef)
+	}
+	if recv.Entries[2].PageRef != page.ID() {
+		t.Errorf("PageRef = %q, want %q", recv.Entries[2].PageRef, page.ID())
+	}
+}
+
+func TestPageGroupingRequiresIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	_, err := daytripper.New(
+		daytripper.WithReceiver(receiver.NewMemoryReceiver()),
+		daytripper.WithPageGrouping(daytripper.GroupByTraceID(), 0),
+	)
+	if err == nil {
+		t.Error("expected an error for page grouping without an idle timeout")
+	}
+}
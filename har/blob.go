@@ -0,0 +1,87 @@
+package har
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// blobRefPrefix prefixes the hex encoded SHA-256 digest in a blob reference.
+const blobRefPrefix = "sha256:"
+
+// ErrInvalidBlob is returned by LoadBodies when a blob reference is malformed or a blob doesn't match its digest.
+var ErrInvalidBlob = errors.New("invalid blob")
+
+// BlobRef returns the reference of a body stored outside the archive, "sha256:" followed by the hex encoded SHA-256
+// digest of the text. Identical bodies have the same reference, so they only need to be stored once.
+func BlobRef(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return blobRefPrefix + hex.EncodeToString(sum[:])
+}
+
+// BlobName returns the name of the file a body with the given reference is stored in, the hex encoded digest.
+func BlobName(ref string) (string, error) {
+	digest, ok := strings.CutPrefix(ref, blobRefPrefix)
+	if !ok || len(digest) != sha256.Size*2 {
+		return "", fmt.Errorf("%w: unsupported reference %q", ErrInvalidBlob, ref)
+	}
+
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", fmt.Errorf("%w: unsupported reference %q", ErrInvalidBlob, ref)
+	}
+
+	return digest, nil
+}
+
+// LoadBodies puts bodies stored outside the archive (see: Content.BlobRef) back into the entries of an archive,
+// reading them from blobs (typically os.DirFS of the blob directory). Each blob is verified against its digest and
+// read only once, no matter how many entries reference it.
+func LoadBodies(archive *HTTPArchive, blobs fs.FS) error {
+	if archive == nil || archive.Log == nil {
+		return nil
+	}
+
+	loaded := make(map[string]string)
+	for _, entry := range archive.Log.Entries {
+		if entry.Response == nil || entry.Response.Content == nil || entry.Response.Content.BlobRef == "" {
+			continue
+		}
+		content := entry.Response.Content
+
+		text, ok := loaded[content.BlobRef]
+		if !ok {
+			var err error
+			if text, err = loadBlob(blobs, content.BlobRef); err != nil {
+				return err
+			}
+			loaded[content.BlobRef] = text
+		}
+
+		content.Text = text
+		content.BlobRef = ""
+	}
+
+	return nil
+}
+
+func loadBlob(blobs fs.FS, ref string) (string, error) {
+	name, err := BlobName(ref)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := fs.ReadFile(blobs, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to load blob %q: %w", ref, err)
+	}
+
+	text := string(data)
+	if BlobRef(text) != ref {
+		return "", fmt.Errorf("%w: blob %q doesn't match its digest", ErrInvalidBlob, ref)
+	}
+
+	return text, nil
+}
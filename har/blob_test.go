@@ -0,0 +1,40 @@
+package har_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+func TestLoadBodiesErrors(t *testing.T) {
+	t.Parallel()
+
+	ref := har.BlobRef("body")
+	name, err := har.BlobName(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		ref   string
+		blobs fstest.MapFS
+		want  error
+	}{
+		{"malformed reference", "md5:abc", fstest.MapFS{}, har.ErrInvalidBlob},
+		{"missing blob", ref, fstest.MapFS{}, fs.ErrNotExist},
+		{"corrupt blob", ref, fstest.MapFS{name: {Data: []byte("other")}}, har.ErrInvalidBlob},
+	}
+	for _, tt := range tests {
+		archive := &har.HTTPArchive{Log: &har.Log{Entries: []*har.Entry{
+			{Response: &har.Response{Content: &har.Content{BlobRef: tt.ref}}},
+		}}}
+
+		if err := har.LoadBodies(archive, tt.blobs); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
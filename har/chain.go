@@ -0,0 +1,220 @@
+package har
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ChainLink is the link of an entry in a hash chain, stored in Entry.Chain.
+type ChainLink struct {
+	// Seq is the position of the entry in the chain, starting at 1.
+	Seq uint64 `json:"seq"`
+	// Hash is the hex encoded hash over the hash of the previous entry, Seq and the entry itself.
+	Hash string `json:"hash"`
+}
+
+// Chain links entries into a tamper-evident hash chain: every entry stores a hash over the hash of the previous
+// entry, its position in the chain and its own JSON encoding. Changing, inserting or removing an entry breaks the chain
+// from that entry on, which ChainVerifier detects.
+//
+// With a key the hashes are HMAC-SHA-256, otherwise SHA-256. Without a key anyone can recompute the chain after
+// editing entries, so a key kept away from the recorded files is needed to prove they weren't edited. Removing entries
+// from the end can't be detected from the file alone, keep the last link (see: Chain.Head) elsewhere to detect it.
+//
+// A Chain isn't safe for concurrent use, see middleware.HashChain for linking recorded entries.
+type Chain struct {
+	key  []byte
+	head ChainLink
+}
+
+// NewChain creates a new chain. The key is optional.
+func NewChain(key []byte) *Chain {
+	return &Chain{key: key}
+}
+
+// Link returns the link of the next entry without adding it to the chain, the entry isn't modified. Use Append to add
+// it once the entry has been stored.
+func (c *Chain) Link(entry *Entry) (*ChainLink, error) {
+	chained := *entry
+	chained.Chain = nil
+
+	data, err := json.Marshal(&chained)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := c.head.Seq + 1
+	sum, err := chainHash(c.key, c.head.Hash, seq, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChainLink{Seq: seq, Hash: sum}, nil
+}
+
+// Append makes link, returned by Link, the head of the chain.
+func (c *Chain) Append(link *ChainLink) {
+	c.head = *link
+}
+
+// Head returns the link of the last entry in the chain, the zero value if the chain is empty.
+func (c *Chain) Head() ChainLink {
+	return c.head
+}
+
+// chainHash computes the hash of an entry. The entry is hashed as a JSON object with the top-level fields in sorted
+// order and without the "_chain" field, so reordering the fields of an entry or re-indenting it doesn't break the
+// chain.
+func chainHash(key []byte, prev string, seq uint64, entryJSON []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(entryJSON, &fields); err != nil {
+		return "", err
+	}
+	delete(fields, "_chain")
+
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	var h hash.Hash
+	if key != nil {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+
+	h.Write([]byte(prev))
+	h.Write(binary.BigEndian.AppendUint64(nil, seq))
+	h.Write(canonical)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ErrChainBroken is wrapped by the ChainError returned by ChainVerifier.Verify.
+var ErrChainBroken = errors.New("hash chain is broken")
+
+// ChainProblem describes how an entry breaks a hash chain.
+type ChainProblem string
+
+const (
+	// ChainAltered indicates the entry was changed after it was recorded.
+	ChainAltered ChainProblem = "altered"
+	// ChainInserted indicates the entry wasn't part of the recorded chain.
+	ChainInserted ChainProblem = "inserted"
+	// ChainRemoved indicates entries were removed before the entry.
+	ChainRemoved ChainProblem = "removed"
+)
+
+// ChainError reports the first entry breaking a hash chain.
+type ChainError struct {
+	// Index is the index of the entry in the entries array.
+	Index int
+	// Seq is the position in the chain the entry was expected at.
+	Seq uint64
+	// Problem describes how the entry breaks the chain.
+	Problem ChainProblem
+}
+
+func (e *ChainError) Error() string {
+	if e.Problem == ChainRemoved {
+		return fmt.Sprintf("%s: entries were removed before entry %d (expected link %d)", ErrChainBroken, e.Index, e.Seq)
+	}
+
+	return fmt.Sprintf("%s: entry %d was %s (expected link %d)", ErrChainBroken, e.Index, e.Problem, e.Seq)
+}
+
+func (e *ChainError) Unwrap() error {
+	return ErrChainBroken
+}
+
+// ChainVerifier rebuilds the hash chain of the entries in a HAR document (see: Chain).
+type ChainVerifier struct {
+	// Key is the key the chain was created with, nil if it was created without one.
+	Key []byte
+	// Start is the last link before the first entry of the document, e.g. the head returned by Verify for the previous
+	// file when a chain spans several files. Leave it nil if the document starts the chain.
+	Start *ChainLink
+}
+
+// Verify rebuilds the hash chain of the entries in a HAR document. It returns the last link of the chain, which can be
+// compared to a link kept elsewhere to detect entries removed from the end. If an entry breaks the chain, a
+// *ChainError describing the first one is returned.
+//
+// Entries are hashed as they're stored in the document, verify the document as it was written rather than one that
+// was decoded and encoded again.
+func (v *ChainVerifier) Verify(r io.Reader) (*ChainLink, error) {
+	var doc struct {
+		Log *struct {
+			Entries []json.RawMessage `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Log == nil {
+		return nil, fmt.Errorf("%w: missing log", ErrNotHAR)
+	}
+
+	entries := doc.Log.Entries
+	links := make([]*ChainLink, len(entries))
+	for i, raw := range entries {
+		var linked struct {
+			Chain *ChainLink `json:"_chain"`
+		}
+		if err := json.Unmarshal(raw, &linked); err != nil {
+			return nil, fmt.Errorf("invalid entry %d: %w", i, err)
+		}
+		links[i] = linked.Chain
+	}
+
+	head := ChainLink{}
+	if v.Start != nil {
+		head = *v.Start
+	}
+
+	// follows returns whether the entry at index i is the next link after prev.
+	follows := func(prev ChainLink, i int) bool {
+		link := links[i]
+		if link == nil || link.Seq != prev.Seq+1 {
+			return false
+		}
+
+		sum, err := chainHash(v.Key, prev.Hash, link.Seq, entries[i])
+		return err == nil && hmac.Equal([]byte(sum), []byte(link.Hash))
+	}
+
+	for i := range entries {
+		if follows(head, i) {
+			head = *links[i]
+			continue
+		}
+
+		chainErr := &ChainError{Index: i, Seq: head.Seq + 1}
+		link := links[i]
+		switch {
+		case link != nil && link.Seq > head.Seq+1 && (i+1 == len(entries) || follows(*link, i+1)):
+			chainErr.Problem = ChainRemoved
+		case link != nil && link.Seq == head.Seq+1 && (i+1 == len(entries) || follows(*link, i+1)):
+			// The link is intact, but the entry doesn't match it.
+			chainErr.Problem = ChainAltered
+		case i+1 < len(entries) && follows(head, i+1):
+			chainErr.Problem = ChainInserted
+		case link == nil:
+			chainErr.Problem = ChainInserted
+		default:
+			chainErr.Problem = ChainAltered
+		}
+
+		return nil, chainErr
+	}
+
+	return &head, nil
+}
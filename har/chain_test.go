@@ -0,0 +1,155 @@
+package har_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+func chainedEntries(t *testing.T, key []byte, count int) ([]*har.Entry, *har.Chain) {
+	t.Helper()
+
+	chain := har.NewChain(key)
+	entries := make([]*har.Entry, count)
+	for i := range entries {
+		entries[i] = &har.Entry{
+			StartedDateTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Time:            har.DurationMS(1234567),
+			Comment:         fmt.Sprintf("entry %d", i),
+			Request:         &har.Request{Method: "GET", URL: fmt.Sprintf("https://example.com/%d", i)},
+		}
+
+		link, err := chain.Link(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[i].Chain = link
+		chain.Append(link)
+	}
+
+	return entries, chain
+}
+
+func encodeEntries(t *testing.T, entries []*har.Entry) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(&har.HTTPArchive{Log: &har.Log{Version: "1.2", Entries: entries}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestChainVerify(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("secret")
+	entries, chain := chainedEntries(t, key, 5)
+	data := encodeEntries(t, entries)
+
+	head, err := (&har.ChainVerifier{Key: key}).Verify(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *head != chain.Head() || head.Seq != 5 {
+		t.Errorf("got head %+v, want %+v", head, chain.Head())
+	}
+
+	// Re-indenting the document doesn't change the entries.
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&har.ChainVerifier{Key: key}).Verify(&indented); err != nil {
+		t.Errorf("indented document: %v", err)
+	}
+
+	if _, err := (&har.ChainVerifier{Key: []byte("other")}).Verify(bytes.NewReader(data)); !errors.Is(err, har.ErrChainBroken) {
+		t.Errorf("wrong key: got %v, want %v", err, har.ErrChainBroken)
+	}
+
+	// The second half of a chain verifies when starting from the head of the first half.
+	second := encodeEntries(t, entries[3:])
+	if _, err := (&har.ChainVerifier{Key: key, Start: entries[2].Chain}).Verify(bytes.NewReader(second)); err != nil {
+		t.Errorf("continued chain: %v", err)
+	}
+}
+
+func TestChainVerifyTampering(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("secret")
+
+	tests := []struct {
+		name    string
+		tamper  func(entries []*har.Entry) []*har.Entry
+		index   int
+		problem har.ChainProblem
+	}{
+		{
+			name: "altered",
+			tamper: func(entries []*har.Entry) []*har.Entry {
+				entries[2].Request.URL = "https://evil.example.com/"
+				return entries
+			},
+			index:   2,
+			problem: har.ChainAltered,
+		},
+		{
+			name: "altered last",
+			tamper: func(entries []*har.Entry) []*har.Entry {
+				entries[4].Comment = "edited"
+				return entries
+			},
+			index:   4,
+			problem: har.ChainAltered,
+		},
+		{
+			name: "inserted",
+			tamper: func(entries []*har.Entry) []*har.Entry {
+				forged := &har.Entry{Comment: "forged", Chain: &har.ChainLink{Seq: 3, Hash: "00"}}
+				return append(entries[:2:2], append([]*har.Entry{forged}, entries[2:]...)...)
+			},
+			index:   2,
+			problem: har.ChainInserted,
+		},
+		{
+			name: "inserted without link",
+			tamper: func(entries []*har.Entry) []*har.Entry {
+				return append(entries[:1:1], append([]*har.Entry{{Comment: "forged"}}, entries[1:]...)...)
+			},
+			index:   1,
+			problem: har.ChainInserted,
+		},
+		{
+			name: "removed",
+			tamper: func(entries []*har.Entry) []*har.Entry {
+				return append(entries[:1:1], entries[3:]...)
+			},
+			index:   1,
+			problem: har.ChainRemoved,
+		},
+	}
+	for _, tt := range tests {
+		entries, _ := chainedEntries(t, key, 5)
+		data := encodeEntries(t, tt.tamper(entries))
+
+		_, err := (&har.ChainVerifier{Key: key}).Verify(bytes.NewReader(data))
+
+		var chainErr *har.ChainError
+		if !errors.As(err, &chainErr) {
+			t.Errorf("%s: got %v, want a ChainError", tt.name, err)
+			continue
+		}
+		if chainErr.Index != tt.index || chainErr.Problem != tt.problem {
+			t.Errorf("%s: got entry %d %s, want entry %d %s", tt.name, chainErr.Index, chainErr.Problem, tt.index,
+				tt.problem)
+		}
+	}
+}
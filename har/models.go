@@ -100,6 +100,11 @@ type Entry struct {
 	Initiator         *Initiator          `json:"_initiator,omitempty"`
 	FromCache         string              `json:"_fromCache,omitempty"`
 	WebSocketMessages []*WebSocketMessage `json:"_webSocketMessages,omitempty"`
+
+	// DayTripper Extensions
+
+	// Chain links the entry into a tamper-evident hash chain over the recorded entries (see: Chain).
+	Chain *ChainLink `json:"_chain,omitempty"`
 }
 
 // Initiator tracks which part of a page initiated a specific network request. This is a Chrome specific extension.
@@ -251,6 +256,10 @@ type Content struct {
 	Text string `json:"text,omitempty"`
 	// Encoding used for the text field, e.g. "base64". Leave out this field if the text field is plain UTF-8.
 	Encoding string `json:"encoding,omitempty"`
+	// BlobRef references the text stored outside the archive when it was deduplicated (see: BlobRef and
+	// LoadBodies), Text is empty in that case. This is a custom field, it's prefixed with an underscore as required by
+	// the HAR spec.
+	BlobRef string `json:"_blobRef,omitempty"`
 	// Comment is a user provided comment.
 	Comment string `json:"comment,omitempty"`
 }
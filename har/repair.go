@@ -0,0 +1,160 @@
+package har
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotHAR is returned by Repair when the input doesn't start like a HAR document.
+var ErrNotHAR = errors.New("input is not a HAR document")
+
+// Repair reads a HAR document that may have been cut short, e.g. the output of a streaming receiver whose process died
+// before it was closed. Every complete entry is kept and a trailing partial entry is dropped. Pages that were written
+// before the document was cut short are kept, a missing "pages" array is replaced by an empty one.
+//
+// An error reading r is treated like the end of the input, so a truncated gzip stream can be repaired as well.
+// Complete documents are returned unchanged.
+func Repair(r io.Reader) (*HTTPArchive, error) {
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	for {
+		key, err := nextKey(dec)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "log" {
+			break
+		}
+
+		// Skip unknown fields before the log.
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrNotHAR, err)
+		}
+	}
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	log := &Log{}
+	repairLog(dec, log)
+
+	if log.Creator == nil {
+		log.Creator = &Agent{}
+	}
+	if log.Pages == nil {
+		log.Pages = make([]*Page, 0)
+	}
+	if log.Entries == nil {
+		log.Entries = make([]*Entry, 0)
+	}
+
+	return &HTTPArchive{Log: log}, nil
+}
+
+// repairLog decodes the fields of the log object until the end of the input or the first error. Arrays are decoded
+// one element at a time so every complete element is kept.
+func repairLog(dec *json.Decoder, log *Log) {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+
+		key, ok := tok.(string)
+		if !ok {
+			// The end of the log object.
+			return
+		}
+
+		switch key {
+		case "entries":
+			if !decodeArray(dec, &log.Entries) {
+				return
+			}
+		case "pages":
+			if !decodeArray(dec, &log.Pages) {
+				return
+			}
+		case "version":
+			if dec.Decode(&log.Version) != nil {
+				return
+			}
+		case "creator":
+			if dec.Decode(&log.Creator) != nil {
+				return
+			}
+		case "browser":
+			if dec.Decode(&log.Browser) != nil {
+				return
+			}
+		case "comment":
+			if dec.Decode(&log.Comment) != nil {
+				return
+			}
+		default:
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				return
+			}
+		}
+	}
+}
+
+// decodeArray decodes the elements of an array one at a time. It returns false if the input ended or was invalid
+// before the end of the array.
+func decodeArray[T any](dec *json.Decoder, values *[]*T) bool {
+	if expectDelim(dec, '[') != nil {
+		return false
+	}
+
+	if *values == nil {
+		*values = make([]*T, 0)
+	}
+
+	for dec.More() {
+		value := new(T)
+		if err := dec.Decode(value); err != nil {
+			return false
+		}
+		*values = append(*values, value)
+	}
+
+	_, err := dec.Token()
+	return err == nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotHAR, err)
+	}
+
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("%w: expected %q, got %v", ErrNotHAR, delim, tok)
+	}
+
+	return nil
+}
+
+func nextKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrNotHAR, err)
+	}
+
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: missing log", ErrNotHAR)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,101 @@
+package har_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/streaming"
+)
+
+func streamedHAR(t *testing.T, entries int, closed bool) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	recv := streaming.New(buf)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2", Creator: "test", Version: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range entries {
+		if err := recv.Entry(&har.Entry{Comment: fmt.Sprintf("entry %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv.Page(&har.Page{ID: "page_1"})
+
+	if closed {
+		if err := recv.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestRepairTruncated(t *testing.T) {
+	t.Parallel()
+
+	data := streamedHAR(t, 3, false)
+	header := bytes.Index(data, []byte(`"entries":[`)) + len(`"entries":[`)
+
+	// Cut the document at every possible position after the header.
+	for cut := header; cut <= len(data); cut++ {
+		prefix := data[:cut]
+
+		archive, err := har.Repair(bytes.NewReader(prefix))
+		if err != nil {
+			t.Fatalf("cut at %d: %v", cut, err)
+		}
+
+		// An entry is complete once the prefix includes its closing brace, the comment is its last field.
+		want := 0
+		for i := range 3 {
+			end := bytes.Index(data, fmt.Appendf(nil, `"comment":"entry %d"}`, i)) + len(`"comment":"entry 0"}`)
+			if cut >= end {
+				want++
+			}
+		}
+		if got := len(archive.Log.Entries); got != want {
+			t.Fatalf("cut at %d: got %d entries, want %d", cut, got, want)
+		}
+
+		if archive.Log.Version != "1.2" || archive.Log.Creator.Name != "test" || archive.Log.Pages == nil {
+			t.Fatalf("cut at %d: unexpected log %+v", cut, archive.Log)
+		}
+
+		if _, err := json.Marshal(archive); err != nil {
+			t.Fatalf("cut at %d: %v", cut, err)
+		}
+	}
+}
+
+func TestRepairComplete(t *testing.T) {
+	t.Parallel()
+
+	archive, err := har.Repair(bytes.NewReader(streamedHAR(t, 2, true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archive.Log.Entries) != 2 || len(archive.Log.Pages) != 1 || archive.Log.Pages[0].ID != "page_1" {
+		t.Errorf("got %d entries and %d pages, want 2 and 1", len(archive.Log.Entries), len(archive.Log.Pages))
+	}
+}
+
+func TestRepairNotHAR(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{"", "[]", `{"foo":1}`, "not json"} {
+		if _, err := har.Repair(strings.NewReader(input)); !errors.Is(err, har.ErrNotHAR) {
+			t.Errorf("%q: got %v, want ErrNotHAR", input, err)
+		}
+	}
+}
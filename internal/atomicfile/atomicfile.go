@@ -0,0 +1,141 @@
+// Package atomicfile replaces files atomically and durably. The contents are written to a temporary file in the same
+// directory and synced, the temporary file is renamed over the target and the directory is synced, so after a crash or
+// a power loss the target has either its old or its new contents, never a partial write.
+//
+// Temporary files are named "." followed by the base name of the target, ".tmp" and a random suffix, e.g.
+// ".manifest.json.tmp123456", so callers can recognize and remove the ones left behind by a crash.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteFile atomically replaces fileName with data.
+func WriteFile(fileName string, data []byte, perm os.FileMode) error {
+	return Write(fileName, perm, func(fp *os.File) error {
+		_, err := fp.Write(data)
+		return err
+	})
+}
+
+// Write atomically replaces fileName with the contents written by write to the temporary file. The temporary file is
+// removed if write fails.
+func Write(fileName string, perm os.FileMode, write func(fp *os.File) error) error {
+	tmp, err := CreateTemp(fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTemp(tmp, perm, write); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := Rename(tmp.Name(), fileName); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// CreateTemp creates a temporary file in the same directory as fileName, named like the ones Write creates. It's meant
+// for files that are written over a longer time, the caller must sync and close the file before renaming it over
+// fileName with Rename.
+func CreateTemp(fileName string) (*os.File, error) {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+
+	return os.CreateTemp(dir, "."+base+".tmp*")
+}
+
+// RemoveTemp removes the temporary files left behind by a crash while replacing fileName. It must only be called when
+// nothing else is replacing fileName. This is best effort, files that can't be removed are left alone.
+func RemoveTemp(fileName string) {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, de := range entries {
+		if de.Type().IsRegular() && strings.HasPrefix(de.Name(), "."+base+".tmp") {
+			_ = os.Remove(filepath.Join(dir, de.Name()))
+		}
+	}
+}
+
+func writeTemp(tmp *os.File, perm os.FileMode, write func(fp *os.File) error) error {
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+
+	return tmp.Sync()
+}
+
+// WriteFileSync writes data to a new file and syncs it. It isn't atomic on its own, it's meant for files in a
+// temporary directory that is renamed as a whole once complete (see: Rename).
+func WriteFileSync(fileName string, data []byte, perm os.FileMode) error {
+	fp, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fp.Write(data); err != nil {
+		_ = fp.Close()
+		return err
+	}
+
+	if err := fp.Sync(); err != nil {
+		_ = fp.Close()
+		return err
+	}
+
+	return fp.Close()
+}
+
+// Rename renames a synced file or directory and syncs the directory of newPath, so the rename itself is durable.
+// Directories must be synced with SyncDir before they're renamed.
+func Rename(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	SyncDir(filepath.Dir(newPath))
+
+	return nil
+}
+
+// SyncDir syncs a directory so files created, renamed or removed within it are durable. This is best effort, not every
+// platform supports syncing directories.
+func SyncDir(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,118 @@
+package atomicfile_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/internal/atomicfile"
+)
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, de := range dirEntries {
+		names = append(names, de.Name())
+	}
+
+	return names
+}
+
+func TestWriteFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "file.json")
+
+	for _, data := range []string{"first", "second"} {
+		if err := atomicfile.WriteFile(fileName, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("got %q, want %q", got, data)
+		}
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("got mode %v, want 0600", info.Mode().Perm())
+	}
+
+	if names := listFiles(t, dir); len(names) != 1 {
+		t.Errorf("got files %v, want only the target", names)
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "file.json")
+	if err := atomicfile.WriteFile(fileName, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	errWrite := errors.New("write failed")
+	err := atomicfile.Write(fileName, 0o644, func(fp *os.File) error {
+		_, _ = fp.WriteString("partial")
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) {
+		t.Fatalf("got %v, want the write error", err)
+	}
+
+	// The target keeps its contents and the temporary file is removed.
+	got, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "original" {
+		t.Errorf("got %q, want the original contents", got)
+	}
+	if names := listFiles(t, dir); len(names) != 1 {
+		t.Errorf("got files %v, want only the target", names)
+	}
+}
+
+func TestRemoveTemp(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "file.json")
+
+	// A temporary file left behind by a crash, and files that aren't temporary files of the target.
+	tmp, err := atomicfile.CreateTemp(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"file.json", ".other.json.tmp123", "file.json.tmp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	atomicfile.RemoveTemp(fileName)
+
+	if names := listFiles(t, dir); len(names) != 3 || names[0] != ".other.json.tmp123" || names[1] != "file.json" ||
+		names[2] != "file.json.tmp" {
+		t.Errorf("got files %v, want only the temporary file of the target removed", names)
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/internal/atomicfile"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// DedupBodies stores response bodies of at least minSize bytes in dir instead of the archive. Each body is stored once
+// in a file named after its SHA-256 digest, the entry keeps a reference to it (see: har.Content.BlobRef) and an empty
+// text. Repeated identical responses, e.g. from polling, only take up space once.
+//
+// The directory is created if it doesn't exist. Use har.LoadBodies to put the bodies back in when reading the archive.
+func DedupBodies(dir string, minSize int) receiver.EntryMiddleware {
+	return func(recv receiver.EntryReceiver) receiver.EntryReceiver {
+		return func(entry *har.Entry) error {
+			if entry.Response != nil && entry.Response.Content != nil {
+				content := entry.Response.Content
+
+				if content.Text != "" && len(content.Text) >= minSize {
+					ref, err := storeBlob(dir, content.Text)
+					if err != nil {
+						return err
+					}
+
+					content.Text = ""
+					content.BlobRef = ref
+				}
+			}
+
+			return recv(entry)
+		}
+	}
+}
+
+// storeBlob writes text to a file named after its digest, unless it already exists. The file is written atomically, so
+// a blob is never seen partially written, even after a power loss.
+func storeBlob(dir, text string) (string, error) {
+	ref := har.BlobRef(text)
+
+	name, err := har.BlobName(ref)
+	if err != nil {
+		return "", err
+	}
+	fileName := filepath.Join(dir, name)
+
+	if _, err := os.Stat(fileName); err == nil {
+		return ref, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	// Concurrent writers of the same blob write identical contents, whichever rename wins is fine.
+	if err := atomicfile.WriteFile(fileName, []byte(text), 0o644); err != nil {
+		return "", fmt.Errorf("failed to store blob %q: %w", ref, err)
+	}
+
+	return ref, nil
+}
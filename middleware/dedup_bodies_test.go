@@ -0,0 +1,86 @@
+package middleware_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/middleware"
+)
+
+func TestDedupBodies(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "blobs")
+	body := strings.Repeat(`{"config":"value"}`, 10)
+
+	var received []*har.Entry
+	recv := middleware.DedupBodies(dir, 100)(func(e *har.Entry) error {
+		received = append(received, e)
+		return nil
+	})
+
+	texts := []string{body, body, "small", ""}
+	for _, text := range texts {
+		entry := &har.Entry{Response: &har.Response{Content: &har.Content{Text: text, Encoding: "base64"}}}
+		if err := recv(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recv(&har.Entry{Response: &har.Response{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 2 {
+		content := received[i].Response.Content
+		if content.Text != "" || content.BlobRef != har.BlobRef(body) || content.Encoding != "base64" {
+			t.Errorf("entry %d: content = %+v, want the body replaced by a reference", i, content)
+		}
+	}
+	if content := received[2].Response.Content; content.Text != "small" || content.BlobRef != "" {
+		t.Errorf("content = %+v, want bodies below the threshold kept", content)
+	}
+
+	blobs, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 1 {
+		t.Fatalf("got %d blobs, want identical bodies stored once", len(blobs))
+	}
+
+	archive := &har.HTTPArchive{Log: &har.Log{Entries: received}}
+	if err := har.LoadBodies(archive, os.DirFS(dir)); err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range texts {
+		if content := received[i].Response.Content; content.Text != text || content.BlobRef != "" {
+			t.Errorf("entry %d: content = %+v, want the body restored", i, content)
+		}
+	}
+}
+
+func TestDedupBodiesStoreError(t *testing.T) {
+	t.Parallel()
+
+	// A file where the blob directory should be makes storing fail.
+	dir := filepath.Join(t.TempDir(), "blobs")
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	recv := middleware.DedupBodies(dir, 1)(func(e *har.Entry) error {
+		called = true
+		return nil
+	})
+
+	if err := recv(&har.Entry{Response: &har.Response{Content: &har.Content{Text: "body"}}}); err == nil {
+		t.Error("expected an error storing the blob")
+	}
+	if called {
+		t.Error("the entry was passed on without its body")
+	}
+}
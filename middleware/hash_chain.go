@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"sync"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// HashChain links every entry into a tamper-evident hash chain (see: har.Chain), stored in har.Entry.Chain. Use
+// har.ChainVerifier to prove a recorded HAR hasn't been edited. The key is optional, but without one anyone can
+// recompute the chain after editing entries.
+//
+// Entries are passed on one at a time so they're stored in the order they were linked, and an entry only becomes part
+// of the chain once the receiver accepted it. The entry must not be changed afterward, so pass this middleware first
+// to daytripper.WithEntryMiddleware, which places it closest to the receiver.
+func HashChain(key []byte) receiver.EntryMiddleware {
+	chain := har.NewChain(key)
+	var mutex sync.Mutex
+
+	return func(recv receiver.EntryReceiver) receiver.EntryReceiver {
+		return func(entry *har.Entry) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			link, err := chain.Link(entry)
+			if err != nil {
+				return err
+			}
+			entry.Chain = link
+
+			if err := recv(entry); err != nil {
+				return err
+			}
+			chain.Append(link)
+
+			return nil
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/middleware"
+)
+
+func TestHashChain(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("secret")
+	var (
+		received []*har.Entry
+		fail     bool
+	)
+	recv := middleware.HashChain(key)(func(e *har.Entry) error {
+		if fail {
+			return errors.New("disk full")
+		}
+		received = append(received, e)
+		return nil
+	})
+
+	for i := range 4 {
+		// An entry the receiver rejects doesn't become part of the chain.
+		fail = i == 2
+		err := recv(&har.Entry{Comment: fmt.Sprintf("entry %d", i)})
+		if fail != (err != nil) {
+			t.Fatalf("entry %d: unexpected error %v", i, err)
+		}
+	}
+
+	if len(received) != 3 || received[2].Chain == nil || received[2].Chain.Seq != 3 {
+		t.Fatalf("unexpected entries: %+v", received)
+	}
+
+	data, err := json.Marshal(&har.HTTPArchive{Log: &har.Log{Entries: received}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := (&har.ChainVerifier{Key: key}).Verify(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *head != *received[2].Chain {
+		t.Errorf("got head %+v, want the link of the last entry", head)
+	}
+}
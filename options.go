@@ -2,6 +2,7 @@ package daytripper
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/swedishborgie/daytripper/receiver"
 )
@@ -75,7 +76,7 @@ func WithTripper(transport http.RoundTripper) Option {
 // unlimited.
 func WithMaxBodySize(maxBodySize int64) Option {
 	return func(d *DayTripper) {
-		d.maxBodySize = maxBodySize
+		d.maxBodySize.Store(maxBodySize)
 	}
 }
 
@@ -103,3 +104,40 @@ func WithClient(client *http.Client) Option {
 		client.Transport = d
 	}
 }
+
+// WithPageGrouping automatically groups requests that don't already belong to a page into pages, using keyFunc to
+// decide which page a request belongs to (see: GroupByTraceID, GroupByIdleGap and GroupByCallSite). Pages are created
+// by the recorder and ended once no requests have been made for them for idleTimeout, which must be positive since most
+// keys (e.g. trace IDs) are never seen again. New returns an error otherwise.
+func WithPageGrouping(keyFunc PageKeyFunc, idleTimeout time.Duration) Option {
+	return func(d *DayTripper) {
+		d.pageKeyFunc = keyFunc
+		d.pageIdleTimeout = idleTimeout
+	}
+}
+
+// WithFlushInterval flushes the receiver in the background every interval, so recordings are stored durably without
+// the application calling DayTripper.Flush. Flushes stop when the DayTripper is closed. Errors are reported to the
+// handler set with WithFlushErrorHandler.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(d *DayTripper) {
+		d.flusher().interval = interval
+	}
+}
+
+// WithFlushAfterEntries flushes the receiver in the background after every n entries. This can be combined with
+// WithFlushInterval, the entry count is reset by every flush. Flushes that are requested while one is running are
+// coalesced into a single flush.
+func WithFlushAfterEntries(n int) Option {
+	return func(d *DayTripper) {
+		d.flusher().entries = int64(n)
+	}
+}
+
+// WithFlushErrorHandler sets a function that is called with the errors of automatic flushes (see: WithFlushInterval).
+// Without a handler the error of the last failed flush is returned from DayTripper.Close.
+func WithFlushErrorHandler(handler func(error)) Option {
+	return func(d *DayTripper) {
+		d.flusher().onError = handler
+	}
+}
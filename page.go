@@ -0,0 +1,213 @@
+package daytripper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+// PageHandle tracks the lifecycle of a single page. Requests made with the context returned by PageHandle.Context are
+// associated with the page, and the page is only sent to the receiver once it has been ended and every associated
+// request has finished (e.g. its response body has been read or closed).
+type PageHandle struct {
+	dt   *DayTripper
+	page *har.Page
+	ctx  context.Context
+	done chan struct{}
+
+	// autoKey and idleTimeout are set for pages managed by a PageKeyFunc.
+	autoKey     string
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+
+	mutex        sync.Mutex
+	pending      int
+	entries      int
+	lastFinished time.Time
+	endedAt      time.Time
+	ended        bool
+	emitted      bool
+}
+
+// StartPage starts a new page with the given title and returns a handle to it. Use PageHandle.Context for requests
+// that belong to the page and call PageHandle.End once the page is complete. Pages that are never ended are sent to
+// the receiver when the DayTripper is closed.
+func (d *DayTripper) StartPage(ctx context.Context, title string) *PageHandle {
+	p := d.newPage(ctx, title)
+
+	d.pageMutex.Lock()
+	d.pageMap[p.ID()] = p
+	d.pageMutex.Unlock()
+
+	return p
+}
+
+func (d *DayTripper) newPage(ctx context.Context, title string) *PageHandle {
+	return d.wrapPage(ctx, &har.Page{
+		ID:              fmt.Sprintf("page_%d", d.pageCounter.Add(1)),
+		Title:           title,
+		StartedDateTime: time.Now(),
+		PageTimings:     &har.PageTimings{},
+	})
+}
+
+func (d *DayTripper) wrapPage(ctx context.Context, page *har.Page) *PageHandle {
+	p := &PageHandle{
+		dt:   d,
+		page: page,
+		done: make(chan struct{}),
+	}
+	p.ctx = context.WithValue(Page(ctx, page.ID), contextKeyPageHandle, p)
+
+	return p
+}
+
+// ID returns the identifier of the page, entries belonging to the page reference it in har.Entry.PageRef.
+func (p *PageHandle) ID() string {
+	return p.page.ID
+}
+
+// Context returns a context that associates requests with this page.
+func (p *PageHandle) Context() context.Context {
+	return p.ctx
+}
+
+// MarkContentLoaded records the current time as the page's OnContentLoad timing.
+func (p *PageHandle) MarkContentLoaded() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.emitted {
+		return
+	}
+
+	p.page.PageTimings.OnContentLoad = har.DurationMS(time.Since(p.page.StartedDateTime))
+}
+
+// End marks the page as complete. The page will be sent to the receiver as soon as every request associated with it
+// has finished, which may be immediately. Calling End more than once has no effect.
+func (p *PageHandle) End() {
+	p.mutex.Lock()
+	if p.ended {
+		p.mutex.Unlock()
+		return
+	}
+	p.ended = true
+	p.endedAt = time.Now()
+	emit := p.pending == 0 && p.markEmitted()
+	p.mutex.Unlock()
+
+	if emit {
+		p.send()
+	}
+}
+
+// Done returns a channel that is closed once the page has been sent to the receiver.
+func (p *PageHandle) Done() <-chan struct{} {
+	return p.done
+}
+
+// begin registers a new in-flight request with the page. Requests started after End are still awaited as long as the
+// page hasn't been sent. It returns false if the page has already been sent, in which case the request isn't tracked.
+func (p *PageHandle) begin() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.emitted {
+		return false
+	}
+
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+	}
+
+	p.pending++
+	return true
+}
+
+// finish marks an in-flight request as finished and sends the page if it was the last request of an ended page.
+func (p *PageHandle) finish() {
+	p.mutex.Lock()
+	p.pending--
+	p.entries++
+	p.lastFinished = time.Now()
+	emit := p.ended && p.pending == 0 && p.markEmitted()
+
+	// Automatic pages end once they've been idle for long enough.
+	if !p.ended && p.pending == 0 && p.idleTimeout > 0 {
+		if p.idleTimer == nil {
+			p.idleTimer = time.AfterFunc(p.idleTimeout, p.End)
+		} else {
+			p.idleTimer.Reset(p.idleTimeout)
+		}
+	}
+	p.mutex.Unlock()
+
+	if emit {
+		p.send()
+	}
+}
+
+// emit computes the final page timings and sends the page to the receiver, even if requests are still in flight. Only
+// the first call has any effect.
+func (p *PageHandle) emit() {
+	p.mutex.Lock()
+	emit := p.markEmitted()
+	p.mutex.Unlock()
+
+	if emit {
+		p.send()
+	}
+}
+
+// markEmitted marks the page as sent and computes its final timings, so no further requests are tracked. It returns
+// false if the page was already marked. The caller must hold the mutex and call send if it returns true.
+func (p *PageHandle) markEmitted() bool {
+	if p.emitted {
+		return false
+	}
+	p.emitted = true
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+	}
+
+	// The page is loaded once the last of its requests has finished. Pages without any requests are loaded when they
+	// are ended, or right now if they're being flushed on close.
+	loadedAt := p.lastFinished
+	if p.entries == 0 {
+		loadedAt = p.endedAt
+	}
+	if loadedAt.IsZero() {
+		loadedAt = time.Now()
+	}
+	p.page.PageTimings.OnLoad = har.DurationMS(loadedAt.Sub(p.page.StartedDateTime))
+
+	return true
+}
+
+// send removes the page from the DayTripper and sends it to the receiver.
+func (p *PageHandle) send() {
+	p.dt.pageMutex.Lock()
+	if p.dt.pageMap[p.page.ID] == p {
+		delete(p.dt.pageMap, p.page.ID)
+	}
+	if p.autoKey != "" && p.dt.autoPages[p.autoKey] == p {
+		delete(p.dt.autoPages, p.autoKey)
+	}
+	p.dt.pageMutex.Unlock()
+
+	p.dt.emitPage(p.page)
+	close(p.done)
+}
+
+func pageHandleFromCtx(ctx context.Context) *PageHandle {
+	p, ok := ctx.Value(contextKeyPageHandle).(*PageHandle)
+	if !ok {
+		return nil
+	}
+
+	return p
+}
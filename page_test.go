@@ -0,0 +1,246 @@
+package daytripper_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+func TestPageHandleWaitsForEntries(t *testing.T) {
+	t.Parallel()
+
+	const bodyDelay = 50 * time.Millisecond
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(bodyDelay)
+		_, _ = w.Write([]byte("OK"))
+	}))
+	defer svr.Close()
+
+	recv := receiver.NewMemoryReceiver()
+	client := &http.Client{}
+	dt, err := daytripper.New(daytripper.WithReceiver(recv), daytripper.WithClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dt.Close() //nolint:errcheck
+
+	page := dt.StartPage(context.Background(), "Test Page")
+
+	req, _ := http.NewRequestWithContext(page.Context(), http.MethodGet, svr.URL, nil)
+	rsp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page.MarkContentLoaded()
+	page.End()
+
+	select {
+	case <-page.Done():
+		t.Fatal("page was sent before its entry finished")
+	default:
+	}
+
+	_, _ = io.ReadAll(rsp.Body)
+	_ = rsp.Body.Close()
+
+	select {
+	case <-page.Done():
+	case <-time.After(time.Second):
+		t.Fatal("page wasn't sent after its entry finished")
+	}
+
+	if len(recv.Pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(recv.Pages))
+	}
+	if len(recv.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(recv.Entries))
+	}
+
+	pg := recv.Pages[0]
+	if pg.ID != page.ID() || recv.Entries[0].PageRef != page.ID() {
+		t.Errorf("page ID = %q, entry PageRef = %q, want %q", pg.ID, recv.Entries[0].PageRef, page.ID())
+	}
+	if pg.Title != "Test Page" {
+		t.Errorf("Title = %q, want %q", pg.Title, "Test Page")
+	}
+	if time.Duration(pg.PageTimings.OnLoad) < bodyDelay {
+		t.Errorf("OnLoad = %v, want at least %v", time.Duration(pg.PageTimings.OnLoad), bodyDelay)
+	}
+	if pg.PageTimings.OnContentLoad <= 0 || pg.PageTimings.OnContentLoad > pg.PageTimings.OnLoad {
+		t.Errorf("OnContentLoad = %v, want between 0 and OnLoad", time.Duration(pg.PageTimings.OnContentLoad))
+	}
+}
+
+func TestPageHandleRequestAfterEnd(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("OK"))
+	}))
+	defer svr.Close()
+
+	recv := receiver.NewMemoryReceiver()
+	client := &http.Client{}
+	dt, err := daytripper.New(daytripper.WithReceiver(recv), daytripper.WithClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dt.Close() //nolint:errcheck
+
+	page := dt.StartPage(context.Background(), "Test Page")
+
+	do := func() *http.Response {
+		t.Helper()
+
+		req, _ := http.NewRequestWithContext(page.Context(), http.MethodGet, svr.URL, nil)
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rsp
+	}
+
+	// The second request starts after End while the first is still pending, the page waits for both.
+	first := do()
+	page.End()
+	second := do()
+
+	close(release)
+	_, _ = io.ReadAll(first.Body)
+	_ = first.Body.Close()
+
+	select {
+	case <-page.Done():
+		t.Fatal("page was sent before the request started after End finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	_, _ = io.ReadAll(second.Body)
+	_ = second.Body.Close()
+
+	select {
+	case <-page.Done():
+	case <-time.After(time.Second):
+		t.Fatal("page wasn't sent after its entries finished")
+	}
+
+	if len(recv.Entries) != 2 || recv.Entries[1].PageRef != page.ID() {
+		t.Errorf("got entries %+v, want both entries of the page before it was sent", recv.Entries)
+	}
+}
+
+func TestPageHandleEndWithoutEntries(t *testing.T) {
+	t.Parallel()
+
+	recv := receiver.NewMemoryReceiver()
+	dt, err := daytripper.New(daytripper.WithReceiver(recv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dt.Close() //nolint:errcheck
+
+	first := dt.StartPage(context.Background(), "First")
+	second := dt.StartPage(context.Background(), "Second")
+	if first.ID() == second.ID() {
+		t.Fatalf("pages share ID %q", first.ID())
+	}
+
+	first.End()
+	first.End()
+
+	select {
+	case <-first.Done():
+	default:
+		t.Fatal("page without entries wasn't sent on End")
+	}
+
+	if len(recv.Pages) != 1 {
+		t.Errorf("got %d pages, want 1", len(recv.Pages))
+	}
+}
+
+func TestPageHandleSentOnClose(t *testing.T) {
+	t.Parallel()
+
+	recv := receiver.NewMemoryReceiver()
+	dt, err := daytripper.New(daytripper.WithReceiver(recv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := dt.StartPage(context.Background(), "Never Ended")
+
+	if err := dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-page.Done():
+	default:
+		t.Fatal("open page wasn't sent on Close")
+	}
+
+	if len(recv.Pages) != 1 {
+		t.Errorf("got %d pages, want 1", len(recv.Pages))
+	}
+}
+
+func TestEndPageExcludedRequest(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	recv := receiver.NewMemoryReceiver()
+	client := &http.Client{}
+	dt, err := daytripper.New(
+		daytripper.WithReceiver(recv),
+		daytripper.WithClient(client),
+		daytripper.WithIncludeAll(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dt.Close() //nolint:errcheck
+
+	doRequest := func(ctx context.Context) {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, svr.URL, nil)
+		rsp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.ReadAll(rsp.Body)
+		_ = rsp.Body.Close()
+	}
+
+	doRequest(daytripper.IncludeContext(daytripper.StartPage(context.Background(), "page_1", "Test Page", "")))
+	// The request ending the page isn't recorded, but the page still should be.
+	doRequest(daytripper.EndPage(context.Background(), "page_1"))
+
+	if len(recv.Entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(recv.Entries))
+	}
+	if len(recv.Pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(recv.Pages))
+	}
+	if recv.Pages[0].PageTimings.OnLoad <= 0 {
+		t.Errorf("OnLoad = %v, want > 0", time.Duration(recv.Pages[0].PageTimings.OnLoad))
+	}
+}
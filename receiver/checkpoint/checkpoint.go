@@ -2,12 +2,15 @@ package checkpoint
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/swedishborgie/daytripper/encrypted"
 	"github.com/swedishborgie/daytripper/har"
 	"github.com/swedishborgie/daytripper/receiver"
 )
@@ -15,16 +18,64 @@ import (
 // Receiver wraps receiver.HARFileReceiver and adds a checkpointing mechanism. Instead of buffering all entries in
 // memory, it will write them to disk every so often with a given file pattern and flush them to disk when the
 // buffer reaches a certain size or duration.
+//
+// Closed files can be passed to a hook running in the background (see: WithRotateHook), e.g. to upload them.
+//
+// Compressing closed files (see: WithCompressRotated), updating the manifest and removing old files (see: WithMaxFiles)
+// run on a background worker, so they never hold up recording. Close waits for them to finish.
 type Receiver struct {
 	nextFile    FileNameGenerator
+	customNames bool
 	maxBytes    uint64
 	maxDuration time.Duration
 	version     *receiver.Version
 
+	compress         bool
+	compressionLevel int
+	compressedSize   bool
+	keys             encrypted.KeyProvider
+
+	maxFiles                int
+	maxTotalBytes           uint64
+	maxAge                  time.Duration
+	retentionPattern        string
+	compressRotated         bool
+	rotatedCompressionLevel int
+	manifestFile            string
+	pageAware               bool
+	pageAwareMaxDelay       time.Duration
+
 	mutex        sync.Mutex
 	currentBytes uint64
 	lastRoll     time.Time
 	harWriter    *receiver.HARFileReceiver
+	// current describes the file being written.
+	current ClosedFile
+	// manifest is loaded on first use when a manifest file is configured.
+	manifest *Manifest
+	// openPages are the pages referenced by the current file that haven't been received yet.
+	openPages map[string]struct{}
+	// pendingFiles are closed files waiting for pages.
+	pendingFiles   []*pendingFile
+	postponedSince time.Time
+	// unfinished are the closed files queued for finishing, they're never pruned.
+	unfinished map[string]struct{}
+	// housekeepingErrs are errors from compressing and pruning old files, they're returned from Close.
+	housekeepingErrs []error
+	// housekeeping runs finishing closed files and pruning old files in the background. The manifest is only used by
+	// its jobs.
+	housekeeping worker
+
+	rotateHook   RotateHook
+	hookAttempts int
+	hookBackoff  time.Duration
+	hookMutex    sync.Mutex
+	hookQueue    []ClosedFile
+	hookErrs     []error
+	hookStarted  bool
+	hookSignal   chan struct{}
+	hookStop     chan struct{}
+	hookWG       sync.WaitGroup
 }
 
 // FileNameGenerator is a function that returns a file name for the next HAR file. This should always return a new
@@ -36,12 +87,21 @@ func New(opts ...Option) *Receiver {
 		maxBytes:    10 * 1024 * 1024, // 10 MB
 		nextFile:    defaultFileNameGenerator(),
 		maxDuration: 0,
+
+		hookAttempts: 3,
+		hookBackoff:  time.Second,
+		hookSignal:   make(chan struct{}, 1),
+		hookStop:     make(chan struct{}),
 	}
 
 	for _, o := range opts {
 		o(r)
 	}
 
+	if r.retentionPattern == "" && !r.customNames {
+		r.retentionPattern = defaultRetentionPattern()
+	}
+
 	return r
 }
 
@@ -49,7 +109,12 @@ func (r *Receiver) Start(version *receiver.Version) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	if r.retentionEnabled() && r.retentionPattern == "" {
+		return errors.New("retention limits require WithRetentionPattern when a custom file name generator is used")
+	}
+
 	r.version = version
+	r.startHookWorker()
 
 	if r.harWriter == nil {
 		return nil
@@ -58,25 +123,55 @@ func (r *Receiver) Start(version *receiver.Version) error {
 	return r.harWriter.Start(version)
 }
 
+// Entry serializes the entry once, the serialized entry is used both to account for the size of the current file and
+// to write it. The mutex is held until the entry is written, so a concurrent rotation can't close the file first.
 func (r *Receiver) Entry(entry *har.Entry) error {
-	if err := r.rotateIfNeeded(entry); err != nil {
+	data, err := json.Marshal(entry)
+	if err != nil {
 		return err
 	}
 
-	return r.harWriter.Entry(entry)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.rotateIfNeeded(data); err != nil {
+		return err
+	}
+
+	r.current.add(entry)
+	r.trackPage(entry)
+
+	return r.harWriter.EntryJSON(data)
 }
 
+// Page serializes the page once, like Entry, the serialized page is used both to account for the size of the current
+// file and to write it.
 func (r *Receiver) Page(page *har.Page) {
-	if err := r.rotateIfNeeded(page); err != nil {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.pageAware && r.closePage(page, data) {
+		return
+	}
+
+	if err := r.rotateIfNeeded(data); err != nil {
 		return
 	}
 
 	if r.harWriter != nil {
-		r.harWriter.Page(page)
+		r.harWriter.PageJSON(data)
 	}
 }
 
 func (r *Receiver) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	if err := r.rotateIfNeeded(nil); err != nil {
 		return err
 	}
@@ -85,18 +180,37 @@ func (r *Receiver) Flush() error {
 }
 
 func (r *Receiver) Close() error {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+	var errs []error
 
-	if r.harWriter == nil {
-		return nil
+	r.mutex.Lock()
+	if r.harWriter != nil {
+		if err := r.harWriter.Close(); err != nil {
+			errs = append(errs, err)
+		} else if r.current.FileName != "" {
+			r.finishLater(r.current)
+			r.current = ClosedFile{}
+		}
 	}
+	r.finishPending()
+	r.mutex.Unlock()
 
-	return r.harWriter.Close()
+	// The jobs of the worker need the mutex.
+	r.housekeeping.wait()
+
+	r.mutex.Lock()
+	errs = append(errs, r.housekeepingErrs...)
+	r.housekeepingErrs = nil
+	r.mutex.Unlock()
+
+	errs = append(errs, r.stopHookWorker()...)
+
+	return errors.Join(errs...)
 }
 
-func (r *Receiver) rotateIfNeeded(toAdd any) error {
-	rotate, err := r.shouldRotate(toAdd)
+// rotateIfNeeded rotates the file if adding the serialized entry or page would exceed the limits. encoded is nil when
+// nothing is added. The caller must hold the mutex.
+func (r *Receiver) rotateIfNeeded(encoded []byte) error {
+	rotate, err := r.shouldRotate(encoded)
 	if err != nil {
 		return fmt.Errorf("failed to determine if we should rotate: %w", err)
 	}
@@ -108,10 +222,8 @@ func (r *Receiver) rotateIfNeeded(toAdd any) error {
 	return nil
 }
 
+// doRotate closes the current file and starts the next one. The caller must hold the mutex.
 func (r *Receiver) doRotate() error {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-
 	if r.harWriter != nil {
 		if err := r.harWriter.Close(); err != nil {
 			return fmt.Errorf("failed to close HAR file: %w", err)
@@ -126,34 +238,64 @@ func (r *Receiver) doRotate() error {
 		return fmt.Errorf("failed to get next file name: %w", err)
 	}
 
-	r.harWriter = receiver.NewHARFileReceiver(fileName)
+	var opts []receiver.HARFileOption
+	if r.compress {
+		if !strings.HasSuffix(fileName, ".gz") {
+			fileName += ".gz"
+		}
+		opts = append(opts, receiver.WithCompression(r.compressionLevel))
+	}
 
-	return r.harWriter.Start(r.version)
-}
+	if r.keys != nil {
+		if !strings.HasSuffix(fileName, ".enc") {
+			fileName += ".enc"
+		}
+		opts = append(opts, receiver.WithEncryption(r.keys))
+	}
 
-func (r *Receiver) shouldRotate(toAdd any) (bool, error) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+	closed := r.current
+	closedPages := r.openPages
+	r.current = ClosedFile{FileName: fileName}
+	r.openPages = nil
+	r.postponedSince = time.Time{}
+	r.harWriter = receiver.NewHARFileReceiver(fileName, opts...)
+
+	if err := r.harWriter.Start(r.version); err != nil {
+		return err
+	}
+
+	r.housekeep(closed, closedPages)
+
+	return nil
+}
 
+// shouldRotate accounts for the serialized entry or page and returns whether the file has to be rotated first. The
+// caller must hold the mutex.
+func (r *Receiver) shouldRotate(encoded []byte) (bool, error) {
 	if r.harWriter == nil {
 		return true, nil
 	}
 
-	if r.maxBytes > 0 && toAdd != nil {
-		sz, err := json.Marshal(toAdd)
-		if err != nil {
-			return false, fmt.Errorf("failed to get size of entry: %w", err)
+	if r.maxBytes > 0 && encoded != nil {
+		if r.compress && r.compressedSize {
+			// The file is measured before the entry is added, its compressed size isn't known until it's written.
+			size, err := r.harWriter.Size()
+			if err != nil {
+				return false, fmt.Errorf("failed to get compressed size of file: %w", err)
+			}
+			r.currentBytes = uint64(size)
+		} else {
+			r.currentBytes += uint64(len(encoded))
 		}
-		r.currentBytes += uint64(len(sz))
 
 		if r.currentBytes > r.maxBytes {
-			return true, nil
+			return !r.postponeRotation(), nil
 		}
 	}
 
 	if r.maxDuration > 0 {
 		if time.Since(r.lastRoll) > r.maxDuration {
-			return true, nil
+			return !r.postponeRotation(), nil
 		}
 	}
 
@@ -0,0 +1,56 @@
+package checkpoint_test
+
+import (
+	"compress/gzip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+)
+
+func benchmarkEntry(b *testing.B, opts ...checkpoint.Option) {
+	b.Helper()
+
+	recv := checkpoint.New(append([]checkpoint.Option{
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(b.TempDir(), "bench-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(64 * 1024 * 1024),
+	}, opts...)...)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		b.Fatal(err)
+	}
+
+	body := strings.Repeat("daytripper ", 10*1024)
+	entry := &har.Entry{
+		StartedDateTime: time.Now(),
+		Request:         &har.Request{Method: "GET", URL: "https://example.com/"},
+		Response: &har.Response{
+			Status:  200,
+			Content: &har.Content{Size: uint64(len(body)), MimeType: "text/plain", Text: body},
+		},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for b.Loop() {
+		if err := recv.Entry(entry); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.StopTimer()
+	if err := recv.Close(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkCheckpointEntry(b *testing.B) {
+	benchmarkEntry(b)
+}
+
+func BenchmarkCheckpointEntryCompressedSize(b *testing.B) {
+	benchmarkEntry(b, checkpoint.WithCompression(gzip.BestSpeed), checkpoint.WithCompressedSizeLimit(true))
+}
@@ -1,9 +1,14 @@
 package checkpoint_test
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -227,3 +232,127 @@ func TestReceiverWithMaxDuration(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckpointCompression(t *testing.T) {
+	t.Parallel()
+
+	// Highly compressible entries, so the compressed and uncompressed sizes differ a lot.
+	entry := &har.Entry{Comment: strings.Repeat("a", 1000)}
+
+	countFiles := func(t *testing.T, compressedLimit bool) []os.DirEntry {
+		t.Helper()
+
+		tmpDir := t.TempDir()
+		recv := checkpoint.New(
+			checkpoint.WithMaxBytes(5000),
+			checkpoint.WithCompression(gzip.DefaultCompression),
+			checkpoint.WithCompressedSizeLimit(compressedLimit),
+			checkpoint.WithFileNameGenerator(
+				checkpoint.TimestampFileGenerator(tmpDir, "test-", "2006-01-02_15-04-05.999"),
+			),
+		)
+		if err := recv.Start(&receiver.Version{}); err != nil {
+			t.Fatal(err)
+		}
+		for range 20 {
+			if err := recv.Entry(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := recv.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		files, err := os.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), ".har.gz") {
+				t.Errorf("file %s doesn't end in .har.gz", f.Name())
+			}
+
+			fp, err := os.Open(filepath.Join(tmpDir, f.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gz, err := gzip.NewReader(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive := &har.HTTPArchive{}
+			if err := json.NewDecoder(gz).Decode(archive); err != nil {
+				t.Errorf("file %s isn't a valid compressed HAR: %v", f.Name(), err)
+			}
+			_ = fp.Close()
+		}
+
+		return files
+	}
+
+	uncompressed := countFiles(t, false)
+	compressed := countFiles(t, true)
+
+	if len(uncompressed) < 4 {
+		t.Errorf("got %d files with an uncompressed limit, want at least 4", len(uncompressed))
+	}
+	if len(compressed) != 1 {
+		t.Errorf("got %d files with a compressed limit, want 1", len(compressed))
+	}
+}
+
+func TestCheckpointConcurrentRotation(t *testing.T) {
+	t.Parallel()
+
+	const (
+		writers = 8
+		entries = 200
+	)
+
+	tmpDir := t.TempDir()
+	recv := checkpoint.New(
+		checkpoint.WithMaxBytes(2000),
+		checkpoint.WithFileNameGenerator(
+			checkpoint.TimestampFileGenerator(tmpDir, "test-", "2006-01-02_15-04-05.999"),
+		),
+	)
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotations triggered by one writer must never close the file another writer is writing to.
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*entries)
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range entries {
+				if err := recv.Entry(&har.Entry{Comment: fmt.Sprintf("writer %d entry %d", w, i)}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, log := range readArchives(t, tmpDir) {
+		for _, entry := range log.Entries {
+			seen[entry.Comment] = true
+		}
+	}
+	if len(seen) != writers*entries {
+		t.Errorf("got %d entries, want %d", len(seen), writers*entries)
+	}
+}
@@ -0,0 +1,121 @@
+package checkpoint
+
+import (
+	"fmt"
+	"time"
+)
+
+// RotateHook is called for every closed file (see: WithRotateHook). If it returns an error it is retried.
+type RotateHook func(file ClosedFile) error
+
+// enqueueHook queues a closed file for the rotate hook.
+func (r *Receiver) enqueueHook(file ClosedFile) {
+	r.hookMutex.Lock()
+	r.hookQueue = append(r.hookQueue, file)
+	r.hookMutex.Unlock()
+
+	select {
+	case r.hookSignal <- struct{}{}:
+	default:
+		// The worker is already signalled.
+	}
+}
+
+// startHookWorker starts the background worker running the rotate hook, if there is one.
+func (r *Receiver) startHookWorker() {
+	r.hookMutex.Lock()
+	defer r.hookMutex.Unlock()
+
+	if r.rotateHook == nil || r.hookStarted {
+		return
+	}
+	r.hookStarted = true
+
+	r.hookWG.Add(1)
+	go r.hookWorker()
+}
+
+// stopHookWorker waits for all queued files to be passed to the rotate hook, and returns the errors of the hooks that
+// failed every attempt.
+func (r *Receiver) stopHookWorker() []error {
+	r.hookMutex.Lock()
+	started := r.hookStarted
+	r.hookStarted = false
+	r.hookMutex.Unlock()
+
+	if started {
+		close(r.hookStop)
+		r.hookWG.Wait()
+		r.hookStop = make(chan struct{})
+	}
+
+	r.hookMutex.Lock()
+	defer r.hookMutex.Unlock()
+
+	errs := r.hookErrs
+	r.hookErrs = nil
+
+	return errs
+}
+
+func (r *Receiver) hookWorker() {
+	defer r.hookWG.Done()
+
+	for {
+		select {
+		case <-r.hookSignal:
+			r.runHooks()
+		case <-r.hookStop:
+			// Drain files queued before stopping.
+			r.runHooks()
+			return
+		}
+	}
+}
+
+// runHooks passes every queued file to the rotate hook.
+func (r *Receiver) runHooks() {
+	for {
+		r.hookMutex.Lock()
+		if len(r.hookQueue) == 0 {
+			r.hookMutex.Unlock()
+			return
+		}
+		file := r.hookQueue[0]
+		r.hookQueue = r.hookQueue[1:]
+		r.hookMutex.Unlock()
+
+		if err := r.runHook(file); err != nil {
+			r.hookMutex.Lock()
+			r.hookErrs = append(r.hookErrs, err)
+			r.hookMutex.Unlock()
+		}
+	}
+}
+
+// runHook calls the rotate hook, retrying with exponential backoff until it succeeds or runs out of attempts. Retries
+// are given up once the worker is stopped, so Close doesn't wait out the backoff.
+func (r *Receiver) runHook(file ClosedFile) error {
+	backoff := r.hookBackoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = r.rotateHook(file); err == nil {
+			return nil
+		}
+
+		if attempt >= r.hookAttempts {
+			return fmt.Errorf("rotate hook failed for %q after %d attempts: %w", file.FileName, attempt, err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-r.hookStop:
+			timer.Stop()
+			return fmt.Errorf("rotate hook failed for %q after %d attempts, stopped retrying on Close: %w",
+				file.FileName, attempt, err)
+		}
+		backoff *= 2
+	}
+}
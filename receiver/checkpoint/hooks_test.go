@@ -0,0 +1,217 @@
+package checkpoint_test
+
+import (
+	"compress/gzip"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+)
+
+func TestCheckpointRotateHook(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mutex sync.Mutex
+		files []checkpoint.ClosedFile
+	)
+
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(t.TempDir(), "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(1),
+		checkpoint.WithCompressRotated(gzip.BestSpeed),
+		checkpoint.WithRotateHook(func(file checkpoint.ClosedFile) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			files = append(files, file)
+			return nil
+		}),
+	)
+
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range 3 {
+		entry := &har.Entry{
+			StartedDateTime: start.Add(time.Duration(i) * time.Minute),
+			Time:            har.DurationMS(time.Second),
+		}
+		if err := recv.Entry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(files) != 3 {
+		t.Fatalf("hook called %d times, want 3", len(files))
+	}
+
+	for i, file := range files {
+		if !strings.HasSuffix(file.FileName, ".har.gz") {
+			t.Errorf("file %d: got name %q, want the compressed file", i, file.FileName)
+		}
+
+		info, err := os.Stat(file.FileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Size != info.Size() {
+			t.Errorf("file %d: got size %d, want %d", i, file.Size, info.Size())
+		}
+
+		if file.Entries != 1 {
+			t.Errorf("file %d: got %d entries, want 1", i, file.Entries)
+		}
+
+		wantStart := start.Add(time.Duration(i) * time.Minute)
+		if !file.Start.Equal(wantStart) || !file.End.Equal(wantStart.Add(time.Second)) {
+			t.Errorf("file %d: got time range %v - %v, want %v - %v", i, file.Start, file.End, wantStart,
+				wantStart.Add(time.Second))
+		}
+	}
+}
+
+func TestCheckpointRotateHookRetry(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mutex    sync.Mutex
+		attempts int
+	)
+
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(t.TempDir(), "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(1),
+		checkpoint.WithRotateHookRetry(3, time.Millisecond),
+		checkpoint.WithRotateHook(func(checkpoint.ClosedFile) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			attempts++
+			if attempts < 3 {
+				return errors.New("upload failed")
+			}
+			return nil
+		}),
+	)
+
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close() //nolint:errcheck
+
+	// The second entry rotates the first file, its hook is retried in the background.
+	for range 2 {
+		if err := recv.Entry(&har.Entry{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		got := attempts
+		mutex.Unlock()
+
+		if got >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d attempts, want 3", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckpointRotateHookFailure(t *testing.T) {
+	t.Parallel()
+
+	var attempts atomic.Int32
+	hookErr := errors.New("upload failed")
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(t.TempDir(), "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(1),
+		checkpoint.WithRotateHookRetry(2, time.Millisecond),
+		checkpoint.WithRotateHook(func(checkpoint.ClosedFile) error {
+			attempts.Add(1)
+			return hookErr
+		}),
+	)
+
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := recv.Entry(&har.Entry{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Wait for the hook of the rotated file to run out of attempts.
+	deadline := time.Now().Add(5 * time.Second)
+	for attempts.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d attempts, want 2", attempts.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	err := recv.Close()
+	if !errors.Is(err, hookErr) {
+		t.Fatalf("Close: got %v, want the hook error", err)
+	}
+	if !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("error = %q, want it to contain the number of attempts", err.Error())
+	}
+}
+
+func TestCheckpointRotateHookCloseStopsRetries(t *testing.T) {
+	t.Parallel()
+
+	hookErr := errors.New("upload failed")
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(t.TempDir(), "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithRotateHookRetry(3, time.Hour),
+		checkpoint.WithRotateHook(func(checkpoint.ClosedFile) error {
+			return hookErr
+		}),
+	)
+
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Entry(&har.Entry{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close must not wait out the hour between attempts.
+	done := make(chan error, 1)
+	go func() {
+		done <- recv.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, hookErr) {
+			t.Errorf("Close: got %v, want the hook error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the retry backoff")
+	}
+}
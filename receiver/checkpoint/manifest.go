@@ -0,0 +1,298 @@
+package checkpoint
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/swedishborgie/daytripper/encrypted"
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/internal/atomicfile"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// ClosedFile describes a file that was closed, either by a rotation or by Close. It's also the record kept for each
+// file in the manifest (see: WithManifest).
+type ClosedFile struct {
+	// FileName is the name of the file, including ".gz" if it was compressed after closing (see: WithCompressRotated).
+	// In the manifest file names are relative to the directory of the manifest.
+	FileName string `json:"fileName"`
+	// Entries is the number of entries in the file.
+	Entries int `json:"entries"`
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+	// Start is the earliest start time of the entries in the file, zero if there are no entries.
+	Start time.Time `json:"start"`
+	// End is the latest end time (start time plus total time) of the entries in the file, zero if there are no
+	// entries.
+	End time.Time `json:"end"`
+	// Pages are the IDs of the pages referenced by the entries in the file.
+	Pages []string `json:"pages,omitempty"`
+	// Hosts are the host names of the requests in the file.
+	Hosts []string `json:"hosts,omitempty"`
+	// StatusCodes maps the response status codes in the file to the number of entries with that status code.
+	StatusCodes map[int]int `json:"statusCodes,omitempty"`
+}
+
+// add updates the metadata with an entry written to the file.
+func (c *ClosedFile) add(entry *har.Entry) {
+	c.Entries++
+
+	summary := receiver.SummarizeEntry(entry)
+	if c.Start.IsZero() || summary.Start.Before(c.Start) {
+		c.Start = summary.Start
+	}
+	if summary.End.After(c.End) {
+		c.End = summary.End
+	}
+
+	if summary.PageRef != "" && !slices.Contains(c.Pages, summary.PageRef) {
+		c.Pages = append(c.Pages, summary.PageRef)
+	}
+
+	if summary.Host != "" && !slices.Contains(c.Hosts, summary.Host) {
+		c.Hosts = append(c.Hosts, summary.Host)
+	}
+
+	if entry.Response != nil {
+		if c.StatusCodes == nil {
+			c.StatusCodes = make(map[int]int)
+		}
+		c.StatusCodes[entry.Response.Status]++
+	}
+}
+
+// Manifest is an index of the files written by one or more Receivers sharing a directory, see WithManifest.
+type Manifest struct {
+	// Files contains a record for every closed file, in the order they were closed.
+	Files []ClosedFile `json:"files"`
+	// Keys decrypts files ending in ".enc" when loading them (see: WithEncryption).
+	Keys encrypted.KeyProvider `json:"-"`
+
+	// dir is the directory of the manifest, file names are relative to it.
+	dir string
+}
+
+// Query selects files from a Manifest and entries from those files. Zero fields match everything.
+type Query = receiver.Query
+
+// matchesFile returns whether a file might contain entries matching the query.
+func matchesFile(q *Query, file *ClosedFile) bool {
+	if file.Entries == 0 || !q.Overlaps(file.Start, file.End) {
+		return false
+	}
+	if q.PageID != "" && !slices.Contains(file.Pages, q.PageID) {
+		return false
+	}
+	if q.Host != "" && !slices.Contains(file.Hosts, q.Host) {
+		return false
+	}
+	if q.StatusCode != 0 && file.StatusCodes[q.StatusCode] == 0 {
+		return false
+	}
+
+	return true
+}
+
+// ReadManifest reads a manifest written by a Receiver.
+func ReadManifest(fileName string) (*Manifest, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{dir: filepath.Dir(fileName)}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %q: %w", fileName, err)
+	}
+
+	return m, nil
+}
+
+// Find returns the files that might contain entries matching the query. The file names are resolved relative to the
+// directory of the manifest.
+func (m *Manifest) Find(q Query) []ClosedFile {
+	var files []ClosedFile
+	for _, file := range m.Files {
+		if !matchesFile(&q, &file) {
+			continue
+		}
+
+		file.FileName = filepath.Join(m.dir, file.FileName)
+		files = append(files, file)
+	}
+
+	return files
+}
+
+// Load reads only the files that might contain entries matching the query, and returns a HAR document with the
+// matching entries and the pages they reference.
+func (m *Manifest) Load(q Query) (*har.HTTPArchive, error) {
+	log := &har.Log{
+		Creator: &har.Agent{},
+		Pages:   make([]*har.Page, 0),
+		Entries: make([]*har.Entry, 0),
+	}
+
+	pageRefs := make(map[string]struct{})
+	pages := make(map[string]*har.Page)
+
+	for _, file := range m.Find(q) {
+		archive, err := readHARFile(file.FileName, m.Keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %w", file.FileName, err)
+		}
+		if archive.Log == nil {
+			continue
+		}
+
+		if log.Version == "" {
+			log.Version = archive.Log.Version
+			if archive.Log.Creator != nil {
+				log.Creator = archive.Log.Creator
+			}
+		}
+
+		for _, entry := range archive.Log.Entries {
+			summary := receiver.SummarizeEntry(entry)
+			if !q.Matches(&summary) {
+				continue
+			}
+
+			log.Entries = append(log.Entries, entry)
+			if entry.PageRef != "" {
+				pageRefs[entry.PageRef] = struct{}{}
+			}
+		}
+
+		for _, page := range archive.Log.Pages {
+			if _, ok := pages[page.ID]; !ok {
+				pages[page.ID] = page
+			}
+		}
+	}
+
+	for _, entry := range log.Entries {
+		if _, ok := pageRefs[entry.PageRef]; !ok {
+			continue
+		}
+		delete(pageRefs, entry.PageRef)
+
+		if page, ok := pages[entry.PageRef]; ok {
+			log.Pages = append(log.Pages, page)
+		}
+	}
+
+	return &har.HTTPArchive{Log: log}, nil
+}
+
+// readHARFile reads a HAR file, decrypting it if its name ends with ".enc" and decompressing it if its name ends with
+// ".gz" (before ".enc").
+func readHARFile(fileName string, keys encrypted.KeyProvider) (*har.HTTPArchive, error) {
+	fp, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close() //nolint:errcheck
+
+	var r io.Reader = fp
+	name := fileName
+	if strings.HasSuffix(name, ".enc") {
+		if keys == nil {
+			return nil, errors.New("file is encrypted but Manifest.Keys isn't set")
+		}
+		r = encrypted.NewReader(fp, keys)
+		name = strings.TrimSuffix(name, ".enc")
+	}
+
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close() //nolint:errcheck
+		r = gz
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.NewDecoder(r).Decode(archive); err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// loadManifest reads the manifest on first use. Records of files that no longer exist are dropped. If the manifest
+// can't be read a new one is started. It runs on the housekeeping worker.
+func (r *Receiver) loadManifest() error {
+	if r.manifest != nil {
+		return nil
+	}
+
+	r.manifest = &Manifest{dir: filepath.Dir(r.manifestFile)}
+
+	existing, err := ReadManifest(r.manifestFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range existing.Files {
+		if _, err := os.Stat(filepath.Join(r.manifest.dir, file.FileName)); err == nil {
+			r.manifest.Files = append(r.manifest.Files, file)
+		}
+	}
+
+	return nil
+}
+
+// addToManifest adds a record for a closed file to the manifest and writes it. It runs on the housekeeping worker.
+func (r *Receiver) addToManifest(file ClosedFile) error {
+	loadErr := r.loadManifest()
+
+	if rel, err := filepath.Rel(r.manifest.dir, file.FileName); err == nil {
+		file.FileName = rel
+	}
+	r.manifest.Files = append(r.manifest.Files, file)
+
+	return errors.Join(loadErr, r.writeManifest())
+}
+
+// removeFromManifest removes the records of removed files from the manifest and writes it. It runs on the
+// housekeeping worker.
+func (r *Receiver) removeFromManifest(removed []string) error {
+	if len(removed) == 0 {
+		return nil
+	}
+
+	loadErr := r.loadManifest()
+
+	r.manifest.Files = slices.DeleteFunc(r.manifest.Files, func(file ClosedFile) bool {
+		return slices.ContainsFunc(removed, func(name string) bool {
+			return filepath.Clean(name) == filepath.Clean(filepath.Join(r.manifest.dir, file.FileName))
+		})
+	})
+
+	return errors.Join(loadErr, r.writeManifest())
+}
+
+// writeManifest atomically replaces the manifest, so readers never see a partially written manifest, even after a
+// power loss. It runs on the housekeeping worker.
+func (r *Receiver) writeManifest() error {
+	data, err := json.MarshalIndent(r.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(r.manifestFile, data, 0o644)
+}
@@ -0,0 +1,203 @@
+package checkpoint_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/encrypted"
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+)
+
+func writeManifestEntries(t *testing.T, dir, prefix string, opts []checkpoint.Option, entries ...*har.Entry) {
+	t.Helper()
+
+	recv := checkpoint.New(append([]checkpoint.Option{
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, prefix, "2006-01-02_15-04-05.999")),
+		checkpoint.WithRetentionPattern(checkpoint.TimestampFilePattern(dir, "test-")),
+		checkpoint.WithManifest(filepath.Join(dir, "manifest.json")),
+		checkpoint.WithMaxBytes(1),
+	}, opts...)...)
+
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := recv.Entry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func manifestEntry(start time.Time, url, pageRef string, status int) *har.Entry {
+	return &har.Entry{
+		PageRef:         pageRef,
+		StartedDateTime: start,
+		Time:            har.DurationMS(time.Second),
+		Request:         &har.Request{URL: url},
+		Response:        &har.Response{Status: status},
+	}
+}
+
+func TestCheckpointManifest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeManifestEntries(t, dir, "test-", nil,
+		manifestEntry(start, "https://a.example/1", "page_1", 200),
+		manifestEntry(start.Add(time.Hour), "https://b.example/2", "page_2", 500),
+		manifestEntry(start.Add(2*time.Hour), "https://a.example/3", "page_2", 404),
+	)
+
+	manifest, err := checkpoint.ReadManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Files) != 3 {
+		t.Fatalf("got %d files in the manifest, want 3", len(manifest.Files))
+	}
+	first := manifest.Files[0]
+	if first.Entries != 1 || !first.Start.Equal(start) || first.Hosts[0] != "a.example" || first.Pages[0] != "page_1" ||
+		first.StatusCodes[200] != 1 || first.Size == 0 {
+		t.Errorf("unexpected record: %+v", first)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query checkpoint.Query
+		want  []string
+	}{
+		{name: "all", query: checkpoint.Query{}, want: []string{"/1", "/2", "/3"}},
+		{name: "host", query: checkpoint.Query{Host: "a.example"}, want: []string{"/1", "/3"}},
+		{name: "page", query: checkpoint.Query{PageID: "page_2"}, want: []string{"/2", "/3"}},
+		{name: "status", query: checkpoint.Query{StatusCode: 500}, want: []string{"/2"}},
+		{
+			name:  "time",
+			query: checkpoint.Query{From: start.Add(30 * time.Minute), To: start.Add(90 * time.Minute)},
+			want:  []string{"/2"},
+		},
+		{name: "no match", query: checkpoint.Query{Host: "c.example"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := len(manifest.Find(tc.query)); got != len(tc.want) {
+				t.Errorf("Find: got %d files, want %d", got, len(tc.want))
+			}
+
+			archive, err := manifest.Load(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(archive.Log.Entries) != len(tc.want) {
+				t.Fatalf("Load: got %d entries, want %d", len(archive.Log.Entries), len(tc.want))
+			}
+			for i, entry := range archive.Log.Entries {
+				if !strings.HasSuffix(entry.Request.URL, tc.want[i]) {
+					t.Errorf("entry %d: got %s, want path %s", i, entry.Request.URL, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckpointManifestRetention(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := []checkpoint.Option{checkpoint.WithMaxFiles(3)}
+
+	// The second run keeps adding to the manifest of the first, and pruning removes the records of removed files.
+	writeManifestEntries(t, dir, "test-a-", opts,
+		manifestEntry(start, "https://a.example/1", "", 200),
+		manifestEntry(start, "https://a.example/2", "", 200),
+	)
+	writeManifestEntries(t, dir, "test-b-", opts,
+		manifestEntry(start, "https://a.example/3", "", 200),
+		manifestEntry(start, "https://a.example/4", "", 200),
+	)
+
+	manifest, err := checkpoint.ReadManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := manifest.Load(checkpoint.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, entry := range archive.Log.Entries {
+		got = append(got, entry.Request.URL)
+	}
+	want := []string{"https://a.example/2", "https://a.example/3", "https://a.example/4"}
+	if len(got) != len(want) {
+		t.Fatalf("got entries %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got entries %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestCheckpointManifestEncrypted(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	keys := encrypted.StaticKey("test", bytes.Repeat([]byte{1}, 32))
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeManifestEntries(t, dir, "test-", []checkpoint.Option{
+		checkpoint.WithEncryption(keys),
+		checkpoint.WithCompression(gzip.BestSpeed),
+		checkpoint.WithCompressRotated(gzip.BestSpeed),
+	},
+		manifestEntry(start, "https://a.example/secret", "", 200),
+		manifestEntry(start.Add(time.Hour), "https://b.example/secret", "", 200),
+	)
+
+	manifest, err := checkpoint.ReadManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range manifest.Find(checkpoint.Query{}) {
+		if !strings.HasSuffix(file.FileName, ".har.gz.enc") {
+			t.Errorf("got file %q, want an encrypted file that wasn't compressed again", file.FileName)
+		}
+
+		data, err := os.ReadFile(file.FileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret")) || !bytes.HasPrefix(data, []byte("DTENC")) {
+			t.Errorf("%q isn't encrypted", file.FileName)
+		}
+	}
+
+	if _, err := manifest.Load(checkpoint.Query{}); err == nil {
+		t.Error("Load without keys: expected error, got nil")
+	}
+
+	manifest.Keys = keys
+	archive, err := manifest.Load(checkpoint.Query{Host: "b.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 1 || archive.Log.Entries[0].Request.URL != "https://b.example/secret" {
+		t.Errorf("unexpected entries: %+v", archive.Log.Entries)
+	}
+}
@@ -1,14 +1,20 @@
 package checkpoint
 
-import "time"
+import (
+	"time"
+
+	"github.com/swedishborgie/daytripper/encrypted"
+)
 
 type Option func(r *Receiver)
 
 // WithFileNameGenerator sets the file name generator to use. You can use this to change the naming scheme of the
-// files.
+// files. Retention limits (see: WithMaxFiles) then require a retention pattern matching the generated names (see:
+// WithRetentionPattern).
 func WithFileNameGenerator(nextFile FileNameGenerator) Option {
 	return func(r *Receiver) {
 		r.nextFile = nextFile
+		r.customNames = true
 	}
 }
 
@@ -27,3 +33,125 @@ func WithMaxDuration(maxDuration time.Duration) Option {
 		r.maxDuration = maxDuration
 	}
 }
+
+// WithCompression gzip compresses every file with the given compression level (e.g. gzip.DefaultCompression). ".gz"
+// is appended to generated file names that don't already end with it.
+func WithCompression(level int) Option {
+	return func(r *Receiver) {
+		r.compress = true
+		r.compressionLevel = level
+	}
+}
+
+// WithEncryption encrypts every file with keys from the given provider (see: package encrypted). ".enc" is appended to
+// generated file names that don't already end with it. Encrypted files aren't compressed after rotation (see:
+// WithCompressRotated) since encrypted data doesn't compress, use WithCompression instead. Set Manifest.Keys to load
+// encrypted files through a manifest.
+func WithEncryption(keys encrypted.KeyProvider) Option {
+	return func(r *Receiver) {
+		r.keys = keys
+	}
+}
+
+// WithCompressedSizeLimit sets whether WithMaxBytes limits the compressed size of each file rather than the
+// uncompressed size. This only applies when WithCompression is used. The size is measured on the compressed output as
+// it's written to the file. Since the compressor buffers data before producing output, files may be slightly larger
+// than the limit.
+func WithCompressedSizeLimit(compressed bool) Option {
+	return func(r *Receiver) {
+		r.compressedSize = compressed
+	}
+}
+
+// WithMaxFiles sets the maximum number of files to keep, including the file currently being written. After every
+// rotation the oldest files matching the retention pattern (see: WithRetentionPattern) are removed until the limit is
+// met. If set to 0, files are never removed based on their count.
+func WithMaxFiles(maxFiles int) Option {
+	return func(r *Receiver) {
+		r.maxFiles = maxFiles
+	}
+}
+
+// WithMaxTotalBytes sets the maximum combined size of all files matching the retention pattern (see:
+// WithRetentionPattern). After every rotation the oldest files are removed until the limit is met, the file currently
+// being written is never removed. If set to 0, files are never removed based on their size.
+func WithMaxTotalBytes(maxTotalBytes uint64) Option {
+	return func(r *Receiver) {
+		r.maxTotalBytes = maxTotalBytes
+	}
+}
+
+// WithMaxAge sets the maximum age of files matching the retention pattern (see: WithRetentionPattern), based on their
+// modification time. Older files are removed after every rotation. If set to 0, files are never removed based on their
+// age.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(r *Receiver) {
+		r.maxAge = maxAge
+	}
+}
+
+// WithRetentionPattern sets the glob pattern (see: filepath.Match) of the files the retention limits apply to. Files
+// left over by earlier runs are included if they match. This defaults to the files created by the default file name
+// generator. When a custom generator is used (see: WithFileNameGenerator) there is no default, and Start fails if
+// retention limits are set without a pattern. Use TimestampFilePattern to get the pattern for a
+// TimestampFileGenerator.
+func WithRetentionPattern(pattern string) Option {
+	return func(r *Receiver) {
+		r.retentionPattern = pattern
+	}
+}
+
+// WithCompressRotated gzip compresses every file with the given compression level (e.g. gzip.DefaultCompression)
+// once it has been closed, and appends ".gz" to its name. Unlike WithCompression, files are written uncompressed and
+// only compressed after rotation, which is slower but produces smaller files.
+func WithCompressRotated(level int) Option {
+	return func(r *Receiver) {
+		r.compressRotated = true
+		r.rotatedCompressionLevel = level
+	}
+}
+
+// WithRotateHook sets a hook that is called for every file after it has been closed, either by a rotation or by
+// Close, e.g. to upload it to object storage or move it to an archive directory. The hook runs on a background worker
+// one file at a time, and is retried if it returns an error (see: WithRotateHookRetry). Close waits for all closed
+// files to be passed to the hook, and returns the errors of hooks that failed. Once Close is called failed hooks
+// aren't retried anymore, so Close never waits out the retry delays.
+//
+// Retention limits (see: WithMaxFiles) may remove a file before its hook has run, so a hook that moves files out of
+// the way should be combined with a retention pattern that doesn't match the moved files.
+func WithRotateHook(hook RotateHook) Option {
+	return func(r *Receiver) {
+		r.rotateHook = hook
+	}
+}
+
+// WithRotateHookRetry sets the number of times the rotate hook is attempted for each file, and the delay before the
+// first retry. The delay doubles after every retry. Defaults to 3 attempts, starting with a 1 second delay.
+func WithRotateHookRetry(attempts int, backoff time.Duration) Option {
+	return func(r *Receiver) {
+		r.hookAttempts = max(attempts, 1)
+		r.hookBackoff = backoff
+	}
+}
+
+// WithManifest keeps a manifest at the given path, a JSON index with a record for every closed file containing its
+// time span, entry count, page IDs, hosts and status codes. Records are added when files are closed and removed when
+// files are pruned (see: WithMaxFiles). Use ReadManifest to query the files. File names in the manifest are relative to
+// its directory, so it should be in the same directory as the files.
+func WithManifest(fileName string) Option {
+	return func(r *Receiver) {
+		r.manifestFile = fileName
+	}
+}
+
+// WithPageAwareRotation postpones rotations while the current file has entries referencing pages that haven't been
+// received yet, so pages end up in the same file as their entries. A rotation is postponed for at most maxDelay after
+// it became due. When a page is split across files anyway, it is copied in the background into every file with
+// entries referencing it, and those files are only compressed, added to the manifest and passed to the rotate hook
+// once all of their pages have been received, or on Close.
+func WithPageAwareRotation(maxDelay time.Duration) Option {
+	return func(r *Receiver) {
+		r.pageAware = true
+		r.pageAwareMaxDelay = maxDelay
+	}
+}
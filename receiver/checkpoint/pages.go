@@ -0,0 +1,126 @@
+package checkpoint
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// pendingFile is a closed file with entries referencing pages that haven't been received yet (see:
+// WithPageAwareRotation). It's finished once all of its pages have been copied into it.
+type pendingFile struct {
+	file      ClosedFile
+	openPages map[string]struct{}
+}
+
+// trackPage records that the current file references the page of an entry. The caller must hold the mutex.
+func (r *Receiver) trackPage(entry *har.Entry) {
+	if !r.pageAware || entry.PageRef == "" {
+		return
+	}
+
+	if r.openPages == nil {
+		r.openPages = make(map[string]struct{})
+	}
+	r.openPages[entry.PageRef] = struct{}{}
+}
+
+// postponeRotation returns whether a rotation that is due should be postponed because the current file references
+// pages that haven't been received yet. Rotations are postponed for at most the configured delay. The caller must hold
+// the mutex.
+func (r *Receiver) postponeRotation() bool {
+	if !r.pageAware || len(r.openPages) == 0 {
+		return false
+	}
+
+	if r.postponedSince.IsZero() {
+		r.postponedSince = time.Now()
+	}
+
+	return time.Since(r.postponedSince) < r.pageAwareMaxDelay
+}
+
+// closePage writes a serialized page to the current file and queues copying it into the closed files that have
+// entries referencing it. Closed files are finished once all of their pages have been copied. It returns false if no
+// file references the page. The caller must hold the mutex.
+func (r *Receiver) closePage(page *har.Page, data []byte) bool {
+	_, found := r.openPages[page.ID]
+	if found {
+		delete(r.openPages, page.ID)
+		r.harWriter.PageJSON(data)
+	}
+
+	finished := false
+	remaining := r.pendingFiles[:0]
+	for _, pf := range r.pendingFiles {
+		if _, ok := pf.openPages[page.ID]; ok {
+			found = true
+			delete(pf.openPages, page.ID)
+
+			r.appendPageLater(pf.file.FileName, page)
+		}
+
+		if len(pf.openPages) > 0 {
+			remaining = append(remaining, pf)
+			continue
+		}
+
+		r.finishLater(pf.file)
+		finished = true
+	}
+	r.pendingFiles = remaining
+
+	if finished {
+		r.pruneLater()
+	}
+
+	return found
+}
+
+// appendPageLater queues copying a page into a closed file on the housekeeping worker, so reopening the file never
+// holds up recording. The file is finished by a job queued after this one. The caller must hold the mutex.
+func (r *Receiver) appendPageLater(fileName string, page *har.Page) {
+	version := r.version
+
+	r.housekeeping.enqueue(func() {
+		if err := r.appendPage(fileName, version, page); err != nil {
+			r.mutex.Lock()
+			r.housekeepingErrs = append(r.housekeepingErrs, err)
+			r.mutex.Unlock()
+		}
+	})
+}
+
+// appendPage copies a page into a closed file. It runs on the housekeeping worker.
+func (r *Receiver) appendPage(fileName string, version *receiver.Version, page *har.Page) error {
+	opts := []receiver.HARFileOption{receiver.WithAppend()}
+	if r.compress {
+		opts = append(opts, receiver.WithCompression(r.compressionLevel))
+	}
+	if r.keys != nil {
+		opts = append(opts, receiver.WithEncryption(r.keys))
+	}
+
+	w := receiver.NewHARFileReceiver(fileName, opts...)
+	if err := w.Start(version); err != nil {
+		return fmt.Errorf("failed to copy page %q into %q: %w", page.ID, fileName, err)
+	}
+
+	w.Page(page)
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to copy page %q into %q: %w", page.ID, fileName, err)
+	}
+
+	return nil
+}
+
+// finishPending queues finishing the closed files that are still waiting for pages. The caller must hold the mutex.
+func (r *Receiver) finishPending() {
+	for _, pf := range r.pendingFiles {
+		r.finishLater(pf.file)
+	}
+	r.pendingFiles = nil
+}
@@ -0,0 +1,206 @@
+package checkpoint_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+)
+
+func readArchives(t *testing.T, dir string) []*har.Log {
+	t.Helper()
+
+	var logs []*har.Log
+	for _, name := range listFiles(t, dir) {
+		fp, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r io.Reader = fp
+		if strings.HasSuffix(name, ".gz") {
+			gz, err := gzip.NewReader(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+
+		archive := &har.HTTPArchive{}
+		if err := json.NewDecoder(r).Decode(archive); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		_ = fp.Close()
+
+		logs = append(logs, archive.Log)
+	}
+
+	return logs
+}
+
+func TestCheckpointPageAwareRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(1),
+		checkpoint.WithPageAwareRotation(time.Hour),
+	)
+
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rotation is postponed until page_1 has been received.
+	for _, entry := range []*har.Entry{{PageRef: "page_1"}, {PageRef: "page_1"}} {
+		if err := recv.Entry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv.Page(&har.Page{ID: "page_1"})
+
+	if err := recv.Entry(&har.Entry{PageRef: "page_2"}); err != nil {
+		t.Fatal(err)
+	}
+	recv.Page(&har.Page{ID: "page_2"})
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := readArchives(t, dir)
+	if len(logs) != 2 {
+		t.Fatalf("got %d files, want 2", len(logs))
+	}
+	if len(logs[0].Entries) != 2 || len(logs[0].Pages) != 1 || logs[0].Pages[0].ID != "page_1" {
+		t.Errorf("first file: got %d entries and pages %v", len(logs[0].Entries), logs[0].Pages)
+	}
+	if len(logs[1].Entries) != 1 || len(logs[1].Pages) != 1 || logs[1].Pages[0].ID != "page_2" {
+		t.Errorf("second file: got %d entries and pages %v", len(logs[1].Entries), logs[1].Pages)
+	}
+}
+
+func TestCheckpointPageAwareRotationSplit(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		opts []checkpoint.Option
+	}{
+		{name: "plain"},
+		{name: "compressed", opts: []checkpoint.Option{checkpoint.WithCompression(gzip.BestSpeed)}},
+		{name: "compressed after rotation", opts: []checkpoint.Option{checkpoint.WithCompressRotated(gzip.BestSpeed)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mutex  sync.Mutex
+				hooked []string
+			)
+
+			dir := t.TempDir()
+			recv := checkpoint.New(append([]checkpoint.Option{
+				checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, "test-", "2006-01-02_15-04-05.999")),
+				checkpoint.WithMaxBytes(1),
+				// Rotations can't be postponed, so the page is split across both files.
+				checkpoint.WithPageAwareRotation(0),
+				checkpoint.WithRotateHook(func(file checkpoint.ClosedFile) error {
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					hooked = append(hooked, file.FileName)
+					return nil
+				}),
+			}, tc.opts...)...)
+
+			if err := recv.Start(&receiver.Version{}); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, entry := range []*har.Entry{{PageRef: "page_1"}, {PageRef: "page_1"}} {
+				if err := recv.Entry(entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The first file is only finished once its page has been copied into it.
+			time.Sleep(10 * time.Millisecond)
+			mutex.Lock()
+			if len(hooked) != 0 {
+				t.Errorf("files %v were finished before their page was received", hooked)
+			}
+			mutex.Unlock()
+
+			recv.Page(&har.Page{ID: "page_1"})
+
+			if err := recv.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			logs := readArchives(t, dir)
+			if len(logs) != 2 {
+				t.Fatalf("got %d files, want 2", len(logs))
+			}
+			for i, log := range logs {
+				if len(log.Entries) != 1 || len(log.Pages) != 1 || log.Pages[0].ID != "page_1" {
+					t.Errorf("file %d: got %d entries and pages %v", i, len(log.Entries), log.Pages)
+				}
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if len(hooked) != 2 {
+				t.Errorf("got hooks for %v, want both files", hooked)
+			}
+		})
+	}
+}
+
+func TestCheckpointPageAwareRotationCopyError(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(1),
+		checkpoint.WithPageAwareRotation(0),
+	)
+
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []*har.Entry{{PageRef: "page_1"}, {PageRef: "page_1"}} {
+		if err := recv.Entry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Corrupt the closed file waiting for the page, copying the page into it in the background fails.
+	files := listFiles(t, dir)
+	if len(files) != 2 {
+		t.Fatalf("got files %v, want 2", files)
+	}
+	if err := os.WriteFile(filepath.Join(dir, files[0]), []byte("not a HAR file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recv.Page(&har.Page{ID: "page_1"})
+
+	err := recv.Close()
+	if err == nil || !strings.Contains(err.Error(), `failed to copy page "page_1"`) {
+		t.Errorf("Close: got %v, want the error copying the page", err)
+	}
+}
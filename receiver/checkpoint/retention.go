@@ -0,0 +1,245 @@
+package checkpoint
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/swedishborgie/daytripper/internal/atomicfile"
+)
+
+// TimestampFilePattern returns a glob pattern matching the files created by a TimestampFileGenerator with the same
+// base path and prefix, including compressed files. It can be used with WithRetentionPattern.
+func TimestampFilePattern(basePath, prefix string) string {
+	return filepath.Join(basePath, prefix+"*.har*")
+}
+
+func defaultRetentionPattern() string {
+	return TimestampFilePattern(".", "daytripper-")
+}
+
+// retentionEnabled returns whether any old files may need to be pruned.
+func (r *Receiver) retentionEnabled() bool {
+	return r.maxFiles > 0 || r.maxTotalBytes > 0 || r.maxAge > 0
+}
+
+// housekeep queues finishing the file that was closed by a rotation and pruning old files. If the file references
+// pages that haven't been received yet, it's finished once they have been copied into it. Errors are kept and returned
+// from Close, so they never cause entries to be dropped. The caller must hold the mutex.
+func (r *Receiver) housekeep(closed ClosedFile, openPages map[string]struct{}) {
+	if closed.FileName != "" && len(openPages) > 0 {
+		r.pendingFiles = append(r.pendingFiles, &pendingFile{file: closed, openPages: openPages})
+	} else if closed.FileName != "" {
+		r.finishLater(closed)
+	}
+
+	r.pruneLater()
+}
+
+// finishLater queues finishing a closed file on the housekeeping worker. The caller must hold the mutex.
+func (r *Receiver) finishLater(file ClosedFile) {
+	if r.unfinished == nil {
+		r.unfinished = make(map[string]struct{})
+	}
+	r.unfinished[filepath.Clean(file.FileName)] = struct{}{}
+
+	r.housekeeping.enqueue(func() {
+		err := r.finishFile(file)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		delete(r.unfinished, filepath.Clean(file.FileName))
+		if err != nil {
+			r.housekeepingErrs = append(r.housekeepingErrs, err)
+		}
+	})
+}
+
+// pruneLater queues pruning old files on the housekeeping worker, if retention limits are set.
+func (r *Receiver) pruneLater() {
+	if !r.retentionEnabled() {
+		return
+	}
+
+	r.housekeeping.enqueue(func() {
+		if err := r.prune(); err != nil {
+			r.mutex.Lock()
+			r.housekeepingErrs = append(r.housekeepingErrs, fmt.Errorf("failed to prune old files: %w", err))
+			r.mutex.Unlock()
+		}
+	})
+}
+
+// finishFile compresses a closed file if compression of rotated files is enabled and the file isn't compressed or
+// encrypted already, adds it to the manifest and queues it for the rotate hook. It runs on the housekeeping worker.
+func (r *Receiver) finishFile(file ClosedFile) error {
+	if r.compressRotated && r.keys == nil && !strings.HasSuffix(file.FileName, ".gz") {
+		if err := compressFile(file.FileName, r.rotatedCompressionLevel); err != nil {
+			return fmt.Errorf("failed to compress %q: %w", file.FileName, err)
+		}
+		file.FileName += ".gz"
+	}
+
+	if info, err := os.Stat(file.FileName); err == nil {
+		file.Size = info.Size()
+	}
+
+	var err error
+	if r.manifestFile != "" {
+		if err = r.addToManifest(file); err != nil {
+			err = fmt.Errorf("failed to update manifest: %w", err)
+		}
+	}
+
+	if r.rotateHook != nil {
+		r.enqueueHook(file)
+	}
+
+	return err
+}
+
+// prune removes the oldest files matching the retention pattern until the retention limits are met. The current file,
+// files waiting for pages and files that haven't been finished yet are never removed, but count towards the file count
+// and total size limits. It runs on the housekeeping worker.
+func (r *Receiver) prune() error {
+	matches, err := filepath.Glob(r.retentionPattern)
+	if err != nil {
+		return err
+	}
+
+	// Files created after the glob aren't matched, files closed since are unfinished or pending.
+	r.mutex.Lock()
+	protected := r.protectedFiles()
+	r.mutex.Unlock()
+
+	type candidate struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+
+	var (
+		candidates []candidate
+		removed    []string
+		count      int
+		total      int64
+		errs       []error
+	)
+
+	for _, name := range matches {
+		info, err := os.Stat(name)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		count++
+		total += info.Size()
+
+		if _, ok := protected[filepath.Clean(name)]; ok {
+			continue
+		}
+		candidates = append(candidates, candidate{name: name, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	// Oldest first, file names break ties since generated names sort chronologically.
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].modTime.Equal(candidates[j].modTime) {
+			return candidates[i].modTime.Before(candidates[j].modTime)
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	now := time.Now()
+	for _, c := range candidates {
+		tooMany := r.maxFiles > 0 && count > r.maxFiles
+		tooLarge := r.maxTotalBytes > 0 && uint64(total) > r.maxTotalBytes
+		tooOld := r.maxAge > 0 && now.Sub(c.modTime) > r.maxAge
+		if !tooMany && !tooLarge && !tooOld {
+			continue
+		}
+
+		if err := os.Remove(c.name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+
+		removed = append(removed, c.name)
+		count--
+		total -= c.size
+	}
+
+	if r.manifestFile != "" {
+		if err := r.removeFromManifest(removed); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update manifest: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// protectedFiles returns the cleaned names of the files prune must not remove. The caller must hold the mutex.
+func (r *Receiver) protectedFiles() map[string]struct{} {
+	protected := make(map[string]struct{}, len(r.unfinished)+len(r.pendingFiles)+1)
+	protected[filepath.Clean(r.current.FileName)] = struct{}{}
+	for name := range r.unfinished {
+		protected[name] = struct{}{}
+	}
+	for _, pf := range r.pendingFiles {
+		protected[filepath.Clean(pf.file.FileName)] = struct{}{}
+	}
+
+	return protected
+}
+
+// compressFile gzip compresses a file into a new file with ".gz" appended to its name and removes the original. The
+// compressed file keeps the modification time of the original, so it keeps its place in the retention order.
+func compressFile(name string, level int) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close() //nolint:errcheck
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = atomicfile.Write(name+".gz", 0o644, func(dst *os.File) error {
+		if err := writeCompressed(dst, src, level); err != nil {
+			return err
+		}
+		return os.Chtimes(dst.Name(), info.ModTime(), info.ModTime())
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+func writeCompressed(dst io.Writer, src io.Reader, level int) error {
+	gz, err := gzip.NewWriterLevel(dst, level)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(gz, src); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
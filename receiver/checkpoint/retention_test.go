@@ -0,0 +1,229 @@
+package checkpoint_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+)
+
+// writeEntries starts the receiver, sends one entry per comment and closes it again. With WithMaxBytes(1) every entry
+// ends up in its own file.
+func writeEntries(t *testing.T, recv *checkpoint.Receiver, comments ...string) {
+	t.Helper()
+
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	for _, comment := range comments {
+		if err := recv.Entry(&har.Entry{Comment: comment}); err != nil {
+			t.Fatalf("Entry: %v", err)
+		}
+	}
+
+	if err := recv.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		// Skip the temporary copy of the open file (see: receiver.HARFileReceiver).
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func readComments(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var comments []string
+	for _, name := range listFiles(t, dir) {
+		if !strings.HasPrefix(name, "test-") {
+			continue
+		}
+
+		fp, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r io.Reader = fp
+		if strings.HasSuffix(name, ".gz") {
+			gz, err := gzip.NewReader(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+
+		archive := &har.HTTPArchive{}
+		if err := json.NewDecoder(r).Decode(archive); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		_ = fp.Close()
+
+		for _, entry := range archive.Log.Entries {
+			comments = append(comments, entry.Comment)
+		}
+	}
+	sort.Strings(comments)
+
+	return comments
+}
+
+func makeOldFile(t *testing.T, name string, age time.Duration) {
+	t.Helper()
+
+	if err := os.WriteFile(name, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := time.Now().Add(-age)
+	if err := os.Chtimes(name, tm, tm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpointMaxFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	makeOldFile(t, filepath.Join(dir, "test-earlier-run.har"), time.Hour)
+	makeOldFile(t, filepath.Join(dir, "unrelated.har"), time.Hour)
+
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(1),
+		checkpoint.WithMaxFiles(3),
+		checkpoint.WithRetentionPattern(checkpoint.TimestampFilePattern(dir, "test-")),
+	)
+
+	comments := make([]string, 5)
+	for i := range comments {
+		comments[i] = fmt.Sprintf("entry %d", i)
+	}
+	writeEntries(t, recv, comments...)
+
+	files := listFiles(t, dir)
+	if len(files) != 4 {
+		t.Fatalf("got files %v, want 3 rotated files and the unrelated file", files)
+	}
+	if files[len(files)-1] != "unrelated.har" {
+		t.Errorf("unrelated file was removed: %v", files)
+	}
+
+	got := readComments(t, dir)
+	want := []string{"entry 2", "entry 3", "entry 4"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+}
+
+func TestCheckpointMaxAge(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	makeOldFile(t, filepath.Join(dir, "test-old.har"), 2*time.Hour)
+	makeOldFile(t, filepath.Join(dir, "test-recent.har"), time.Minute)
+
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxAge(time.Hour),
+		checkpoint.WithRetentionPattern(checkpoint.TimestampFilePattern(dir, "test-")),
+	)
+	writeEntries(t, recv, "entry")
+
+	files := listFiles(t, dir)
+	if len(files) != 2 || files[1] != "test-recent.har" {
+		t.Errorf("got files %v, want the new file and test-recent.har", files)
+	}
+}
+
+func TestCheckpointMaxTotalBytes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(1),
+		checkpoint.WithMaxTotalBytes(1),
+		checkpoint.WithRetentionPattern(checkpoint.TimestampFilePattern(dir, "test-")),
+	)
+	writeEntries(t, recv, "entry 0", "entry 1", "entry 2")
+
+	// Only the file being written is kept, since it's never removed.
+	got := readComments(t, dir)
+	if fmt.Sprint(got) != fmt.Sprint([]string{"entry 2"}) {
+		t.Errorf("got entries %v, want only the last entry", got)
+	}
+}
+
+func TestCheckpointCompressRotated(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxBytes(1),
+		checkpoint.WithCompressRotated(gzip.BestSpeed),
+	)
+	writeEntries(t, recv, "entry 0", "entry 1", "entry 2")
+
+	files := listFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("got files %v, want 3", files)
+	}
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".har.gz") {
+			t.Errorf("file %s wasn't compressed", name)
+		}
+	}
+
+	got := readComments(t, dir)
+	if fmt.Sprint(got) != fmt.Sprint([]string{"entry 0", "entry 1", "entry 2"}) {
+		t.Errorf("got entries %v", got)
+	}
+}
+
+func TestCheckpointRetentionRequiresPattern(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recv := checkpoint.New(
+		checkpoint.WithFileNameGenerator(checkpoint.TimestampFileGenerator(dir, "test-", "2006-01-02_15-04-05.999")),
+		checkpoint.WithMaxFiles(3),
+	)
+
+	// Without a pattern, the limits would apply to the default file names rather than the generated ones.
+	if err := recv.Start(&receiver.Version{}); err == nil {
+		t.Error("Start: expected an error for retention limits without a pattern")
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+package checkpoint
+
+import "sync"
+
+// worker runs jobs one at a time on a background goroutine, in the order they were queued. The goroutine is started
+// by the first job and exits once the queue is empty.
+type worker struct {
+	mutex sync.Mutex
+	queue []func()
+	// idle is closed when the goroutine exits, it's nil while no goroutine is running.
+	idle chan struct{}
+}
+
+// enqueue queues a job, starting the goroutine if it isn't running.
+func (w *worker) enqueue(job func()) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.queue = append(w.queue, job)
+
+	if w.idle == nil {
+		w.idle = make(chan struct{})
+		go w.run()
+	}
+}
+
+func (w *worker) run() {
+	for {
+		w.mutex.Lock()
+		if len(w.queue) == 0 {
+			close(w.idle)
+			w.idle = nil
+			w.mutex.Unlock()
+			return
+		}
+		job := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mutex.Unlock()
+
+		job()
+	}
+}
+
+// wait waits until every queued job has run.
+func (w *worker) wait() {
+	for {
+		w.mutex.Lock()
+		idle := w.idle
+		w.mutex.Unlock()
+
+		if idle == nil {
+			return
+		}
+		<-idle
+	}
+}
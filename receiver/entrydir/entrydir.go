@@ -0,0 +1,330 @@
+// Package entrydir provides a receiver that writes every entry to a directory of its own, so recordings can be
+// inspected with grep, diff and the tools used to open the bodies rather than by digging through HAR JSON.
+//
+// The directory of an entry is named after its sequence number, method and host (e.g. "000042-GET-example.com_8080")
+// and contains:
+//
+//   - "request.http" and "response.http", the request and status line followed by the headers, as they'd appear on
+//     the wire. Headers are sorted by name so entries diff cleanly.
+//   - "request.body.<ext>" and "response.body.<ext>", the decoded bodies, with an extension derived from the MIME type
+//     (e.g. "response.body.json"). Empty bodies are left out.
+//   - "meta.json", the timings, sizes and other details of the entry (see: Meta).
+//
+// The pages are written to "pages.json" in the root directory, which is replaced atomically. Every entry directory is
+// written under a temporary name, synced and renamed once it's complete, so a crash or a power loss never leaves a
+// partially written entry behind. Existing directories are added to, numbering continues after the highest sequence
+// number found.
+package entrydir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/internal/atomicfile"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+const (
+	pagesFile = "pages.json"
+	metaFile  = "meta.json"
+	tmpSuffix = ".tmp"
+)
+
+var (
+	// entryDirPattern matches the names of entry directories, the first group is the sequence number.
+	entryDirPattern = regexp.MustCompile(`^(\d{6,})-`)
+	// tmpEntryDirPattern matches the temporary names of entry directories that are being written (see: entryDirName).
+	tmpEntryDirPattern = regexp.MustCompile(`^\d{6,}-[\w.-]+-[\w.-]+\.tmp$`)
+)
+
+// Receiver writes every entry to a directory of its own as soon as it arrives.
+type Receiver struct {
+	dir string
+
+	mutex   sync.Mutex
+	seq     int
+	pages   []*har.Page
+	started bool
+	closed  bool
+}
+
+// Meta is the contents of the "meta.json" file of an entry.
+type Meta struct {
+	// Seq is the sequence number of the entry.
+	Seq int `json:"seq"`
+	// PageRef is a reference to the page the entry is a part of.
+	PageRef string `json:"pageref,omitempty"`
+	// StartedDateTime is the time the request was started.
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total elapsed time of the request in milliseconds.
+	Time har.DurationMS `json:"time"`
+	// Timings contains detailed timing information about the request.
+	Timings *har.Timings `json:"timings,omitempty"`
+	// Method is the HTTP request method.
+	Method string `json:"method,omitempty"`
+	// URL is the absolute URL of the request.
+	URL string `json:"url,omitempty"`
+	// Status is the HTTP status code of the response.
+	Status int `json:"status,omitempty"`
+	// Error is the error returned instead of a response, if any.
+	Error any `json:"error,omitempty"`
+	// ServerIPAddress is the IP address of the server the client connected to.
+	ServerIPAddress string `json:"serverIPAddress,omitempty"`
+	// Connection is the ID of the connection the request was sent on.
+	Connection string `json:"connection,omitempty"`
+	// Request contains the sizes and body details of the request.
+	Request *MetaBody `json:"request,omitempty"`
+	// Response contains the sizes and body details of the response.
+	Response *MetaBody `json:"response,omitempty"`
+	// Comment is the comment of the entry.
+	Comment string `json:"comment,omitempty"`
+}
+
+// MetaBody contains the sizes and body details of a request or response.
+type MetaBody struct {
+	// HeadersSize is the size of the headers in bytes, as recorded.
+	HeadersSize uint64 `json:"headersSize"`
+	// BodySize is the size of the body on the wire in bytes, as recorded.
+	BodySize uint64 `json:"bodySize"`
+	// MimeType is the MIME type of the body.
+	MimeType string `json:"mimeType,omitempty"`
+	// BodyFile is the name of the file containing the decoded body, empty if there is none.
+	BodyFile string `json:"bodyFile,omitempty"`
+	// BlobRef references the body stored outside the archive when it was deduplicated (see: har.BlobRef).
+	BlobRef string `json:"blobRef,omitempty"`
+	// Comment is the comment of the body, e.g. when it was truncated.
+	Comment string `json:"comment,omitempty"`
+}
+
+// New creates a new Receiver writing to the given directory. The directory is created when Receiver.Start is called
+// if it doesn't exist yet.
+func New(dir string) *Receiver {
+	return &Receiver{dir: dir}
+}
+
+// Start creates the directory, removes entries left incomplete by an earlier crash and loads the existing pages. Only
+// the temporary names written by a Receiver are removed, other files in the directory are left alone.
+func (r *Receiver) Start(_ *receiver.Version) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.started {
+		return nil
+	}
+
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		if isTemporary(de) {
+			if err := os.RemoveAll(filepath.Join(r.dir, name)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if match := entryDirPattern.FindStringSubmatch(name); match != nil && de.IsDir() {
+			if seq, err := strconv.Atoi(match[1]); err == nil && seq > r.seq {
+				r.seq = seq
+			}
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.dir, pagesFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &r.pages); err != nil {
+			return fmt.Errorf("failed to read %s: %w", pagesFile, err)
+		}
+	}
+
+	r.started = true
+
+	return nil
+}
+
+// Entry writes the entry to a new directory.
+func (r *Receiver) Entry(entry *har.Entry) error {
+	r.mutex.Lock()
+	if r.closed || !r.started {
+		r.mutex.Unlock()
+		return os.ErrClosed
+	}
+	r.seq++
+	seq := r.seq
+	r.mutex.Unlock()
+
+	name := entryDirName(seq, entry)
+	tmp := filepath.Join(r.dir, name+tmpSuffix)
+
+	if err := writeEntry(tmp, seq, entry); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+
+	// The files were synced as they were written, the directory must be synced before it's renamed.
+	atomicfile.SyncDir(tmp)
+
+	if err := atomicfile.Rename(tmp, filepath.Join(r.dir, name)); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// Page adds the page to "pages.json".
+func (r *Receiver) Page(page *har.Page) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed || !r.started {
+		return
+	}
+
+	r.pages = append(r.pages, page)
+
+	// Page can't report errors, the pages are written again with the next page.
+	_ = r.writePages()
+}
+
+// Flush is a no-op, every entry is written as soon as it arrives.
+func (r *Receiver) Flush() error {
+	return nil
+}
+
+// Close writes the pages once more, in case writing them failed before. Calling Close more than once is safe;
+// subsequent calls are no-ops.
+func (r *Receiver) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if !r.started || len(r.pages) == 0 {
+		return nil
+	}
+
+	return r.writePages()
+}
+
+// isTemporary returns whether a directory entry is an entry directory or pages file left behind by a crash while it
+// was written.
+func isTemporary(de os.DirEntry) bool {
+	if de.IsDir() {
+		return tmpEntryDirPattern.MatchString(de.Name())
+	}
+
+	// See: atomicfile.
+	return de.Type().IsRegular() && strings.HasPrefix(de.Name(), "."+pagesFile+tmpSuffix)
+}
+
+// writePages replaces "pages.json" with the current pages. The caller must hold the mutex.
+func (r *Receiver) writePages() error {
+	data, err := json.MarshalIndent(r.pages, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(filepath.Join(r.dir, pagesFile), append(data, '\n'), 0o644)
+}
+
+// writeEntry writes the files of an entry to dir, syncing each of them.
+func writeEntry(dir string, seq int, entry *har.Entry) error {
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return err
+	}
+
+	meta := &Meta{
+		Seq:             seq,
+		PageRef:         entry.PageRef,
+		StartedDateTime: entry.StartedDateTime,
+		Time:            entry.Time,
+		Timings:         entry.Timings,
+		ServerIPAddress: entry.ServerIPAddress,
+		Connection:      entry.Connection,
+		Comment:         entry.Comment,
+	}
+
+	if req := entry.Request; req != nil {
+		meta.Method = req.Method
+		meta.URL = req.URL
+		meta.Request = &MetaBody{HeadersSize: req.HeadersSize, BodySize: req.BodySize}
+
+		if err := atomicfile.WriteFileSync(filepath.Join(dir, "request.http"), formatRequest(req), 0o644); err != nil {
+			return err
+		}
+
+		if pd := req.PostData; pd != nil {
+			meta.Request.MimeType = pd.MimeType
+			meta.Request.Comment = pd.Comment
+
+			// The recorder doesn't record how request bodies are encoded, they're written as recorded.
+			if pd.Text != "" {
+				meta.Request.BodyFile = "request.body" + extension(pd.MimeType)
+				fileName := filepath.Join(dir, meta.Request.BodyFile)
+				if err := atomicfile.WriteFileSync(fileName, []byte(pd.Text), 0o644); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if rsp := entry.Response; rsp != nil {
+		meta.Status = rsp.Status
+		meta.Error = rsp.Error
+		meta.Response = &MetaBody{HeadersSize: rsp.HeadersSize, BodySize: rsp.BodySize}
+
+		if rsp.Status != 0 {
+			if err := atomicfile.WriteFileSync(filepath.Join(dir, "response.http"), formatResponse(rsp), 0o644); err != nil {
+				return err
+			}
+		}
+
+		if content := rsp.Content; content != nil {
+			meta.Response.MimeType = content.MimeType
+			meta.Response.BlobRef = content.BlobRef
+			meta.Response.Comment = content.Comment
+
+			body, err := decodeContent(content)
+			if err != nil {
+				return err
+			}
+
+			if len(body) > 0 {
+				meta.Response.BodyFile = "response.body" + extension(content.MimeType)
+				if err := atomicfile.WriteFileSync(filepath.Join(dir, meta.Response.BodyFile), body, 0o644); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFileSync(filepath.Join(dir, metaFile), append(data, '\n'), 0o644)
+}
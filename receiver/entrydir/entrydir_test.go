@@ -0,0 +1,250 @@
+package entrydir_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/entrydir"
+)
+
+var testVersion = &receiver.Version{HARVersion: "1.2", Creator: "test", Version: "0.1"}
+
+func testEntry() *har.Entry {
+	return &har.Entry{
+		StartedDateTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Time:            har.DurationMS(150 * time.Millisecond),
+		Timings: &har.Timings{
+			Send: har.DurationMS(50 * time.Millisecond),
+			Wait: har.DurationMS(100 * time.Millisecond),
+		},
+		Request: &har.Request{
+			Method:      "POST",
+			URL:         "http://example.com:8080/api/items?limit=10",
+			HTTPVersion: "HTTP/1.1",
+			Headers: []*har.Header{
+				{Name: "User-Agent", Value: "test"},
+				{Name: "Content-Type", Value: "application/json"},
+			},
+			PostData: &har.PostData{MimeType: "application/json", Text: `{"name":"item"}`},
+			BodySize: 15,
+		},
+		Response: &har.Response{
+			Status:      200,
+			StatusText:  "200 OK",
+			HTTPVersion: "HTTP/1.1",
+			Headers: []*har.Header{
+				{Name: "Set-Cookie", Value: "a=1"},
+				{Name: "Content-Type", Value: "image/png"},
+				{Name: "Set-Cookie", Value: "b=2"},
+			},
+			Content: &har.Content{
+				MimeType: "image/png",
+				Text:     base64.StdEncoding.EncodeToString([]byte{0x89, 'P', 'N', 'G'}),
+				Encoding: "base64",
+			},
+		},
+	}
+}
+
+func readFile(t *testing.T, elem ...string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(elem...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestReceiver(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recv := entrydir.New(dir)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recv.Entry(testEntry()); err != nil {
+		t.Fatal(err)
+	}
+	recv.Page(&har.Page{ID: "page_1", Title: "one"})
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entryDir := filepath.Join(dir, "000001-POST-example.com_8080")
+
+	wantRequest := "POST /api/items?limit=10 HTTP/1.1\n" +
+		"Host: example.com:8080\n" +
+		"Content-Type: application/json\n" +
+		"User-Agent: test\n"
+	if got := readFile(t, entryDir, "request.http"); got != wantRequest {
+		t.Errorf("request.http:\n%s\nwant:\n%s", got, wantRequest)
+	}
+
+	wantResponse := "HTTP/1.1 200 OK\n" +
+		"Content-Type: image/png\n" +
+		"Set-Cookie: a=1\n" +
+		"Set-Cookie: b=2\n"
+	if got := readFile(t, entryDir, "response.http"); got != wantResponse {
+		t.Errorf("response.http:\n%s\nwant:\n%s", got, wantResponse)
+	}
+
+	if got := readFile(t, entryDir, "request.body.json"); got != `{"name":"item"}` {
+		t.Errorf("request.body.json: %q", got)
+	}
+	if got := readFile(t, entryDir, "response.body.png"); got != "\x89PNG" {
+		t.Errorf("response.body.png: %q, want the decoded body", got)
+	}
+
+	meta := &entrydir.Meta{}
+	if err := json.Unmarshal([]byte(readFile(t, entryDir, "meta.json")), meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Seq != 1 || meta.Status != 200 || meta.Timings == nil ||
+		meta.Timings.Wait != har.DurationMS(100*time.Millisecond) || meta.Request.BodyFile != "request.body.json" ||
+		meta.Response.BodyFile != "response.body.png" {
+		t.Errorf("unexpected meta.json: %+v", meta)
+	}
+
+	var pages []*har.Page
+	if err := json.Unmarshal([]byte(readFile(t, dir, "pages.json")), &pages); err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].ID != "page_1" {
+		t.Errorf("unexpected pages: %+v", pages)
+	}
+}
+
+func TestReceiverFailedRequest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recv := entrydir.New(dir)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &har.Entry{
+		Request:  &har.Request{Method: "GET", URL: "https://example.com/"},
+		Response: &har.Response{Error: "connection refused"},
+	}
+	if err := recv.Entry(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "000001-GET-example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a response only the request and meta.json are written.
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if len(names) != 2 || names[0] != "meta.json" || names[1] != "request.http" {
+		t.Errorf("got files %v", names)
+	}
+}
+
+func TestReceiverContinues(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// Left over from a crash while writing an entry.
+	if err := os.Mkdir(filepath.Join(dir, "000003-GET-example.com.tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		recv := entrydir.New(dir)
+		if err := recv.Start(testVersion); err != nil {
+			t.Fatal(err)
+		}
+		if err := recv.Entry(testEntry()); err != nil {
+			t.Fatal(err)
+		}
+		recv.Page(&har.Page{ID: "page"})
+		if err := recv.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	want := []string{"000001-POST-example.com_8080", "000002-POST-example.com_8080", "pages.json"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Errorf("got %v, want %v", names, want)
+	}
+
+	var pages []*har.Page
+	if err := json.Unmarshal([]byte(readFile(t, dir, "pages.json")), &pages); err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Errorf("got %d pages, want the pages of both sessions", len(pages))
+	}
+}
+
+func TestReceiverKeepsOtherFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	// Left over from a crash while writing an entry and the pages.
+	if err := os.Mkdir(filepath.Join(dir, "000001-GET-example.com.tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".pages.json.tmp123456"), []byte("[]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files that weren't written by the receiver.
+	if err := os.WriteFile(filepath.Join(dir, "notes.tmp"), []byte("notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "scratch.tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	recv := entrydir.New(dir)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if len(names) != 2 || names[0] != "notes.tmp" || names[1] != "scratch.tmp" {
+		t.Errorf("got %v, want only the files not written by the receiver", names)
+	}
+}
@@ -0,0 +1,173 @@
+package entrydir
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+// extensions maps common MIME types to the extension used for their bodies. mime.ExtensionsByType is used for other
+// types, its results depend on the system and aren't always the most common extension.
+var extensions = map[string]string{
+	"application/javascript":            ".js",
+	"application/json":                  ".json",
+	"application/octet-stream":          ".bin",
+	"application/pdf":                   ".pdf",
+	"application/x-www-form-urlencoded": ".txt",
+	"application/xml":                   ".xml",
+	"application/zip":                   ".zip",
+	"image/gif":                         ".gif",
+	"image/jpeg":                        ".jpg",
+	"image/png":                         ".png",
+	"image/svg+xml":                     ".svg",
+	"image/webp":                        ".webp",
+	"text/css":                          ".css",
+	"text/csv":                          ".csv",
+	"text/html":                         ".html",
+	"text/javascript":                   ".js",
+	"text/plain":                        ".txt",
+	"text/xml":                          ".xml",
+}
+
+// extension returns the file extension for a body with the given MIME type, ".bin" if it's unknown.
+func extension(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ".bin"
+	}
+
+	if ext, ok := extensions[mediaType]; ok {
+		return ext
+	}
+
+	switch {
+	case strings.HasSuffix(mediaType, "+json"):
+		return ".json"
+	case strings.HasSuffix(mediaType, "+xml"):
+		return ".xml"
+	}
+
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return ".txt"
+	}
+
+	return ".bin"
+}
+
+// entryDirName returns the name of the directory of an entry, e.g. "000042-GET-example.com_8080".
+func entryDirName(seq int, entry *har.Entry) string {
+	method, host := "UNKNOWN", "unknown"
+	if entry.Request != nil {
+		if entry.Request.Method != "" {
+			method = entry.Request.Method
+		}
+		if u, err := url.Parse(entry.Request.URL); err == nil && u.Host != "" {
+			host = u.Host
+		}
+	}
+
+	return fmt.Sprintf("%06d-%s-%s", seq, sanitize(method), sanitize(host))
+}
+
+// sanitize replaces the characters that aren't safe in file names on every platform.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// formatRequest returns the request line and headers of a request as they'd appear on the wire.
+func formatRequest(req *har.Request) []byte {
+	var buf bytes.Buffer
+
+	target, host := req.URL, ""
+	if u, err := url.Parse(req.URL); err == nil {
+		target, host = u.RequestURI(), u.Host
+	}
+
+	fmt.Fprintf(&buf, "%s %s %s\n", req.Method, target, httpVersion(req.HTTPVersion))
+
+	// The Host header isn't part of the recorded headers, it's taken from the URL.
+	if host != "" && !hasHeader(req.Headers, "Host") {
+		fmt.Fprintf(&buf, "Host: %s\n", host)
+	}
+	writeHeaders(&buf, req.Headers)
+
+	return buf.Bytes()
+}
+
+// formatResponse returns the status line and headers of a response as they'd appear on the wire.
+func formatResponse(rsp *har.Response) []byte {
+	var buf bytes.Buffer
+
+	// The recorder stores the complete status (e.g. "200 OK") as the status text.
+	status := rsp.StatusText
+	if !strings.HasPrefix(status, strconv.Itoa(rsp.Status)) {
+		status = strings.TrimSpace(strconv.Itoa(rsp.Status) + " " + status)
+	}
+
+	fmt.Fprintf(&buf, "%s %s\n", httpVersion(rsp.HTTPVersion), status)
+	writeHeaders(&buf, rsp.Headers)
+
+	return buf.Bytes()
+}
+
+func httpVersion(version string) string {
+	if version == "" {
+		return "HTTP/1.1"
+	}
+
+	return version
+}
+
+func hasHeader(headers []*har.Header, name string) bool {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeHeaders writes the headers sorted by name, keeping the order of headers with the same name.
+func writeHeaders(buf *bytes.Buffer, headers []*har.Header) {
+	sorted := make([]*har.Header, len(headers))
+	copy(sorted, headers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name)
+	})
+
+	for _, h := range sorted {
+		fmt.Fprintf(buf, "%s: %s\n", h.Name, h.Value)
+	}
+}
+
+// decodeContent returns the decoded response body.
+func decodeContent(content *har.Content) ([]byte, error) {
+	if content.Encoding == "base64" {
+		body, err := base64.StdEncoding.DecodeString(content.Text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response body: %w", err)
+		}
+		return body, nil
+	}
+
+	return []byte(content.Text), nil
+}
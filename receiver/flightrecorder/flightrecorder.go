@@ -0,0 +1,341 @@
+// Package flightrecorder provides a receiver that keeps only the most recent entries in memory and writes them out as
+// a HAR file when triggered.
+package flightrecorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+)
+
+// Receiver is a flight recorder. It keeps the last entries in a ring buffer bounded by count, size and age, and only
+// writes them out as a HAR file when triggered: explicitly with Trigger or Dump, by an OS signal, by an entry matching
+// a predicate or when the error rate of recent entries crosses a threshold. Unlike receiver.MemoryReceiver, memory use
+// is bounded, which makes it cheap enough to leave on in production.
+//
+// Entries are encoded once when they're received, so the size limit is measured against their encoded JSON size and
+// dumping doesn't need to re-encode them. Pages are kept for as long as an entry in the buffer references them.
+type Receiver struct {
+	maxEntries      int
+	maxBytes        uint64
+	maxAge          time.Duration
+	nextFile        checkpoint.FileNameGenerator
+	signals         []os.Signal
+	predicate       func(*har.Entry) bool
+	errorRate       float64
+	errorWindow     int
+	triggerInterval time.Duration
+	onDump          DumpCallback
+
+	mutex       sync.Mutex
+	version     *receiver.Version
+	ring        []*bufferedEntry
+	ringBytes   uint64
+	pages       map[string]*har.Page
+	pageRefs    map[string]int
+	errors      []bool
+	lastTrigger time.Time
+
+	trigger chan struct{}
+	sigCh   chan os.Signal
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// DumpCallback is called after an automatically triggered dump with the name of the file written and any error that
+// occurred.
+type DumpCallback func(fileName string, err error)
+
+type bufferedEntry struct {
+	data       []byte
+	pageRef    string
+	receivedAt time.Time
+}
+
+// New creates a new flight recorder. By default, it keeps the last 1000 entries and writes dumps to the current
+// directory.
+func New(opts ...Option) *Receiver {
+	r := &Receiver{
+		maxEntries:      1000,
+		nextFile:        checkpoint.TimestampFileGenerator(".", "flightrecorder-", "2006-01-02_15-04-05.999"),
+		triggerInterval: time.Minute,
+		version:         &receiver.Version{},
+		pages:           make(map[string]*har.Page),
+		pageRefs:        make(map[string]int),
+		trigger:         make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+// Start starts the background worker that writes automatically triggered dumps, and starts listening for the
+// configured signals.
+func (r *Receiver) Start(version *receiver.Version) error {
+	r.mutex.Lock()
+	r.version = version
+	r.mutex.Unlock()
+
+	if len(r.signals) > 0 {
+		r.sigCh = make(chan os.Signal, 1)
+		signal.Notify(r.sigCh, r.signals...)
+	}
+
+	r.wg.Add(1)
+	go r.worker()
+
+	return nil
+}
+
+// Entry adds the entry to the ring buffer, evicting the oldest entries if needed, and evaluates the triggers.
+func (r *Receiver) Entry(entry *har.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	r.ring = append(r.ring, &bufferedEntry{data: data, pageRef: entry.PageRef, receivedAt: now})
+	r.ringBytes += uint64(len(data))
+	if entry.PageRef != "" {
+		r.pageRefs[entry.PageRef]++
+	}
+	r.evict(now)
+
+	if r.shouldTrigger(entry, now) {
+		r.lastTrigger = now
+		r.errors = r.errors[:0]
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+			// A dump is already pending.
+		}
+	}
+
+	return nil
+}
+
+// Page keeps the page for as long as entries in the buffer reference it. Pages without any buffered entries are
+// dropped.
+func (r *Receiver) Page(page *har.Page) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.pageRefs[page.ID] > 0 {
+		r.pages[page.ID] = page
+	}
+}
+
+// Flush is a no-op, nothing is written until a dump is triggered.
+func (r *Receiver) Flush() error {
+	return nil
+}
+
+// Close stops listening for signals and waits for a dump that is being written to finish. The buffer isn't written
+// out.
+func (r *Receiver) Close() error {
+	if r.sigCh != nil {
+		signal.Stop(r.sigCh)
+	}
+
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	r.wg.Wait()
+
+	return nil
+}
+
+// Trigger writes the buffer to the next file from the file name generator and returns its name.
+func (r *Receiver) Trigger() (string, error) {
+	fileName, err := r.nextFile()
+	if err != nil {
+		return "", fmt.Errorf("failed to get next file name: %w", err)
+	}
+
+	fp, err := os.Create(fileName)
+	if err != nil {
+		return fileName, err
+	}
+
+	if err := r.Dump(fp); err != nil {
+		_ = fp.Close()
+		return fileName, err
+	}
+
+	return fileName, fp.Close()
+}
+
+// Dump writes the buffer to w as a HAR document. The buffer is left intact.
+func (r *Receiver) Dump(w io.Writer) error {
+	r.mutex.Lock()
+	r.evict(time.Now())
+	version := r.version
+	entries := make([][]byte, 0, len(r.ring))
+	for _, e := range r.ring {
+		entries = append(entries, e.data)
+	}
+	pages := make([]*har.Page, 0, len(r.pages))
+	for _, page := range r.pages {
+		pages = append(pages, page)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].StartedDateTime.Before(pages[j].StartedDateTime)
+	})
+
+	return writeHAR(w, version, pages, entries)
+}
+
+// Len returns the number of entries currently buffered.
+func (r *Receiver) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.ring)
+}
+
+func (r *Receiver) worker() {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.trigger:
+		case <-r.sigCh:
+		}
+
+		fileName, err := r.Trigger()
+		if r.onDump != nil {
+			r.onDump(fileName, err)
+		}
+	}
+}
+
+// evict drops the oldest entries until the buffer is within its limits. The caller must hold the mutex.
+func (r *Receiver) evict(now time.Time) {
+	drop := 0
+	for drop < len(r.ring) {
+		e := r.ring[drop]
+		overCount := r.maxEntries > 0 && len(r.ring)-drop > r.maxEntries
+		overBytes := r.maxBytes > 0 && r.ringBytes > r.maxBytes
+		overAge := r.maxAge > 0 && now.Sub(e.receivedAt) > r.maxAge
+		if !overCount && !overBytes && !overAge {
+			break
+		}
+
+		r.ringBytes -= uint64(len(e.data))
+		if e.pageRef != "" {
+			r.pageRefs[e.pageRef]--
+			if r.pageRefs[e.pageRef] <= 0 {
+				delete(r.pageRefs, e.pageRef)
+				delete(r.pages, e.pageRef)
+			}
+		}
+		r.ring[drop] = nil
+		drop++
+	}
+
+	r.ring = r.ring[drop:]
+}
+
+// shouldTrigger evaluates the automatic triggers for a new entry. The caller must hold the mutex.
+func (r *Receiver) shouldTrigger(entry *har.Entry, now time.Time) bool {
+	triggered := r.predicate != nil && r.predicate(entry)
+
+	if r.errorWindow > 0 {
+		r.errors = append(r.errors, ServerError(entry))
+		if len(r.errors) > r.errorWindow {
+			r.errors = r.errors[1:]
+		}
+
+		if len(r.errors) == r.errorWindow {
+			errCount := 0
+			for _, isErr := range r.errors {
+				if isErr {
+					errCount++
+				}
+			}
+			triggered = triggered || float64(errCount)/float64(r.errorWindow) >= r.errorRate
+		}
+	}
+
+	if !triggered {
+		return false
+	}
+
+	return r.lastTrigger.IsZero() || now.Sub(r.lastTrigger) >= r.triggerInterval
+}
+
+// ServerError reports whether the entry failed, either with a 5xx status or without receiving a response at all. It
+// can be used with WithEntryTrigger.
+func ServerError(entry *har.Entry) bool {
+	return entry.Response == nil || entry.Response.Status == 0 || entry.Response.Status >= 500
+}
+
+// writeHAR writes a HAR document containing the pages and already encoded entries to w.
+func writeHAR(w io.Writer, version *receiver.Version, pages []*har.Page, entries [][]byte) error {
+	bw := bufio.NewWriter(w)
+
+	creatorBytes, err := json.Marshal(&har.Agent{
+		Name:    version.Creator,
+		Version: version.Version,
+	})
+	if err != nil {
+		return err
+	}
+
+	versionBytes, err := json.Marshal(version.HARVersion)
+	if err != nil {
+		return err
+	}
+
+	pagesBytes, err := json.Marshal(pages)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(bw, `{"log":{"version":%s,"creator":%s,"pages":%s,"entries":[`,
+		versionBytes, creatorBytes, pagesBytes); err != nil {
+		return err
+	}
+
+	for i, data := range entries {
+		if i > 0 {
+			if _, err := bw.WriteString(",\n"); err != nil {
+				return err
+			}
+		}
+
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if _, err := bw.WriteString("]}}\n"); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,221 @@
+package flightrecorder_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+	"github.com/swedishborgie/daytripper/receiver/flightrecorder"
+)
+
+func entryWithStatus(comment string, status int) *har.Entry {
+	return &har.Entry{
+		Comment:  comment,
+		Response: &har.Response{Status: status},
+	}
+}
+
+func dump(t *testing.T, recv *flightrecorder.Receiver) *har.HTTPArchive {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := recv.Dump(&buf); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(buf.Bytes(), archive); err != nil {
+		t.Fatalf("invalid HAR: %v\n%s", err, buf.String())
+	}
+
+	return archive
+}
+
+func TestFlightRecorderMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	recv := flightrecorder.New(flightrecorder.WithMaxEntries(2))
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2", Creator: "test", Version: "0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close() //nolint:errcheck
+
+	for i, comment := range []string{"1", "2", "3"} {
+		entry := entryWithStatus(comment, 200)
+		entry.PageRef = "page_" + comment
+		if err := recv.Entry(entry); err != nil {
+			t.Fatal(err)
+		}
+		recv.Page(&har.Page{ID: entry.PageRef, StartedDateTime: time.Unix(int64(i), 0)})
+	}
+	// A page without any buffered entries is dropped.
+	recv.Page(&har.Page{ID: "page_empty"})
+
+	archive := dump(t, recv)
+	if archive.Log.Creator.Name != "test" || archive.Log.Version != "1.2" {
+		t.Errorf("log = %+v, want the started version", archive.Log)
+	}
+	if len(archive.Log.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(archive.Log.Entries))
+	}
+	if archive.Log.Entries[0].Comment != "2" || archive.Log.Entries[1].Comment != "3" {
+		t.Errorf("got %q and %q, want the two most recent entries",
+			archive.Log.Entries[0].Comment, archive.Log.Entries[1].Comment)
+	}
+	if len(archive.Log.Pages) != 2 || archive.Log.Pages[0].ID != "page_2" || archive.Log.Pages[1].ID != "page_3" {
+		t.Errorf("pages = %+v, want page_2 and page_3", archive.Log.Pages)
+	}
+}
+
+func TestFlightRecorderMaxBytesAndAge(t *testing.T) {
+	t.Parallel()
+
+	entrySize := func() int {
+		data, _ := json.Marshal(entryWithStatus("x", 200))
+		return len(data)
+	}()
+
+	recv := flightrecorder.New(
+		flightrecorder.WithMaxEntries(0),
+		flightrecorder.WithMaxBytes(uint64(entrySize*2)),
+		flightrecorder.WithMaxAge(50*time.Millisecond),
+	)
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close() //nolint:errcheck
+
+	for _, comment := range []string{"a", "b", "c"} {
+		if err := recv.Entry(entryWithStatus(comment, 200)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if recv.Len() != 2 {
+		t.Errorf("got %d entries, want 2", recv.Len())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if archive := dump(t, recv); len(archive.Log.Entries) != 0 {
+		t.Errorf("got %d entries after they expired, want 0", len(archive.Log.Entries))
+	}
+}
+
+func TestFlightRecorderTrigger(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	recv := flightrecorder.New(
+		flightrecorder.WithFileNameGenerator(checkpoint.TimestampFileGenerator(tmpDir, "test-", "2006-01-02_15-04-05.999")),
+	)
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close() //nolint:errcheck
+
+	if err := recv.Entry(entryWithStatus("entry", 200)); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName, err := recv.Trigger()
+	if err != nil {
+		t.Fatalf("Trigger: %v", err)
+	}
+	if filepath.Dir(fileName) != tmpDir {
+		t.Errorf("dump written to %s, want it in %s", fileName, tmpDir)
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"comment":"entry"`) {
+		t.Errorf("dump doesn't contain the entry: %s", data)
+	}
+
+	// The buffer is left intact after a dump.
+	if recv.Len() != 1 {
+		t.Errorf("got %d entries after dump, want 1", recv.Len())
+	}
+}
+
+func TestFlightRecorderAutomaticTriggers(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		opt     flightrecorder.Option
+		entries []*har.Entry
+	}{
+		{
+			name:    "predicate",
+			opt:     flightrecorder.WithEntryTrigger(flightrecorder.ServerError),
+			entries: []*har.Entry{entryWithStatus("ok", 200), entryWithStatus("error", 503)},
+		},
+		{
+			name: "error rate",
+			opt:  flightrecorder.WithErrorRateTrigger(0.5, 4),
+			entries: []*har.Entry{
+				entryWithStatus("ok", 200),
+				entryWithStatus("error", 500),
+				{Comment: "no response"},
+				entryWithStatus("ok", 200),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dumped := make(chan string, 10)
+			recv := flightrecorder.New(
+				tc.opt,
+				flightrecorder.WithFileNameGenerator(checkpoint.TimestampFileGenerator(t.TempDir(), "test-", "2006-01-02_15-04-05.999")),
+				flightrecorder.WithDumpCallback(func(fileName string, err error) {
+					if err != nil {
+						t.Errorf("dump: %v", err)
+					}
+					dumped <- fileName
+				}),
+			)
+			if err := recv.Start(&receiver.Version{}); err != nil {
+				t.Fatal(err)
+			}
+			defer recv.Close() //nolint:errcheck
+
+			for i, entry := range tc.entries {
+				if err := recv.Entry(entry); err != nil {
+					t.Fatal(err)
+				}
+				if i < len(tc.entries)-1 {
+					select {
+					case <-dumped:
+						t.Fatalf("dump triggered early, after entry %d", i)
+					case <-time.After(20 * time.Millisecond):
+					}
+				}
+			}
+
+			select {
+			case <-dumped:
+			case <-time.After(time.Second):
+				t.Fatal("dump wasn't triggered")
+			}
+
+			// Further errors within the minimum trigger interval don't cause another dump.
+			if err := recv.Entry(entryWithStatus("error", 500)); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-dumped:
+				t.Fatal("dump triggered within the minimum trigger interval")
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+	}
+}
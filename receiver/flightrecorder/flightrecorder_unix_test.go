@@ -0,0 +1,39 @@
+//go:build unix
+
+package flightrecorder_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+	"github.com/swedishborgie/daytripper/receiver/flightrecorder"
+)
+
+func TestFlightRecorderSignalTrigger(t *testing.T) {
+	dumped := make(chan string, 1)
+	recv := flightrecorder.New(
+		flightrecorder.WithSignalTrigger(syscall.SIGUSR1),
+		flightrecorder.WithFileNameGenerator(checkpoint.TimestampFileGenerator(t.TempDir(), "test-", "2006-01-02_15-04-05.999")),
+		flightrecorder.WithDumpCallback(func(fileName string, err error) {
+			dumped <- fileName
+		}),
+	)
+	if err := recv.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close() //nolint:errcheck
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-dumped:
+	case <-time.After(time.Second):
+		t.Fatal("dump wasn't triggered by the signal")
+	}
+}
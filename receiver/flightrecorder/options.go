@@ -0,0 +1,80 @@
+package flightrecorder
+
+import (
+	"os"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver/checkpoint"
+)
+
+type Option func(r *Receiver)
+
+// WithMaxEntries sets the maximum number of entries kept in the buffer. If set to 0, the number of entries isn't
+// limited. The default is 1000.
+func WithMaxEntries(maxEntries int) Option {
+	return func(r *Receiver) {
+		r.maxEntries = maxEntries
+	}
+}
+
+// WithMaxBytes sets the maximum encoded size of the entries kept in the buffer. If set to 0 (the default), the size
+// isn't limited.
+func WithMaxBytes(maxBytes uint64) Option {
+	return func(r *Receiver) {
+		r.maxBytes = maxBytes
+	}
+}
+
+// WithMaxAge sets the maximum age of the entries kept in the buffer. If set to 0 (the default), the age isn't
+// limited.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(r *Receiver) {
+		r.maxAge = maxAge
+	}
+}
+
+// WithFileNameGenerator sets the file name generator used for triggered dumps.
+func WithFileNameGenerator(nextFile checkpoint.FileNameGenerator) Option {
+	return func(r *Receiver) {
+		r.nextFile = nextFile
+	}
+}
+
+// WithSignalTrigger writes a dump whenever one of the given signals is received (e.g. syscall.SIGUSR1).
+func WithSignalTrigger(signals ...os.Signal) Option {
+	return func(r *Receiver) {
+		r.signals = append(r.signals, signals...)
+	}
+}
+
+// WithEntryTrigger writes a dump whenever an entry matching the predicate is received (see: ServerError).
+func WithEntryTrigger(predicate func(*har.Entry) bool) Option {
+	return func(r *Receiver) {
+		r.predicate = predicate
+	}
+}
+
+// WithErrorRateTrigger writes a dump once at least the given fraction (between 0 and 1) of the last window entries
+// were errors (see: ServerError).
+func WithErrorRateTrigger(rate float64, window int) Option {
+	return func(r *Receiver) {
+		r.errorRate = rate
+		r.errorWindow = window
+	}
+}
+
+// WithMinTriggerInterval sets the minimum time between two automatically triggered dumps, so a burst of errors
+// doesn't write a dump for every entry. The default is one minute. Explicit calls to Trigger and Dump aren't limited.
+func WithMinTriggerInterval(interval time.Duration) Option {
+	return func(r *Receiver) {
+		r.triggerInterval = interval
+	}
+}
+
+// WithDumpCallback sets a function that is called after every automatically triggered dump.
+func WithDumpCallback(onDump DumpCallback) Option {
+	return func(r *Receiver) {
+		r.onDump = onDump
+	}
+}
@@ -1,118 +1,758 @@
 package receiver
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"sync"
 
+	"github.com/swedishborgie/daytripper/encrypted"
 	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/internal/atomicfile"
 )
 
-// HARFileReceiver is a basic implementation of a receiver that stores state in memory and flushes to a single file.
-// This is a good option if you have a small number of requests, but you'll run into issues if you have too many
-// requests or if those requests are too large. This implementation buffers into memory until closed, which means that
-// the more requests you make, the more memory it will consume.
+// HARFileReceiver is a receiver that writes entries to a single HAR file as they arrive. Unlike streaming.Receiver, the
+// file is a complete, valid HAR document after every Flush.
 //
-// With this implementation every time you flush, the entire archive needs to be re-written from the beginning.
-// You should either flush infrequently or flush on close.
+// Each entry is serialized once and appended to the "entries" array. Flush writes the remainder of the document (the
+// closing of the entries array and the "pages" array) after the last entry. Pages are serialized once as well and kept
+// in memory, there are typically very few per session.
 //
-// If you need something more robust, consider using checkpoint.Receiver which will automatically rotate files based
-// on size or time.
+// The file is never modified in place, so a crash or a full disk leaves it as it was after the last successful flush.
+// Entries are written to a temporary copy of the file, which Flush completes, syncs and renames over the file. The copy
+// is made when the first entry or page after a flush arrives, by copying the file up to the remainder of the document,
+// so entries are never serialized again. A copy left behind by a crash (named "." followed by the file name, ".tmp"
+// and a random suffix) is removed by the next Start.
+//
+// When compressed, the file is a series of gzip members: the entries written between two flushes are compressed into
+// one member and the remainder of the document into the last one. Decompressing the members in sequence (as gzip
+// tools and gzip.Reader do by default) yields the complete document. When encrypted, the same applies to the chunks of
+// the encrypted stream, the remainder of the document is always in the final chunk.
+//
+// Like streaming.Receiver, this implementation writes the "entries" array before the "pages" array.
+//
+// If you need to limit the size of individual files, consider using checkpoint.Receiver which will automatically rotate
+// files based on size or time.
 type HARFileReceiver struct {
-	mutex    sync.Mutex
-	entries  []*har.Entry
-	pages    []*har.Page
-	encoder  *json.Encoder
-	fileName string
-	fp       *os.File
-	version  *Version
+	mutex      sync.Mutex
+	fileName   string
+	version    *Version
+	pages      []json.RawMessage
+	fp         *os.File          // the temporary copy being written, or the file itself once flushed
+	bw         *bufio.Writer     // 64 KiB buffer over fp, or over gz when compressing
+	gz         *gzip.Writer      // set when compressing, sits between bw and fp
+	enc        *encrypted.Writer // set when encrypting, sits between gz (or bw) and fp
+	counter    countingWriter    // sits right above fp, counts the bytes written to it
+	tailOffset int64             // offset of the remainder of the document, the next entry is written here
+	entryCount int               // tracks comma-prefix logic
+	dirty      bool              // entries were written since the last flush
+	pagesDirty bool              // pages were received since the last flush
+	flushed    bool              // fp was renamed over the file, it's copied before anything is written again
+
+	compress         bool
+	compressionLevel int
+	appendExisting   bool
+	keys             encrypted.KeyProvider
+}
+
+// HARFileOption is an option for NewHARFileReceiver.
+type HARFileOption func(*HARFileReceiver)
+
+// WithCompression gzip compresses the file with the given compression level (e.g. gzip.DefaultCompression). The file
+// name should typically end in ".har.gz".
+func WithCompression(level int) HARFileOption {
+	return func(s *HARFileReceiver) {
+		s.compress = true
+		s.compressionLevel = level
+	}
+}
+
+// WithEncryption encrypts the file with keys from the given provider (see: package encrypted). Use encrypted.Reader or
+// the "daytripper decrypt" command to read it. The file name should typically end in ".enc".
+func WithEncryption(keys encrypted.KeyProvider) HARFileOption {
+	return func(s *HARFileReceiver) {
+		s.keys = keys
+	}
+}
+
+// WithAppend makes Start keep the pages and entries of an existing file, new pages and entries are added to them
+// instead of replacing the file. This allows a restarted process to keep adding to the same archive. The existing
+// document is copied up to the end of its last entry without decoding the entries again, only its pages are read into
+// memory. A file that was cut short, e.g. by a crash while another receiver was writing it, is repaired first (see:
+// har.Repair), keeping every complete entry.
+func WithAppend() HARFileOption {
+	return func(s *HARFileReceiver) {
+		s.appendExisting = true
+	}
 }
 
 // NewHARFileReceiver creates a new receiver with a given file name. The file won't be opened until
 // HARFileReceiver.Start is called.
-func NewHARFileReceiver(fileName string) *HARFileReceiver {
-	return &HARFileReceiver{
+func NewHARFileReceiver(fileName string, opts ...HARFileOption) *HARFileReceiver {
+	s := &HARFileReceiver{
 		fileName: fileName,
-		pages:    make([]*har.Page, 0),
-		entries:  make([]*har.Entry, 0),
 	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
 }
 
-// Start opens the file and sets up the encoder.
+// Start creates the file and writes an empty archive to it, or the contents of the existing file when appending. The
+// file is written to a temporary file in the same directory which then replaces the target, so a failed start never
+// destroys an existing file. Temporary copies of the file left behind by a crash are removed.
 func (s *HARFileReceiver) Start(version *Version) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	s.version = version
 
-	fp, err := os.Create(s.fileName)
+	var existing *existingArchive
+	if s.appendExisting {
+		var err error
+		if existing, err = s.load(); err != nil {
+			return err
+		}
+	}
+
+	atomicfile.RemoveTemp(s.fileName)
+
+	tmp, err := atomicfile.CreateTemp(s.fileName)
 	if err != nil {
 		return err
 	}
-	s.fp = fp
 
-	s.encoder = json.NewEncoder(fp)
+	// create renames the temporary file over the target once it's complete.
+	if err := s.create(tmp, existing); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		s.fp = nil
+		return err
+	}
 
 	return nil
 }
 
-// Entry receives a single HTTP request / response pair.
+// Entry serializes a single HTTP request / response pair and appends it to the file. It returns os.ErrClosed if the
+// receiver isn't started or was closed.
 func (s *HARFileReceiver) Entry(entry *har.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.EntryJSON(data)
+}
+
+// EntryJSON appends an entry that has already been serialized with json.Marshal. This allows receivers wrapping this
+// one to inspect the serialized entry (e.g. to measure its size) without serializing it twice. The data must be a
+// single JSON object, it isn't validated.
+func (s *HARFileReceiver) EntryJSON(data []byte) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.entries = append(s.entries, entry)
-	return nil
+	if s.fp == nil {
+		return os.ErrClosed
+	}
+
+	return s.writeEntry(data)
 }
 
-// Page receives a new page.
+// Page receives a new page. Pages are written to the file on every flush.
 func (s *HARFileReceiver) Page(page *har.Page) {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return
+	}
+
+	s.PageJSON(data)
+}
+
+// PageJSON receives a page that has already been serialized with json.Marshal, like EntryJSON does for entries. The
+// data must be a single JSON object, it isn't validated.
+func (s *HARFileReceiver) PageJSON(data []byte) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.pages = append(s.pages, page)
+	s.pages = append(s.pages, data)
+	s.pagesDirty = true
 }
 
-// Flush flushes the entire archive to disk. It will truncate the file and re-write the entire state.
+// Flush writes the remainder of the document after the last entry, syncs it to disk and atomically replaces the file
+// with it, the file is a valid HAR document afterward. Nothing is written if no entries or pages were received since
+// the last flush.
 func (s *HARFileReceiver) Flush() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	harLog := &har.HTTPArchive{
-		Log: &har.Log{
-			Version: s.version.HARVersion,
-			Creator: &har.Agent{
-				Name:    s.version.Creator,
-				Version: s.version.Version,
-			},
-			Pages:   s.pages,
-			Entries: s.entries,
-		},
+	return s.flush()
+}
+
+// Size returns the size of the file in bytes, including the entries written since the last flush but not the
+// remainder of the document. When compressing or encrypting, data still buffered by the compressor or the encrypted
+// stream is only counted once it's written, so the size lags behind slightly. It returns os.ErrClosed if the receiver
+// isn't started or was closed.
+func (s *HARFileReceiver) Size() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.fp == nil {
+		return 0, os.ErrClosed
+	}
+
+	if err := s.bw.Flush(); err != nil {
+		return 0, err
+	}
+
+	return s.counter.n, nil
+}
+
+// Close will flush and close the file, it will also dispose of all the recorded pages. Calling Close more than once is
+// safe; subsequent calls are no-ops.
+func (s *HARFileReceiver) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.fp == nil {
+		return nil
+	}
+
+	err := s.flush()
+	err = errors.Join(err, s.fp.Close())
+	if !s.flushed {
+		// The flush failed, the file keeps the entries of the last successful one.
+		_ = os.Remove(s.fp.Name())
+	}
+
+	s.fp = nil
+	s.pages = nil
+
+	return err
+}
+
+// create writes the beginning of the archive and the existing entries to a new temporary file, and flushes it, which
+// renames it over the target. The caller must hold the mutex.
+func (s *HARFileReceiver) create(fp *os.File, existing *existingArchive) error {
+	if err := fp.Chmod(0o644); err != nil {
+		return err
+	}
+
+	s.fp = fp
+	s.flushed = false
+	s.bw = bufio.NewWriterSize(fp, 64*1024)
+	s.gz = nil
+	s.enc = nil
+	s.tailOffset = 0
+	s.entryCount = 0
+
+	if s.keys != nil {
+		enc, err := encrypted.NewWriter(fp, s.keys)
+		if err != nil {
+			return err
+		}
+		s.enc = enc
+	}
+
+	if s.compress {
+		gz, err := gzip.NewWriterLevel(fp, s.compressionLevel)
+		if err != nil {
+			return err
+		}
+		s.gz = gz
+	}
+	s.startMember(0)
+
+	if existing != nil && existing.log == nil {
+		if err := s.copyExisting(existing); err != nil {
+			return err
+		}
+
+		return s.flush()
+	}
+
+	creatorBytes, err := json.Marshal(&har.Agent{
+		Name:    s.version.Creator,
+		Version: s.version.Version,
+	})
+	if err != nil {
+		return err
+	}
+
+	versionBytes, err := json.Marshal(s.version.HARVersion)
+	if err != nil {
+		return err
 	}
 
-	if _, err := s.fp.Seek(0, 0); err != nil {
+	if _, err = fmt.Fprintf(s.bw, `{"log":{"version":%s,"creator":%s,"entries":[`, versionBytes, creatorBytes); err != nil {
 		return err
 	}
+	s.dirty = true
 
-	if err := s.fp.Truncate(0); err != nil {
+	if existing != nil {
+		// The existing file was repaired, its pages and entries are written again.
+		pages := make([]json.RawMessage, 0, len(existing.log.Pages)+len(s.pages))
+		for _, page := range existing.log.Pages {
+			data, err := json.Marshal(page)
+			if err != nil {
+				return err
+			}
+			pages = append(pages, data)
+		}
+		s.pages = append(pages, s.pages...)
+
+		for _, entry := range existing.log.Entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			if err := s.writeEntry(data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.flush()
+}
+
+// copyExisting copies the existing document up to the end of its last entry. The caller must hold the mutex.
+func (s *HARFileReceiver) copyExisting(existing *existingArchive) error {
+	doc, err := s.openDocument()
+	if err != nil {
 		return err
 	}
+	defer doc.Close() //nolint:errcheck
 
-	if err := s.encoder.Encode(harLog); err != nil {
+	if _, err := io.CopyN(s.bw, doc, existing.size); err != nil {
 		return err
 	}
 
+	s.pages = append(existing.pages, s.pages...)
+	s.entryCount = existing.entries
+	s.dirty = true
+
 	return nil
 }
 
-// Close will flush and close the file, it will also dispose of all the recorded entries and pages.
-func (s *HARFileReceiver) Close() error {
-	if err := s.Flush(); err != nil {
+// writeEntry appends a serialized entry to the file. The caller must hold the mutex.
+func (s *HARFileReceiver) writeEntry(data []byte) error {
+	if s.flushed {
+		if err := s.reopen(); err != nil {
+			return err
+		}
+	}
+
+	if s.entryCount > 0 {
+		if _, err := s.bw.WriteString(",\n"); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.bw.Write(data); err != nil {
 		return err
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.entryCount++
+	s.dirty = true
+
+	return nil
+}
+
+// flush writes the remainder of the document after the last entry, truncates anything after it, syncs the temporary
+// copy and renames it over the file. The file position is moved back to the start of the remainder, where the next
+// entry is written once the file has been copied again (see: reopen). The caller must hold the mutex.
+func (s *HARFileReceiver) flush() error {
+	if s.fp == nil {
+		return os.ErrClosed
+	}
+
+	if s.flushed {
+		if !s.pagesDirty {
+			return nil
+		}
+
+		if err := s.reopen(); err != nil {
+			return err
+		}
+	}
+
+	if s.dirty {
+		if err := s.endMember(false); err != nil {
+			return err
+		}
+
+		offset, err := s.fp.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		s.tailOffset = offset
+		s.dirty = false
+	}
+
+	if _, err := s.fp.Seek(s.tailOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Close the entries array, write the pages array and close the log and root objects. Trailing newline matches
+	// json.Encoder behaviour.
+	s.startMember(s.tailOffset)
+	if _, err := s.bw.WriteString(`],"pages":[`); err != nil {
+		return err
+	}
+	for i, page := range s.pages {
+		if i > 0 {
+			if err := s.bw.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		if _, err := s.bw.Write(page); err != nil {
+			return err
+		}
+	}
+	if _, err := s.bw.WriteString("]}}\n"); err != nil {
+		return err
+	}
+
+	if err := s.endMember(true); err != nil {
+		return err
+	}
+
+	end, err := s.fp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fp.Truncate(end); err != nil {
+		return err
+	}
+
+	if err := s.fp.Sync(); err != nil {
+		return err
+	}
 
-	s.pages = make([]*har.Page, 0)
-	s.entries = make([]*har.Entry, 0)
+	if _, err := s.fp.Seek(s.tailOffset, io.SeekStart); err != nil {
+		return err
+	}
+	s.startMember(s.tailOffset)
+
+	if err := atomicfile.Rename(s.fp.Name(), s.fileName); err != nil {
+		return err
+	}
+	s.flushed = true
+	s.pagesDirty = false
+
+	return nil
+}
+
+// reopen replaces the flushed file with a new temporary copy of everything before the remainder of the document, so
+// the file itself is never modified. The caller must hold the mutex.
+func (s *HARFileReceiver) reopen() error {
+	tmp, err := atomicfile.CreateTemp(s.fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := copyFile(tmp, s.fp, s.tailOffset); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	// The file was synced by the last flush and is only read.
+	_ = s.fp.Close()
+
+	s.fp = tmp
+	s.flushed = false
+	s.startMember(s.tailOffset)
+
+	return nil
+}
+
+// copyFile copies the first n bytes of src to dst, leaving the position of dst at n.
+func copyFile(dst, src *os.File, n int64) error {
+	if err := dst.Chmod(0o644); err != nil {
+		return err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Copying from a limited *os.File lets the kernel copy the data where supported.
+	_, err := io.CopyN(dst, src, n)
+
+	return err
+}
+
+// startMember resets the writers to write at the current file position, which must be offset, starting a new gzip
+// member when compressing and continuing the encrypted stream at offset when encrypting.
+func (s *HARFileReceiver) startMember(offset int64) {
+	s.counter = countingWriter{w: s.fp, n: offset}
+
+	var w io.Writer = &s.counter
+	if s.enc != nil {
+		s.enc.Reset(&s.counter, offset)
+		w = s.enc
+	}
+
+	if s.gz == nil {
+		s.bw.Reset(w)
+		return
+	}
+
+	s.gz.Reset(w)
+	s.bw.Reset(s.gz)
+}
+
+// endMember pushes everything written to the file, ending the current gzip member when compressing. When encrypting,
+// the data is sealed into the final chunk of the stream if final is set.
+func (s *HARFileReceiver) endMember(final bool) error {
+	if err := s.bw.Flush(); err != nil {
+		return err
+	}
+
+	if s.gz != nil {
+		if err := s.gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	if s.enc == nil {
+		return nil
+	}
+
+	if final {
+		return s.enc.Close()
+	}
+
+	return s.enc.Flush()
+}
+
+// countingWriter counts the bytes written to w, starting at n.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// existingArchive describes the existing file Start appends to (see: WithAppend).
+type existingArchive struct {
+	size    int64             // length of the document up to the end of the last entry, which is copied as is
+	entries int               // number of entries in the document
+	pages   []json.RawMessage // pages after the entries
+	log     *har.Log          // set instead of the fields above if the file had to be repaired
+}
+
+// errUnexpectedLayout is returned by scanArchive for valid documents it can't append to, they're repaired instead.
+var errUnexpectedLayout = errors.New("unexpected document layout")
+
+// load scans the existing file, if there is one. A file that was cut short is repaired, other errors are returned
+// without touching the file. The caller must hold the mutex.
+func (s *HARFileReceiver) load() (*existingArchive, error) {
+	doc, err := s.openDocument()
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, io.EOF) {
+		// An empty file is treated like a missing one.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", s.fileName, err)
+	}
+
+	existing, err := scanArchive(doc)
+	_ = doc.Close()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err == nil {
+		return existing, nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, encrypted.ErrTruncated) &&
+		!errors.As(err, &syntaxErr) && !errors.Is(err, errUnexpectedLayout) {
+		return nil, fmt.Errorf("failed to read %q: %w", s.fileName, err)
+	}
+
+	if doc, err = s.openDocument(); err != nil {
+		return nil, fmt.Errorf("failed to read %q: %w", s.fileName, err)
+	}
+	defer doc.Close() //nolint:errcheck
+
+	archive, err := har.Repair(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to repair %q: %w", s.fileName, err)
+	}
+
+	return &existingArchive{log: archive.Log}, nil
+}
+
+// openDocument opens the existing file and returns a reader for the document in it, decrypting and decompressing it
+// as configured. It returns io.EOF if the file is empty.
+func (s *HARFileReceiver) openDocument() (io.ReadCloser, error) {
+	fp, err := os.Open(s.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := fp.Stat(); err == nil && info.Size() == 0 {
+		_ = fp.Close()
+		return nil, io.EOF
+	}
+
+	var r io.Reader = fp
+	if s.keys != nil {
+		r = encrypted.NewReader(fp, s.keys)
+	}
+
+	if s.compress {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			_ = fp.Close()
+			return nil, err
+		}
+		r = gz
+	}
+
+	return &documentReader{Reader: r, fp: fp}, nil
+}
+
+// documentReader reads the document from a file through any decryption and decompression.
+type documentReader struct {
+	io.Reader
+	fp *os.File
+}
+
+func (d *documentReader) Close() error {
+	return d.fp.Close()
+}
+
+// scanArchive finds the end of the last entry of a document and reads the pages after it. Only documents laid out like
+// the ones written by HARFileReceiver are supported: the "entries" array must be followed by nothing but the "pages"
+// array, which is written again after the new entries. It returns io.EOF if the document is empty.
+func scanArchive(r io.Reader) (*existingArchive, error) {
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	existing, err := scanLog(dec)
+	if errors.Is(err, io.EOF) {
+		// The document ends between two tokens.
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return existing, err
+}
+
+func scanLog(dec *json.Decoder) (*existingArchive, error) {
+	if key, err := nextKey(dec); err != nil {
+		return nil, err
+	} else if key != "log" {
+		return nil, errUnexpectedLayout
+	}
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	existing := &existingArchive{size: -1}
+	for dec.More() {
+		key, err := nextKey(dec)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case key == "entries" && existing.size < 0:
+			err = existing.scanEntries(dec)
+		case key != "pages" && existing.size < 0:
+			// Fields before the entries are copied as they are.
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		case key == "pages" && existing.size >= 0:
+			err = dec.Decode(&existing.pages)
+		default:
+			err = errUnexpectedLayout
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if existing.size < 0 {
+		return nil, errUnexpectedLayout
+	}
+
+	// The end of the log and the root object, and nothing after them.
+	for range 2 {
+		if err := expectDelim(dec, '}'); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := dec.Token(); err == nil {
+		return nil, errUnexpectedLayout
+	} else if !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+// scanEntries counts the entries of the "entries" array and records where the last one ends.
+func (e *existingArchive) scanEntries(dec *json.Decoder) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		var entry json.RawMessage
+		if err := dec.Decode(&entry); err != nil {
+			return err
+		}
+		e.entries++
+	}
+	e.size = dec.InputOffset()
+
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return errUnexpectedLayout
+	}
+
+	return nil
+}
+
+func nextKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := tok.(string)
+	if !ok {
+		return "", errUnexpectedLayout
+	}
 
-	return s.fp.Close()
+	return key, nil
 }
@@ -1,9 +1,13 @@
 package receiver
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/swedishborgie/daytripper/har"
 )
 
 func TestHARFileReceiverFlushAfterClose(t *testing.T) {
@@ -16,7 +20,11 @@ func TestHARFileReceiverFlushAfterClose(t *testing.T) {
 		t.Fatalf("Start: %v", err)
 	}
 
-	// Close the underlying file directly to force Seek to fail.
+	if err := recv.Entry(&har.Entry{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close the underlying file directly to force writing the entry to fail.
 	if err := recv.fp.Close(); err != nil {
 		t.Fatalf("closing fp directly: %v", err)
 	}
@@ -26,7 +34,7 @@ func TestHARFileReceiverFlushAfterClose(t *testing.T) {
 	}
 }
 
-func TestHARFileReceiverFlushTruncateError(t *testing.T) {
+func TestHARFileReceiverFlushWriteErrorKeepsFile(t *testing.T) {
 	t.Parallel()
 
 	dir := t.TempDir()
@@ -37,18 +45,102 @@ func TestHARFileReceiverFlushTruncateError(t *testing.T) {
 		t.Fatalf("Start: %v", err)
 	}
 
-	// Close the writable file and replace fp with a read-only handle.
-	// Seek(0,0) succeeds on a read-only file; Truncate fails with an error.
+	if err := recv.Entry(&har.Entry{Comment: "flushed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	// Copy the file for the next entry, then close the writable copy and replace fp with a read-only handle. Seek
+	// succeeds on a read-only file, writing the entry and the remainder of the document fails.
+	if err := recv.Entry(&har.Entry{Comment: "not flushed"}); err != nil {
+		t.Fatal(err)
+	}
 	_ = recv.fp.Close()
-	roFile, err := os.OpenFile(harPath, os.O_RDONLY, 0)
+	roFile, err := os.OpenFile(recv.fp.Name(), os.O_RDONLY, 0)
 	if err != nil {
 		t.Fatalf("opening read-only file: %v", err)
 	}
 	recv.fp = roFile
+	t.Cleanup(func() { _ = roFile.Close() })
 
 	if err := recv.Flush(); err == nil {
 		t.Fatal("Flush with read-only fp: expected error, got nil")
 	}
+	if err := recv.Close(); err == nil {
+		t.Fatal("Close with read-only fp: expected error, got nil")
+	}
+
+	// The file is left as it was after the last successful flush, and the failed copy is removed.
+	data, err := os.ReadFile(harPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(data, archive); err != nil {
+		t.Fatalf("file is not valid JSON: %v", err)
+	}
+	if len(archive.Log.Entries) != 1 || archive.Log.Entries[0].Comment != "flushed" {
+		t.Errorf("got entries %+v, want the flushed entry", archive.Log.Entries)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the HAR file to remain, got %d files", len(files))
+	}
+}
+
+func TestHARFileReceiverEncodeErrorKeepsFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	harPath := filepath.Join(dir, "test.har")
+	recv := NewHARFileReceiver(harPath)
+
+	if err := recv.Start(&Version{HARVersion: "1.2", Creator: "test", Version: "0.1"}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if err := recv.Entry(&har.Entry{Comment: "good"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	before, err := os.ReadFile(harPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A channel can't be encoded, the entry must be rejected without writing anything.
+	if err := recv.Entry(&har.Entry{Response: &har.Response{Error: make(chan int)}}); err == nil {
+		t.Fatal("Entry with unencodable entry: expected error, got nil")
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	after, err := os.ReadFile(harPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("failed flush modified the existing file")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the HAR file to remain, got %d files", len(files))
+	}
 }
 
 func TestHARFileReceiverStartPermissionError(t *testing.T) {
@@ -1,10 +1,19 @@
 package receiver_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/swedishborgie/daytripper/encrypted"
 	"github.com/swedishborgie/daytripper/har"
 	"github.com/swedishborgie/daytripper/receiver"
 )
@@ -71,3 +80,573 @@ func TestHarFileReceiver(t *testing.T) {
 		t.Errorf("got %s, want %s", harFile.Log.Creator.Version, "1.2.3")
 	}
 }
+
+func TestHarFileReceiverCompression(t *testing.T) {
+	t.Parallel()
+
+	fileName := filepath.Join(t.TempDir(), "test.har.gz")
+	recv := receiver.NewHARFileReceiver(fileName, receiver.WithCompression(gzip.DefaultCompression))
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	readBack := func() *har.HTTPArchive {
+		t.Helper()
+
+		fp, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fp.Close() //nolint:errcheck
+
+		gz, err := gzip.NewReader(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		archive := &har.HTTPArchive{}
+		if err := json.NewDecoder(gz).Decode(archive); err != nil {
+			t.Fatal(err)
+		}
+		return archive
+	}
+
+	for i := range 2 {
+		if err := recv.Entry(&har.Entry{Comment: "test entry"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := recv.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The file is a complete archive after every flush.
+		if got := len(readBack().Log.Entries); got != i+1 {
+			t.Errorf("got %d entries after flush %d, want %d", got, i+1, i+1)
+		}
+	}
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(readBack().Log.Entries); got != 2 {
+		t.Errorf("got %d entries after close, want 2", got)
+	}
+}
+
+func TestHarFileReceiverInvalidCompressionLevel(t *testing.T) {
+	t.Parallel()
+
+	recv := receiver.NewHARFileReceiver(filepath.Join(t.TempDir(), "test.har.gz"), receiver.WithCompression(42))
+	if err := recv.Start(&receiver.Version{}); err == nil {
+		t.Fatal("Start: expected error, got nil")
+	}
+}
+
+func TestHarFileReceiverAppend(t *testing.T) {
+	t.Parallel()
+
+	keys := encrypted.StaticKey("test", bytes.Repeat([]byte{1}, 32))
+
+	for _, tc := range []struct {
+		name       string
+		file       string
+		compressed bool
+		encrypted  bool
+	}{
+		{name: "plain", file: "test.har"},
+		{name: "compressed", file: "test.har.gz", compressed: true},
+		{name: "encrypted", file: "test.har.enc", encrypted: true},
+		{name: "compressed and encrypted", file: "test.har.gz.enc", compressed: true, encrypted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fileName := filepath.Join(t.TempDir(), tc.file)
+			version := &receiver.Version{HARVersion: "1.2", Creator: "test_creator", Version: "1.2.3"}
+
+			opts := []receiver.HARFileOption{receiver.WithAppend()}
+			if tc.compressed {
+				opts = append(opts, receiver.WithCompression(gzip.BestSpeed))
+			}
+			if tc.encrypted {
+				opts = append(opts, receiver.WithEncryption(keys))
+			}
+
+			for i, comment := range []string{"first", "second"} {
+				recv := receiver.NewHARFileReceiver(fileName, opts...)
+				if err := recv.Start(version); err != nil {
+					t.Fatalf("run %d: Start: %v", i, err)
+				}
+				if err := recv.Entry(&har.Entry{Comment: comment}); err != nil {
+					t.Fatal(err)
+				}
+				recv.Page(&har.Page{ID: comment})
+				if err := recv.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				if err := recv.Entry(&har.Entry{Comment: comment + " after flush"}); err != nil {
+					t.Fatal(err)
+				}
+				if err := recv.Close(); err != nil {
+					t.Fatalf("run %d: Close: %v", i, err)
+				}
+			}
+
+			fp, err := os.Open(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close() //nolint:errcheck
+
+			var r io.Reader = fp
+			if tc.encrypted {
+				r = encrypted.NewReader(fp, keys)
+			}
+			if tc.compressed {
+				gz, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gz
+			}
+
+			harFile := &har.HTTPArchive{}
+			if err := json.NewDecoder(r).Decode(harFile); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(harFile.Log.Entries) != 4 || harFile.Log.Entries[0].Comment != "first" ||
+				harFile.Log.Entries[3].Comment != "second after flush" {
+				t.Errorf("unexpected entries: %+v", harFile.Log.Entries)
+			}
+			if len(harFile.Log.Pages) != 2 || harFile.Log.Pages[0].ID != "first" || harFile.Log.Pages[1].ID != "second" {
+				t.Errorf("unexpected pages: %+v", harFile.Log.Pages)
+			}
+		})
+	}
+}
+
+func TestHarFileReceiverAppendInvalidFile(t *testing.T) {
+	t.Parallel()
+
+	fileName := filepath.Join(t.TempDir(), "test.har")
+	if err := os.WriteFile(fileName, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recv := receiver.NewHARFileReceiver(fileName, receiver.WithAppend())
+	if err := recv.Start(&receiver.Version{}); err == nil {
+		t.Fatal("Start: expected error, got nil")
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "not json" {
+		t.Error("existing file was overwritten")
+	}
+}
+
+func TestHarFileReceiverAppendKeepsExistingDocument(t *testing.T) {
+	t.Parallel()
+
+	// Written by another tool, the existing entries must be copied as they are rather than encoded again.
+	existing := `{"log":{"version":"1.2","creator":{"name":"other","version":"1"},` +
+		`"entries":[{"comment":"a",  "time":1}],"pages":[{"id":"page_1"}]}}`
+
+	fileName := filepath.Join(t.TempDir(), "test.har")
+	if err := os.WriteFile(fileName, []byte(existing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recv := receiver.NewHARFileReceiver(fileName, receiver.WithAppend())
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Entry(&har.Entry{Comment: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	recv.Page(&har.Page{ID: "page_2"})
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := existing[:strings.Index(existing, `],"pages"`)]
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		t.Errorf("the existing document was rewritten:\n%s", data)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(data, archive); err != nil {
+		t.Fatalf("file is not valid JSON: %v", err)
+	}
+	if len(archive.Log.Entries) != 2 || archive.Log.Entries[1].Comment != "b" {
+		t.Errorf("unexpected entries: %+v", archive.Log.Entries)
+	}
+	if len(archive.Log.Pages) != 2 || archive.Log.Pages[0].ID != "page_1" || archive.Log.Pages[1].ID != "page_2" {
+		t.Errorf("unexpected pages: %+v", archive.Log.Pages)
+	}
+}
+
+func TestHarFileReceiverAppendTruncatedFile(t *testing.T) {
+	t.Parallel()
+
+	keys := encrypted.StaticKey("test", bytes.Repeat([]byte{1}, 32))
+
+	for _, tc := range []struct {
+		name       string
+		compressed bool
+		encrypted  bool
+	}{
+		{name: "plain"},
+		{name: "compressed", compressed: true},
+		{name: "encrypted", encrypted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fileName := filepath.Join(t.TempDir(), "test.har")
+
+			opts := []receiver.HARFileOption{receiver.WithAppend()}
+			if tc.compressed {
+				opts = append(opts, receiver.WithCompression(gzip.BestSpeed))
+			}
+			if tc.encrypted {
+				opts = append(opts, receiver.WithEncryption(keys))
+			}
+
+			recv := receiver.NewHARFileReceiver(fileName, opts...)
+			if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := recv.Entry(&har.Entry{Comment: "kept"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := recv.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			size, err := recv.Size()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := recv.Entry(&har.Entry{Comment: strings.Repeat("x", 1024)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := recv.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Cut the file short in the middle of the second entry, e.g. by a crash while copying it elsewhere.
+			info, err := os.Stat(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(fileName, size+(info.Size()-size)/2); err != nil {
+				t.Fatal(err)
+			}
+
+			recv = receiver.NewHARFileReceiver(fileName, opts...)
+			if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			if err := recv.Entry(&har.Entry{Comment: "appended"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := recv.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fp, err := os.Open(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close() //nolint:errcheck
+
+			var r io.Reader = fp
+			if tc.encrypted {
+				r = encrypted.NewReader(fp, keys)
+			}
+			if tc.compressed {
+				gz, err := gzip.NewReader(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gz
+			}
+
+			archive := &har.HTTPArchive{}
+			if err := json.NewDecoder(r).Decode(archive); err != nil {
+				t.Fatal(err)
+			}
+			if len(archive.Log.Entries) != 2 || archive.Log.Entries[0].Comment != "kept" ||
+				archive.Log.Entries[1].Comment != "appended" {
+				t.Errorf("unexpected entries: %+v", archive.Log.Entries)
+			}
+		})
+	}
+}
+
+func TestHarFileReceiverIncrementalFlush(t *testing.T) {
+	t.Parallel()
+
+	fileName := filepath.Join(t.TempDir(), "test.har")
+	recv := receiver.NewHARFileReceiver(fileName)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	readBack := func() ([]byte, *har.HTTPArchive) {
+		t.Helper()
+
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		archive := &har.HTTPArchive{}
+		if err := json.Unmarshal(data, archive); err != nil {
+			t.Fatalf("file is not valid JSON: %v", err)
+		}
+		return data, archive
+	}
+
+	var previous []byte
+	for i := range 3 {
+		if err := recv.Entry(&har.Entry{Comment: fmt.Sprintf("entry %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+		recv.Page(&har.Page{ID: fmt.Sprintf("page_%d", i)})
+
+		// Flushing twice must not duplicate the end of the document.
+		for range 2 {
+			if err := recv.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data, archive := readBack()
+		if got := len(archive.Log.Entries); got != i+1 {
+			t.Fatalf("got %d entries after flush %d, want %d", got, i+1, i+1)
+		}
+		if got := archive.Log.Entries[i].Comment; got != fmt.Sprintf("entry %d", i) {
+			t.Errorf("got entry %q, want %q", got, fmt.Sprintf("entry %d", i))
+		}
+		if got := len(archive.Log.Pages); got != i+1 {
+			t.Errorf("got %d pages after flush %d, want %d", got, i+1, i+1)
+		}
+
+		// Earlier entries are never rewritten, only the end of the document after them.
+		if previous != nil {
+			entriesEnd := bytes.LastIndex(previous, []byte(`],"pages":`))
+			if !bytes.Equal(previous[:entriesEnd], data[:entriesEnd]) {
+				t.Error("previously flushed entries were modified")
+			}
+		}
+		previous = data
+	}
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Entry(&har.Entry{}); err == nil {
+		t.Error("Entry after Close: expected error, got nil")
+	}
+}
+
+func TestHarFileReceiverEntriesAfterFlush(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "test.har")
+	recv := receiver.NewHARFileReceiver(fileName)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := recv.Entry(&har.Entry{Comment: "flushed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	flushed, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry larger than the write buffer reaches the disk before the next flush, it must not touch the file a crash
+	// would leave behind.
+	large := strings.Repeat("x", 256*1024)
+	if err := recv.Entry(&har.Entry{Comment: large}); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(flushed, current) {
+		t.Fatal("the file was modified before the flush")
+	}
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(data, archive); err != nil {
+		t.Fatalf("file is not valid JSON: %v", err)
+	}
+	if len(archive.Log.Entries) != 2 || archive.Log.Entries[1].Comment != large {
+		t.Errorf("got %d entries, want both entries", len(archive.Log.Entries))
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the HAR file to remain, got %d files", len(files))
+	}
+}
+
+func TestHarFileReceiverEntryJSON(t *testing.T) {
+	t.Parallel()
+
+	fileName := filepath.Join(t.TempDir(), "test.har")
+	recv := receiver.NewHARFileReceiver(fileName)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(&har.Entry{Comment: "encoded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.EntryJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Entry(&har.Entry{Comment: "plain"}); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := json.Marshal(&har.Page{ID: "encoded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv.PageJSON(page)
+	recv.Page(&har.Page{ID: "plain"})
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(contents, archive); err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 2 || archive.Log.Entries[0].Comment != "encoded" ||
+		archive.Log.Entries[1].Comment != "plain" {
+		t.Errorf("unexpected entries: %+v", archive.Log.Entries)
+	}
+	if len(archive.Log.Pages) != 2 || archive.Log.Pages[0].ID != "encoded" || archive.Log.Pages[1].ID != "plain" {
+		t.Errorf("unexpected pages: %+v", archive.Log.Pages)
+	}
+}
+
+func TestHarFileReceiverSize(t *testing.T) {
+	t.Parallel()
+
+	t.Run("plain", func(t *testing.T) {
+		t.Parallel()
+
+		recv := receiver.NewHARFileReceiver(filepath.Join(t.TempDir(), "test.har"))
+		if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+			t.Fatal(err)
+		}
+
+		before, err := recv.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := json.Marshal(&har.Entry{Comment: "test entry"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recv.EntryJSON(data); err != nil {
+			t.Fatal(err)
+		}
+
+		// Entries are counted as soon as they're written, without a flush.
+		after, err := recv.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after != before+int64(len(data)) {
+			t.Errorf("got size %d after writing %d bytes, want %d", after, len(data), before+int64(len(data)))
+		}
+
+		if err := recv.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := recv.Size(); !errors.Is(err, os.ErrClosed) {
+			t.Errorf("Size after Close: got %v, want os.ErrClosed", err)
+		}
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		t.Parallel()
+
+		fileName := filepath.Join(t.TempDir(), "test.har.gz")
+		recv := receiver.NewHARFileReceiver(fileName, receiver.WithCompression(gzip.BestSpeed))
+		if err := recv.Start(&receiver.Version{HARVersion: "1.2"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Random comments barely compress, so the compressor has to produce output.
+		rnd := rand.New(rand.NewPCG(1, 2))
+		comment := make([]byte, 1024)
+		for range 200 {
+			for i := range comment {
+				comment[i] = byte('a' + rnd.IntN(26))
+			}
+			if err := recv.Entry(&har.Entry{Comment: string(comment)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		size, err := recv.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recv.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size < 50*1024 || size > info.Size() {
+			t.Errorf("got size %d, want the compressed size written so far (file is %d bytes)", size, info.Size())
+		}
+	})
+}
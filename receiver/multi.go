@@ -0,0 +1,196 @@
+package receiver
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+// Branch is a single child receiver of a Multi receiver, along with the filters and middleware that apply only to it.
+type Branch struct {
+	// Receiver is the child receiver.
+	Receiver Receiver
+	// Filter decides whether an entry is sent to this branch. If nil, all entries and pages are sent. Otherwise, only
+	// the pages referenced by entries the filter accepted are sent.
+	Filter func(*har.Entry) bool
+	// EntryMiddleware is applied to entries before they're passed to Receiver.
+	EntryMiddleware []EntryMiddleware
+	// PageMiddleware is applied to pages before they're passed to Receiver.
+	PageMiddleware []PageMiddleware
+}
+
+type branch struct {
+	Branch
+	sendEntry EntryReceiver
+	sendPage  PageReceiver
+
+	// pageRefs are the pages referenced by entries sent to a filtered branch that haven't been sent themselves yet.
+	mutex    sync.Mutex
+	pageRefs map[string]struct{}
+}
+
+// accept records that an entry was sent to a filtered branch, so the page it references is sent as well.
+func (b *branch) accept(entry *har.Entry) {
+	if b.Filter == nil || entry.PageRef == "" {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.pageRefs == nil {
+		b.pageRefs = make(map[string]struct{})
+	}
+	b.pageRefs[entry.PageRef] = struct{}{}
+}
+
+// wantsPage returns whether a page should be sent to the branch. Filtered branches only receive the pages referenced
+// by their entries, every page is sent once.
+func (b *branch) wantsPage(page *har.Page) bool {
+	if b.Filter == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.pageRefs[page.ID]; !ok {
+		return false
+	}
+	delete(b.pageRefs, page.ID)
+
+	return true
+}
+
+// Multi is a receiver that sends every entry and page to several child receivers, for example to write a complete HAR
+// file to disk while streaming a filtered and redacted copy somewhere else.
+//
+// Branches with middleware receive their own copy of each entry and page, so middleware in one branch (e.g. redaction)
+// never affects what the other branches receive.
+//
+// Failures in one branch don't stop the others: Entry, Flush and Close are always passed to every branch and the
+// errors of all branches are joined together. If Start fails, the branches that did start are closed again.
+type Multi struct {
+	branches []*branch
+}
+
+// NewMulti creates a new Multi receiver with the given branches.
+func NewMulti(branches ...Branch) *Multi {
+	m := &Multi{}
+
+	for _, b := range branches {
+		br := &branch{Branch: b}
+
+		br.sendEntry = b.Receiver.Entry
+		for _, mw := range b.EntryMiddleware {
+			br.sendEntry = mw(br.sendEntry)
+		}
+
+		br.sendPage = b.Receiver.Page
+		for _, mw := range b.PageMiddleware {
+			br.sendPage = mw(br.sendPage)
+		}
+
+		m.branches = append(m.branches, br)
+	}
+
+	return m
+}
+
+// Start starts every branch.
+func (m *Multi) Start(version *Version) error {
+	for i, b := range m.branches {
+		if err := b.Receiver.Start(version); err != nil {
+			errs := []error{err}
+			for _, started := range m.branches[:i] {
+				errs = append(errs, started.Receiver.Close())
+			}
+			return errors.Join(errs...)
+		}
+	}
+
+	return nil
+}
+
+// Entry sends the entry to every branch whose filter accepts it.
+func (m *Multi) Entry(entry *har.Entry) error {
+	var (
+		encoded   []byte
+		encodeErr error
+		errs      []error
+	)
+
+	for _, b := range m.branches {
+		if b.Filter != nil && !b.Filter(entry) {
+			continue
+		}
+		b.accept(entry)
+
+		toSend := entry
+		if len(b.EntryMiddleware) > 0 {
+			// The entry is encoded once for every branch that needs a copy. If that fails, those branches are skipped
+			// and the others still receive the entry.
+			if encoded == nil && encodeErr == nil {
+				encoded, encodeErr = json.Marshal(entry)
+				errs = append(errs, encodeErr)
+			}
+			if encodeErr != nil {
+				continue
+			}
+
+			toSend = &har.Entry{}
+			if err := json.Unmarshal(encoded, toSend); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		errs = append(errs, b.sendEntry(toSend))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Page sends the page to every branch without a filter, and to the filtered branches that received entries referencing
+// it.
+func (m *Multi) Page(page *har.Page) {
+	for _, b := range m.branches {
+		if !b.wantsPage(page) {
+			continue
+		}
+
+		toSend := page
+		if len(b.PageMiddleware) > 0 {
+			pg := *page
+			if page.PageTimings != nil {
+				timings := *page.PageTimings
+				pg.PageTimings = &timings
+			}
+			toSend = &pg
+		}
+
+		b.sendPage(toSend)
+	}
+}
+
+// Flush flushes every branch.
+func (m *Multi) Flush() error {
+	errs := make([]error, 0, len(m.branches))
+	for _, b := range m.branches {
+		errs = append(errs, b.Receiver.Flush())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close closes every branch.
+func (m *Multi) Close() error {
+	errs := make([]error, 0, len(m.branches))
+	for _, b := range m.branches {
+		errs = append(errs, b.Receiver.Close())
+	}
+
+	return errors.Join(errs...)
+}
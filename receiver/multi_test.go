@@ -0,0 +1,216 @@
+package receiver_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+type failingReceiver struct {
+	*receiver.MemoryReceiver
+	err    error
+	closed bool
+}
+
+func (f *failingReceiver) Start(*receiver.Version) error { return f.err }
+func (f *failingReceiver) Flush() error                  { return f.err }
+
+func (f *failingReceiver) Entry(entry *har.Entry) error {
+	_ = f.MemoryReceiver.Entry(entry)
+	return f.err
+}
+
+func (f *failingReceiver) Close() error {
+	f.closed = true
+	return f.err
+}
+
+func TestMultiReceiver(t *testing.T) {
+	t.Parallel()
+
+	full := receiver.NewMemoryReceiver()
+	redacted := receiver.NewMemoryReceiver()
+
+	multi := receiver.NewMulti(
+		receiver.Branch{Receiver: full},
+		receiver.Branch{
+			Receiver: redacted,
+			Filter: func(entry *har.Entry) bool {
+				return entry.Comment != "skip"
+			},
+			EntryMiddleware: []receiver.EntryMiddleware{func(next receiver.EntryReceiver) receiver.EntryReceiver {
+				return func(entry *har.Entry) error {
+					entry.Request.Headers = nil
+					return next(entry)
+				}
+			}},
+			PageMiddleware: []receiver.PageMiddleware{func(next receiver.PageReceiver) receiver.PageReceiver {
+				return func(page *har.Page) {
+					page.Title = "redacted"
+					next(page)
+				}
+			}},
+		},
+	)
+
+	if err := multi.Start(&receiver.Version{Creator: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := []*har.Header{{Name: "Authorization", Value: "secret"}}
+	for _, comment := range []string{"keep", "skip"} {
+		entry := &har.Entry{PageRef: "page_1", Comment: comment, Request: &har.Request{Headers: headers}}
+		if err := multi.Entry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	multi.Page(&har.Page{ID: "page_1", Title: "Page", PageTimings: &har.PageTimings{}})
+
+	if err := multi.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := multi.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if full.Version.Creator != "test" || redacted.Version.Creator != "test" {
+		t.Error("branches weren't started")
+	}
+	if len(full.Entries) != 2 {
+		t.Fatalf("full branch got %d entries, want 2", len(full.Entries))
+	}
+	if len(redacted.Entries) != 1 {
+		t.Fatalf("redacted branch got %d entries, want 1", len(redacted.Entries))
+	}
+	if len(full.Entries[0].Request.Headers) != 1 {
+		t.Error("middleware in one branch changed the entry of another branch")
+	}
+	if redacted.Entries[0].Request.Headers != nil {
+		t.Error("branch middleware wasn't applied")
+	}
+	if full.Pages[0].Title != "Page" || redacted.Pages[0].Title != "redacted" {
+		t.Errorf("page titles = %q and %q, want %q and %q", full.Pages[0].Title, redacted.Pages[0].Title, "Page", "redacted")
+	}
+}
+
+func TestMultiReceiverPartialFailure(t *testing.T) {
+	t.Parallel()
+
+	errFirst := errors.New("first")
+	errSecond := errors.New("second")
+	first := &failingReceiver{MemoryReceiver: receiver.NewMemoryReceiver(), err: errFirst}
+	healthy := receiver.NewMemoryReceiver()
+	second := &failingReceiver{MemoryReceiver: receiver.NewMemoryReceiver(), err: errSecond}
+
+	multi := receiver.NewMulti(
+		receiver.Branch{Receiver: first},
+		receiver.Branch{Receiver: healthy},
+		receiver.Branch{Receiver: second},
+	)
+
+	err := multi.Entry(&har.Entry{})
+	if !errors.Is(err, errFirst) || !errors.Is(err, errSecond) {
+		t.Errorf("Entry error = %v, want both branch errors", err)
+	}
+	if len(healthy.Entries) != 1 {
+		t.Errorf("healthy branch got %d entries, want 1", len(healthy.Entries))
+	}
+
+	if err := multi.Flush(); !errors.Is(err, errFirst) || !errors.Is(err, errSecond) {
+		t.Errorf("Flush error = %v, want both branch errors", err)
+	}
+
+	if err := multi.Close(); !errors.Is(err, errFirst) || !errors.Is(err, errSecond) {
+		t.Errorf("Close error = %v, want both branch errors", err)
+	}
+	if !first.closed || !second.closed {
+		t.Error("not every branch was closed")
+	}
+}
+
+func TestMultiReceiverCopyFailure(t *testing.T) {
+	t.Parallel()
+
+	withMiddleware := receiver.NewMemoryReceiver()
+	plain := receiver.NewMemoryReceiver()
+
+	passThrough := func(next receiver.EntryReceiver) receiver.EntryReceiver { return next }
+	multi := receiver.NewMulti(
+		receiver.Branch{Receiver: withMiddleware, EntryMiddleware: []receiver.EntryMiddleware{passThrough}},
+		receiver.Branch{Receiver: plain},
+	)
+
+	// A channel can't be encoded, so the entry can't be copied for the branch with middleware.
+	if err := multi.Entry(&har.Entry{Response: &har.Response{Error: make(chan int)}}); err == nil {
+		t.Error("Entry: expected an error copying the entry")
+	}
+	if len(withMiddleware.Entries) != 0 {
+		t.Errorf("branch with middleware got %d entries, want 0", len(withMiddleware.Entries))
+	}
+	if len(plain.Entries) != 1 {
+		t.Errorf("other branch got %d entries, want 1", len(plain.Entries))
+	}
+}
+
+func TestMultiReceiverStartFailure(t *testing.T) {
+	t.Parallel()
+
+	errStart := errors.New("start")
+	started := &failingReceiver{MemoryReceiver: receiver.NewMemoryReceiver()}
+	failing := &failingReceiver{MemoryReceiver: receiver.NewMemoryReceiver(), err: errStart}
+
+	multi := receiver.NewMulti(receiver.Branch{Receiver: started}, receiver.Branch{Receiver: failing})
+	if err := multi.Start(&receiver.Version{}); !errors.Is(err, errStart) {
+		t.Fatalf("Start error = %v, want %v", err, errStart)
+	}
+	if !started.closed {
+		t.Error("started branch wasn't closed after a failed start")
+	}
+	if failing.closed {
+		t.Error("branch that failed to start was closed")
+	}
+}
+
+func TestMultiReceiverFilteredPages(t *testing.T) {
+	t.Parallel()
+
+	full := receiver.NewMemoryReceiver()
+	filtered := receiver.NewMemoryReceiver()
+
+	multi := receiver.NewMulti(
+		receiver.Branch{Receiver: full},
+		receiver.Branch{
+			Receiver: filtered,
+			Filter: func(entry *har.Entry) bool {
+				return entry.PageRef != "page_2"
+			},
+		},
+	)
+
+	if err := multi.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pageRef := range []string{"page_1", "page_2", "page_2"} {
+		if err := multi.Entry(&har.Entry{PageRef: pageRef}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	multi.Page(&har.Page{ID: "page_1"})
+	multi.Page(&har.Page{ID: "page_2"})
+	multi.Page(&har.Page{ID: "page_3"})
+
+	if err := multi.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(full.Pages) != 3 {
+		t.Errorf("unfiltered branch got %d pages, want 3", len(full.Pages))
+	}
+	// Every entry of page_2 was rejected and page_3 has no entries, only page_1 is referenced by the filtered branch.
+	if len(filtered.Pages) != 1 || filtered.Pages[0].ID != "page_1" {
+		t.Errorf("filtered branch got pages %+v, want only page_1", filtered.Pages)
+	}
+}
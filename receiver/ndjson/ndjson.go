@@ -0,0 +1,106 @@
+// Package ndjson provides a receiver that writes newline delimited JSON (JSON Lines) along with a reader to convert the
+// output back into a HAR document.
+//
+// Every line is a self-contained JSON Record. The first line is a header record containing the receiver.Version, the
+// following lines are entry and page records in the order they were received. Unlike streaming.Receiver, a log that
+// was cut short by a crash stays readable up to the last complete line, and it can be processed line by line with
+// standard tools.
+package ndjson
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// RecordType identifies the contents of a Record.
+type RecordType string
+
+const (
+	// RecordTypeHeader is the first record of a log, it contains the version information.
+	RecordTypeHeader RecordType = "header"
+	// RecordTypeEntry contains a single entry.
+	RecordTypeEntry RecordType = "entry"
+	// RecordTypePage contains a single page.
+	RecordTypePage RecordType = "page"
+)
+
+// Record is a single line of an NDJSON log.
+type Record struct {
+	// Type is the type of the record, it determines which of the other fields is set.
+	Type RecordType `json:"type"`
+	// Version is set for header records.
+	Version *receiver.Version `json:"version,omitempty"`
+	// Entry is set for entry records.
+	Entry *har.Entry `json:"entry,omitempty"`
+	// Page is set for page records.
+	Page *har.Page `json:"page,omitempty"`
+}
+
+// Receiver writes every entry and page to the provided writer as its own line as soon as it arrives.
+//
+// The caller is responsible for closing the underlying writer after Close() returns.
+type Receiver struct {
+	mutex sync.Mutex
+	bw    *bufio.Writer
+}
+
+// New creates a new Receiver that writes NDJSON output to w. The caller retains ownership of w and is responsible for
+// closing it after Close() returns.
+func New(w io.Writer) *Receiver {
+	return &Receiver{
+		bw: bufio.NewWriterSize(w, 64*1024),
+	}
+}
+
+// Start writes the header record and flushes it to the underlying writer.
+func (r *Receiver) Start(version *receiver.Version) error {
+	if err := r.write(&Record{Type: RecordTypeHeader, Version: version}); err != nil {
+		return err
+	}
+
+	return r.Flush()
+}
+
+// Entry writes an entry record.
+func (r *Receiver) Entry(entry *har.Entry) error {
+	return r.write(&Record{Type: RecordTypeEntry, Entry: entry})
+}
+
+// Page writes a page record.
+func (r *Receiver) Page(page *har.Page) {
+	_ = r.write(&Record{Type: RecordTypePage, Page: page})
+}
+
+// Flush pushes the internal write buffer to the underlying writer. Every complete line is a valid record.
+func (r *Receiver) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.bw.Flush()
+}
+
+// Close flushes the internal write buffer. The caller is responsible for closing the underlying writer afterward.
+func (r *Receiver) Close() error {
+	return r.Flush()
+}
+
+func (r *Receiver) write(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, err := r.bw.Write(data); err != nil {
+		return err
+	}
+
+	return r.bw.WriteByte('\n')
+}
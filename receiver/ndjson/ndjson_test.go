@@ -0,0 +1,120 @@
+package ndjson_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/ndjson"
+)
+
+func writeLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	recv := ndjson.New(&buf)
+	if err := recv.Start(&receiver.Version{HARVersion: "1.2", Creator: "test", Version: "0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []string{"first", "second"} {
+		if err := recv.Entry(&har.Entry{Comment: comment, PageRef: "page_1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv.Page(&har.Page{ID: "page_1", PageTimings: &har.PageTimings{}})
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestReceiverWritesOneRecordPerLine(t *testing.T) {
+	t.Parallel()
+
+	buf := writeLog(t)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4:\n%s", len(lines), buf.String())
+	}
+
+	wantTypes := []ndjson.RecordType{
+		ndjson.RecordTypeHeader, ndjson.RecordTypeEntry, ndjson.RecordTypeEntry, ndjson.RecordTypePage,
+	}
+	for i, line := range lines {
+		record := &ndjson.Record{}
+		if err := json.Unmarshal([]byte(line), record); err != nil {
+			t.Fatalf("line %d isn't valid JSON: %v", i+1, err)
+		}
+		if record.Type != wantTypes[i] {
+			t.Errorf("line %d: type = %q, want %q", i+1, record.Type, wantTypes[i])
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	archive, err := ndjson.Convert(writeLog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if archive.Log.Version != "1.2" || archive.Log.Creator.Name != "test" || archive.Log.Creator.Version != "0.1" {
+		t.Errorf("log = %+v, creator = %+v, want the header version", archive.Log, archive.Log.Creator)
+	}
+	if len(archive.Log.Entries) != 2 || archive.Log.Entries[1].Comment != "second" {
+		t.Errorf("got %d entries, want both entries in order", len(archive.Log.Entries))
+	}
+	if len(archive.Log.Pages) != 1 {
+		t.Errorf("got %d pages, want 1", len(archive.Log.Pages))
+	}
+}
+
+func TestConvertTruncated(t *testing.T) {
+	t.Parallel()
+
+	buf := writeLog(t)
+	// Simulate a crash halfway through writing the last record.
+	truncated := buf.Bytes()[:buf.Len()-10]
+
+	reader := ndjson.NewReader(bytes.NewReader(truncated))
+	var err error
+	for err == nil {
+		_, err = reader.Next()
+	}
+	if !errors.Is(err, ndjson.ErrTruncated) {
+		t.Errorf("Next error = %v, want %v", err, ndjson.ErrTruncated)
+	}
+
+	archive, err := ndjson.Convert(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Log.Entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(archive.Log.Entries))
+	}
+	if len(archive.Log.Pages) != 0 {
+		t.Errorf("got %d pages, want the truncated page to be dropped", len(archive.Log.Pages))
+	}
+}
+
+func TestConvertInvalidLine(t *testing.T) {
+	t.Parallel()
+
+	_, err := ndjson.Convert(strings.NewReader("{\"type\":\"header\"}\nnot json\n{\"type\":\"entry\",\"entry\":{}}\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Convert error = %v, want an error for line 2", err)
+	}
+
+	reader := ndjson.NewReader(strings.NewReader("\n\n"))
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Next on empty lines = %v, want %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,100 @@
+package ndjson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+// ErrTruncated is returned by Reader.Next when the log ends with an incomplete line, which typically happens when the
+// writing process crashed.
+var ErrTruncated = errors.New("ndjson log ends with a truncated record")
+
+// Reader reads records from an NDJSON log one line at a time.
+type Reader struct {
+	br   *bufio.Reader
+	line int
+}
+
+// NewReader creates a new Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Next returns the next record. It returns io.EOF once all records have been read, or ErrTruncated if the last line is
+// incomplete. Empty lines are skipped.
+func (r *Reader) Next() (*Record, error) {
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		r.line++
+
+		complete := err == nil
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if !complete {
+				return nil, io.EOF
+			}
+			continue
+		}
+
+		record := &Record{}
+		if jsonErr := json.Unmarshal(line, record); jsonErr != nil {
+			if !complete {
+				return nil, ErrTruncated
+			}
+			return nil, fmt.Errorf("invalid record on line %d: %w", r.line, jsonErr)
+		}
+
+		return record, nil
+	}
+}
+
+// Convert reads an NDJSON log and converts it into a HAR document. A truncated last line is ignored, so logs from
+// processes that crashed can still be converted.
+func Convert(r io.Reader) (*har.HTTPArchive, error) {
+	log := &har.Log{
+		Creator: &har.Agent{},
+		Pages:   make([]*har.Page, 0),
+		Entries: make([]*har.Entry, 0),
+	}
+
+	reader := NewReader(r)
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrTruncated) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch record.Type {
+		case RecordTypeHeader:
+			if record.Version != nil {
+				log.Version = record.Version.HARVersion
+				log.Creator = &har.Agent{
+					Name:    record.Version.Creator,
+					Version: record.Version.Version,
+				}
+			}
+		case RecordTypeEntry:
+			if record.Entry != nil {
+				log.Entries = append(log.Entries, record.Entry)
+			}
+		case RecordTypePage:
+			if record.Page != nil {
+				log.Pages = append(log.Pages, record.Page)
+			}
+		}
+	}
+
+	return &har.HTTPArchive{Log: log}, nil
+}
@@ -0,0 +1,115 @@
+package push
+
+import (
+	"net/http"
+	"time"
+)
+
+type Option func(r *Receiver)
+
+// Format is the format of the batches sent to the collector.
+type Format int
+
+const (
+	// FormatNDJSON sends every batch as newline delimited JSON, in the format of ndjson.Receiver: a header record
+	// followed by entry and page records. Batches can be converted with ndjson.Convert.
+	FormatNDJSON Format = iota
+	// FormatHAR sends every batch as a HAR document containing the entries and pages of the batch.
+	FormatHAR
+)
+
+// WithFormat sets the format of the batches. The default is FormatNDJSON.
+func WithFormat(format Format) Option {
+	return func(r *Receiver) {
+		r.format = format
+	}
+}
+
+// WithClient sets the HTTP client used to send batches. The client must not be recorded by a DayTripper, since
+// recording the requests sending entries would create new entries to send. By default, a client with its own transport
+// is used.
+func WithClient(client *http.Client) Option {
+	return func(r *Receiver) {
+		r.client = client
+	}
+}
+
+// WithHeader adds a header to every request sent to the collector, e.g. for authentication.
+func WithHeader(key, value string) Option {
+	return func(r *Receiver) {
+		r.header.Add(key, value)
+	}
+}
+
+// WithCompression gzip compresses every batch with the given compression level (e.g. gzip.DefaultCompression).
+func WithCompression(level int) Option {
+	return func(r *Receiver) {
+		r.compress = true
+		r.compressionLevel = level
+	}
+}
+
+// WithMaxBatchEntries sets the maximum number of entries in a batch. The default is 100.
+func WithMaxBatchEntries(maxEntries int) Option {
+	return func(r *Receiver) {
+		r.maxEntries = maxEntries
+	}
+}
+
+// WithMaxBatchBytes sets the maximum encoded size of the entries and pages in a batch, before compression. A batch
+// is sent once it reaches the limit or adding an entry would exceed it, a single entry larger than the limit is sent on
+// its own. The default is 1 MiB.
+func WithMaxBatchBytes(maxBytes int) Option {
+	return func(r *Receiver) {
+		r.maxBytes = maxBytes
+	}
+}
+
+// WithMaxBatchDelay sets the maximum time entries are held before their batch is sent. The default is 5 seconds.
+func WithMaxBatchDelay(maxDelay time.Duration) Option {
+	return func(r *Receiver) {
+		r.maxDelay = maxDelay
+	}
+}
+
+// WithMaxQueuedBatches sets the maximum number of batches waiting to be sent, e.g. while a batch is retried. Once the
+// limit is reached the oldest waiting batch is dropped for every new one. If maxBatches is 0 the number of batches
+// isn't limited. The default is 100.
+func WithMaxQueuedBatches(maxBatches int) Option {
+	return func(r *Receiver) {
+		r.maxQueued = maxBatches
+	}
+}
+
+// WithRetry sets how often sending a batch is attempted, and the delay before the first retry. The delay doubles after
+// every attempt. Batches are retried after network errors and responses with status 408, 429 or 5xx. The default is 3
+// attempts with a delay of one second. Once Close is called failed batches aren't retried anymore, they're kept in the
+// disk buffer or dropped right away.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(r *Receiver) {
+		r.attempts = max(attempts, 1)
+		r.backoff = backoff
+	}
+}
+
+// WithDiskBuffer keeps batches that couldn't be sent in dir, using at most maxBytes, until the collector is reachable
+// again. While batches are buffered, new batches are added to the buffer rather than sent, so the collector receives
+// them in order. The buffer is retried whenever a batch is due, or after the maximum batch delay if there are no new
+// entries. Once the buffer is full the oldest batches are dropped, if maxBytes is 0 the size of the buffer isn't
+// limited. Batches left over from an earlier run are sent as well.
+//
+// Without a disk buffer, batches that couldn't be sent are dropped.
+func WithDiskBuffer(dir string, maxBytes int64) Option {
+	return func(r *Receiver) {
+		r.bufferDir = dir
+		r.bufferMaxBytes = maxBytes
+	}
+}
+
+// WithErrorHandler sets a function that is called with every error sending batches, including batches that were
+// dropped. Without a handler, the last 100 errors are returned from Flush or Close.
+func WithErrorHandler(handler func(error)) Option {
+	return func(r *Receiver) {
+		r.onError = handler
+	}
+}
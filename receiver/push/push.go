@@ -0,0 +1,406 @@
+// Package push provides a receiver that sends entries to a collector endpoint over HTTP in batches.
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// Receiver POSTs batches of entries and pages to a collector. Entries are encoded when they're received and collected
+// into batches, which are sent by a background worker once they're full (see: WithMaxBatchEntries and
+// WithMaxBatchBytes) or old enough (see: WithMaxBatchDelay). Pages are sent with the next batch.
+//
+// Failed requests are retried with exponential backoff (see: WithRetry). Batches that still couldn't be sent are kept
+// on disk until the collector is reachable again if a disk buffer is configured (see: WithDiskBuffer), and dropped
+// otherwise. If batches are queued faster than they're sent, the oldest queued batches are dropped (see:
+// WithMaxQueuedBatches).
+//
+// Flush waits until every entry received so far was sent, buffered on disk or dropped.
+type Receiver struct {
+	url              string
+	client           *http.Client
+	header           http.Header
+	format           Format
+	compress         bool
+	compressionLevel int
+	maxEntries       int
+	maxBytes         int
+	maxDelay         time.Duration
+	maxQueued        int
+	attempts         int
+	backoff          time.Duration
+	bufferDir        string
+	bufferMaxBytes   int64
+	onError          func(error)
+
+	mutex          sync.Mutex
+	version        *receiver.Version
+	current        *batch
+	queue          []*batch
+	droppedEntries int // entries dropped from the queue since the worker last reported them
+	errs           []error
+	droppedErrs    int // errors dropped since errs was last returned
+	started        bool
+	closed         bool
+
+	// backlog are the names of the batches in the disk buffer, oldest first. It's only used by the worker.
+	backlog []string
+	seq     int
+
+	signal chan struct{}
+	flushC chan chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// batch is a set of encoded entries and pages sent in a single request.
+type batch struct {
+	entries [][]byte
+	pages   [][]byte
+	size    int
+	created time.Time
+}
+
+// maxErrors is the number of errors kept to be returned from Flush or Close, older errors are dropped.
+const maxErrors = 100
+
+// New creates a new Receiver sending batches to url. Nothing is sent until Start is called.
+func New(url string, opts ...Option) *Receiver {
+	r := &Receiver{
+		url:        url,
+		client:     &http.Client{Transport: newTransport(), Timeout: 30 * time.Second},
+		header:     make(http.Header),
+		maxEntries: 100,
+		maxBytes:   1024 * 1024,
+		maxDelay:   5 * time.Second,
+		maxQueued:  100,
+		attempts:   3,
+		backoff:    time.Second,
+		version:    &receiver.Version{},
+		signal:     make(chan struct{}, 1),
+		flushC:     make(chan chan struct{}),
+		stop:       make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+// newTransport returns a transport of its own, http.DefaultTransport may be recorded.
+func newTransport() http.RoundTripper {
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		return t.Clone()
+	}
+
+	return &http.Transport{Proxy: http.ProxyFromEnvironment}
+}
+
+// Start loads the batches left in the disk buffer by an earlier run and starts the background worker.
+func (r *Receiver) Start(version *receiver.Version) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.started {
+		return nil
+	}
+
+	r.version = version
+
+	if r.bufferDir != "" {
+		if err := os.MkdirAll(r.bufferDir, 0o755); err != nil {
+			return err
+		}
+
+		backlog, err := r.loadBacklog()
+		if err != nil {
+			return err
+		}
+		r.backlog = backlog
+	}
+
+	r.started = true
+	r.wg.Add(1)
+	go r.worker()
+
+	return nil
+}
+
+// Entry adds an entry to the current batch, the batch is queued for sending once it's full.
+func (r *Receiver) Entry(entry *har.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	if r.current != nil && (len(r.current.entries) >= r.maxEntries || r.current.size+len(data) > r.maxBytes) {
+		r.seal()
+	}
+
+	r.add(data, false)
+
+	// An entry larger than the limit is sent on its own.
+	if len(r.current.entries) >= r.maxEntries || r.current.size >= r.maxBytes {
+		r.seal()
+	}
+
+	return nil
+}
+
+// Page adds a page to the current batch.
+func (r *Receiver) Page(page *har.Page) {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	r.add(data, true)
+}
+
+// Flush queues the current batch and waits until every queued batch was sent, buffered on disk or dropped. It returns
+// the errors sending batches since the last Flush, unless an error handler is set (see: WithErrorHandler).
+func (r *Receiver) Flush() error {
+	r.mutex.Lock()
+	if r.closed || !r.started {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.seal()
+	r.mutex.Unlock()
+
+	done := make(chan struct{})
+	select {
+	case r.flushC <- done:
+		<-done
+	case <-r.stop:
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.takeErrors()
+}
+
+// Close sends the remaining batches, stops the background worker and returns the errors sending batches, unless an
+// error handler is set (see: WithErrorHandler). Calling Close more than once is safe; subsequent calls are no-ops.
+func (r *Receiver) Close() error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.closed = true
+	started := r.started
+	r.mutex.Unlock()
+
+	if started {
+		close(r.stop)
+		r.wg.Wait()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.takeErrors()
+}
+
+// add adds an encoded entry or page to the current batch. The caller must hold the mutex.
+func (r *Receiver) add(data []byte, page bool) {
+	if r.current == nil {
+		r.current = &batch{created: time.Now()}
+		r.notify()
+	}
+
+	if page {
+		r.current.pages = append(r.current.pages, data)
+	} else {
+		r.current.entries = append(r.current.entries, data)
+	}
+	r.current.size += len(data)
+}
+
+// seal queues the current batch for sending, dropping the oldest queued batch if the queue is full. The caller must
+// hold the mutex.
+func (r *Receiver) seal() {
+	if r.current == nil {
+		return
+	}
+
+	if r.maxQueued > 0 && len(r.queue) >= r.maxQueued {
+		r.droppedEntries += len(r.queue[0].entries)
+		r.queue = slices.Delete(r.queue, 0, 1)
+	}
+
+	r.queue = append(r.queue, r.current)
+	r.current = nil
+	r.notify()
+}
+
+func (r *Receiver) notify() {
+	select {
+	case r.signal <- struct{}{}:
+	default:
+		// The worker is already signalled.
+	}
+}
+
+// report passes an error to the error handler, or keeps it to be returned from Flush or Close. Only the last maxErrors
+// errors are kept.
+func (r *Receiver) report(err error) {
+	if r.onError != nil {
+		r.onError(err)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.errs) >= maxErrors {
+		r.errs = slices.Delete(r.errs, 0, 1)
+		r.droppedErrs++
+	}
+	r.errs = append(r.errs, err)
+}
+
+// takeErrors returns the kept errors and clears them. The caller must hold the mutex.
+func (r *Receiver) takeErrors() error {
+	errs := r.errs
+	if r.droppedErrs > 0 {
+		errs = append([]error{fmt.Errorf("%d earlier errors were dropped", r.droppedErrs)}, errs...)
+	}
+
+	r.errs = nil
+	r.droppedErrs = 0
+
+	return errors.Join(errs...)
+}
+
+func (r *Receiver) worker() {
+	defer r.wg.Done()
+
+	timer := time.NewTimer(r.maxDelay)
+	timer.Stop()
+
+	for {
+		var timeout <-chan time.Time
+		if wait, ok := r.nextDeadline(); ok {
+			timer.Reset(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-r.signal:
+			r.process()
+		case <-timeout:
+			r.process()
+		case done := <-r.flushC:
+			r.process()
+			close(done)
+		case <-r.stop:
+			r.mutex.Lock()
+			r.seal()
+			r.mutex.Unlock()
+			r.process()
+			return
+		}
+
+		timer.Stop()
+	}
+}
+
+// nextDeadline returns how long the worker can wait before the current batch is due, or the disk buffer should be
+// retried.
+func (r *Receiver) nextDeadline() (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.current != nil {
+		return max(time.Until(r.current.created.Add(r.maxDelay)), 0), true
+	}
+
+	if len(r.backlog) > 0 {
+		return r.maxDelay, true
+	}
+
+	return 0, false
+}
+
+// process queues the current batch if it's due and sends the queued batches. Batches in the disk buffer are sent
+// first, while any remain new batches are added to the buffer.
+func (r *Receiver) process() {
+	r.mutex.Lock()
+	if r.current != nil && time.Since(r.current.created) >= r.maxDelay {
+		r.seal()
+	}
+	queue := r.queue
+	r.queue = nil
+	dropped := r.droppedEntries
+	r.droppedEntries = 0
+	version := r.version
+	r.mutex.Unlock()
+
+	if dropped > 0 {
+		r.report(fmt.Errorf("dropped %d entries, too many batches were waiting to be sent", dropped))
+	}
+
+	if len(queue) == 0 && len(r.backlog) == 0 {
+		return
+	}
+
+	r.drainBacklog()
+
+	for _, b := range queue {
+		body, err := r.encode(version, b)
+		if err != nil {
+			r.report(err)
+			continue
+		}
+
+		if len(r.backlog) == 0 {
+			err := r.send(body, r.contentType(), r.compress)
+			if err == nil {
+				continue
+			}
+			r.report(err)
+
+			var permanent *permanentError
+			if errors.As(err, &permanent) {
+				continue
+			}
+		}
+
+		if r.bufferDir == "" {
+			r.report(errDropped(len(b.entries)))
+			continue
+		}
+
+		if err := r.spill(body); err != nil {
+			r.report(err)
+		}
+	}
+}
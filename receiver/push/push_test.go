@@ -0,0 +1,458 @@
+package push_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/ndjson"
+	"github.com/swedishborgie/daytripper/receiver/push"
+)
+
+var testVersion = &receiver.Version{HARVersion: "1.2", Creator: "test", Version: "0.1"}
+
+// collector records the batches it receives. It responds with the status returned by status, 200 if it's nil.
+type collector struct {
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	status   func(n int) int
+	count    atomic.Int32
+}
+
+func newCollector(t *testing.T, status func(n int) int) (*collector, *httptest.Server) {
+	t.Helper()
+
+	c := &collector{status: status}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := int(c.count.Add(1))
+		if c.status != nil {
+			if code := c.status(n); code != http.StatusOK {
+				w.WriteHeader(code)
+				return
+			}
+		}
+
+		var body io.Reader = req.Body
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(req.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+
+		data, err := io.ReadAll(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		c.mutex.Lock()
+		c.requests = append(c.requests, req)
+		c.bodies = append(c.bodies, data)
+		c.mutex.Unlock()
+	}))
+	t.Cleanup(svr.Close)
+
+	return c, svr
+}
+
+// comments returns the comments of the entries in the NDJSON batches received so far, one slice per batch.
+func (c *collector) comments(t *testing.T) [][]string {
+	t.Helper()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var batches [][]string
+	for _, body := range c.bodies {
+		archive, err := ndjson.Convert(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var comments []string
+		for _, entry := range archive.Log.Entries {
+			comments = append(comments, entry.Comment)
+		}
+		batches = append(batches, comments)
+	}
+
+	return batches
+}
+
+func sendEntries(t *testing.T, recv *push.Receiver, from, to int) {
+	t.Helper()
+
+	for i := from; i < to; i++ {
+		if err := recv.Entry(&har.Entry{Comment: fmt.Sprintf("entry %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBatchByCount(t *testing.T) {
+	t.Parallel()
+
+	c, svr := newCollector(t, nil)
+	recv := push.New(svr.URL,
+		push.WithMaxBatchEntries(2),
+		push.WithMaxBatchDelay(time.Hour),
+		push.WithCompression(gzip.BestSpeed),
+		push.WithHeader("Authorization", "Bearer token"),
+	)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	sendEntries(t, recv, 0, 5)
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := c.comments(t)
+	if fmt.Sprint(got) != "[[entry 0 entry 1] [entry 2 entry 3] [entry 4]]" {
+		t.Errorf("got batches %v", got)
+	}
+
+	req := c.requests[0]
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/x-ndjson" ||
+		req.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("unexpected request: %s %v", req.Method, req.Header)
+	}
+}
+
+func TestBatchBySize(t *testing.T) {
+	t.Parallel()
+
+	c, svr := newCollector(t, nil)
+	recv := push.New(svr.URL, push.WithMaxBatchBytes(100), push.WithMaxBatchDelay(time.Hour))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every entry is about 80 bytes, so only one fits in a batch.
+	sendEntries(t, recv, 0, 3)
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.comments(t); len(got) != 3 {
+		t.Errorf("got batches %v, want one entry per batch", got)
+	}
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchOversizedEntry(t *testing.T) {
+	t.Parallel()
+
+	c, svr := newCollector(t, nil)
+	recv := push.New(svr.URL, push.WithMaxBatchBytes(10), push.WithMaxBatchDelay(time.Hour))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close() //nolint:errcheck
+
+	// The entry exceeds the limit on its own, it's sent right away rather than after the maximum delay.
+	sendEntries(t, recv, 0, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.comments(t)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("oversized entry wasn't sent on its own")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchByTime(t *testing.T) {
+	t.Parallel()
+
+	c, svr := newCollector(t, nil)
+	recv := push.New(svr.URL, push.WithMaxBatchDelay(10*time.Millisecond))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close() //nolint:errcheck
+
+	sendEntries(t, recv, 0, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.comments(t)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch wasn't sent after the maximum delay")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFormatHAR(t *testing.T) {
+	t.Parallel()
+
+	c, svr := newCollector(t, nil)
+	recv := push.New(svr.URL, push.WithFormat(push.FormatHAR))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	sendEntries(t, recv, 0, 2)
+	recv.Page(&har.Page{ID: "page_1"})
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.bodies) != 1 || c.requests[0].Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d batches, want 1 HAR document", len(c.bodies))
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.Unmarshal(c.bodies[0], archive); err != nil {
+		t.Fatal(err)
+	}
+	if archive.Log.Version != "1.2" || len(archive.Log.Entries) != 2 || len(archive.Log.Pages) != 1 {
+		t.Errorf("unexpected log: %+v", archive.Log)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	// The collector is unavailable for the first two attempts.
+	c, svr := newCollector(t, func(n int) int {
+		if n <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	recv := push.New(svr.URL, push.WithRetry(3, time.Millisecond))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retries are given up on Close, Flush waits for them.
+	sendEntries(t, recv, 0, 1)
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.comments(t); fmt.Sprint(got) != "[[entry 0]]" {
+		t.Errorf("got batches %v", got)
+	}
+}
+
+func TestCloseStopsRetries(t *testing.T) {
+	t.Parallel()
+
+	_, svr := newCollector(t, func(int) int { return http.StatusServiceUnavailable })
+	recv := push.New(svr.URL, push.WithRetry(3, time.Hour))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	sendEntries(t, recv, 0, 1)
+
+	// Close must not wait out the hour between attempts.
+	done := make(chan error, 1)
+	go func() {
+		done <- recv.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Close: expected an error for the dropped batch")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the retry backoff")
+	}
+}
+
+func TestRejected(t *testing.T) {
+	t.Parallel()
+
+	c, svr := newCollector(t, func(int) int { return http.StatusBadRequest })
+	recv := push.New(svr.URL, push.WithRetry(3, time.Millisecond), push.WithDiskBuffer(t.TempDir(), 1024*1024))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	sendEntries(t, recv, 0, 1)
+	if err := recv.Close(); err == nil {
+		t.Error("Close: expected an error for the rejected batch")
+	}
+
+	if got := c.count.Load(); got != 1 {
+		t.Errorf("got %d attempts, want a rejected batch not to be retried", got)
+	}
+}
+
+func TestMaxQueuedBatches(t *testing.T) {
+	t.Parallel()
+
+	// The collector holds the first request until the other batches are queued.
+	release := make(chan struct{})
+	c, svr := newCollector(t, func(n int) int {
+		if n == 1 {
+			<-release
+		}
+		return http.StatusOK
+	})
+	recv := push.New(svr.URL, push.WithMaxBatchEntries(1), push.WithMaxQueuedBatches(2))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	sendEntries(t, recv, 0, 1)
+	for c.count.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	sendEntries(t, recv, 1, 6)
+	close(release)
+
+	err := recv.Flush()
+	if err == nil || !strings.Contains(err.Error(), "dropped 3 entries") {
+		t.Errorf("Flush: got %v, want the dropped entries reported", err)
+	}
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.comments(t); fmt.Sprint(got) != "[[entry 0] [entry 4] [entry 5]]" {
+		t.Errorf("got batches %v, want the oldest queued batches dropped", got)
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	t.Parallel()
+
+	_, svr := newCollector(t, func(int) int { return http.StatusBadRequest })
+	recv := push.New(svr.URL, push.WithMaxBatchEntries(1), push.WithMaxQueuedBatches(0))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	sendEntries(t, recv, 0, 150)
+
+	// Only the last errors are kept, Flush returns and clears them.
+	err := recv.Flush()
+	if err == nil || !strings.Contains(err.Error(), "50 earlier errors were dropped") {
+		t.Errorf("Flush: got %v, want the dropped errors counted", err)
+	}
+	if err := recv.Close(); err != nil {
+		t.Errorf("Close: got %v, want the errors to be returned only once", err)
+	}
+}
+
+func TestDiskBuffer(t *testing.T) {
+	t.Parallel()
+
+	var down atomic.Bool
+	down.Store(true)
+	c, svr := newCollector(t, func(int) int {
+		if down.Load() {
+			return http.StatusBadGateway
+		}
+		return http.StatusOK
+	})
+
+	dir := t.TempDir()
+	var errs atomic.Int32
+	opts := []push.Option{
+		push.WithMaxBatchEntries(1),
+		push.WithMaxBatchDelay(time.Hour),
+		push.WithRetry(2, time.Millisecond),
+		push.WithDiskBuffer(dir, 650),
+		push.WithErrorHandler(func(error) { errs.Add(1) }),
+	}
+
+	// The collector is down, the batches (about 200 bytes each) are kept on disk and the oldest one is dropped once
+	// the buffer is full.
+	recv := push.New(svr.URL, opts...)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	sendEntries(t, recv, 0, 4)
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d buffered batches, want 3", len(files))
+	}
+	if errs.Load() == 0 {
+		t.Error("expected errors to be reported")
+	}
+
+	// Once the collector is back, the buffered batches are sent before new ones.
+	down.Store(false)
+	recv = push.New(svr.URL, opts...)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	sendEntries(t, recv, 4, 5)
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.comments(t); fmt.Sprint(got) != "[[entry 1] [entry 2] [entry 3] [entry 4]]" {
+		t.Errorf("got batches %v", got)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("got %d buffered batches after sending, want 0", len(files))
+	}
+}
+
+func TestDiskBufferUnlimited(t *testing.T) {
+	t.Parallel()
+
+	_, svr := newCollector(t, func(int) int { return http.StatusBadGateway })
+
+	dir := t.TempDir()
+	recv := push.New(svr.URL,
+		push.WithMaxBatchEntries(1),
+		push.WithMaxBatchDelay(time.Hour),
+		push.WithRetry(1, time.Millisecond),
+		push.WithDiskBuffer(dir, 0),
+		push.WithErrorHandler(func(error) {}),
+	)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	sendEntries(t, recv, 0, 3)
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a size limit no batches are dropped.
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("got %d buffered batches, want 3", len(files))
+	}
+}
@@ -0,0 +1,338 @@
+package push
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/internal/atomicfile"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeHAR    = "application/json"
+)
+
+// permanentError is returned by send when the collector rejected a batch, sending it again won't help.
+type permanentError struct {
+	status int
+}
+
+func (e *permanentError) Error() string {
+	return fmt.Sprintf("collector rejected batch with status %d", e.status)
+}
+
+func errDropped(entries int) error {
+	return fmt.Errorf("dropped batch with %d entries", entries)
+}
+
+func (r *Receiver) contentType() string {
+	if r.format == FormatHAR {
+		return contentTypeHAR
+	}
+
+	return contentTypeNDJSON
+}
+
+// encode encodes a batch in the configured format, compressing it if configured.
+func (r *Receiver) encode(version *receiver.Version, b *batch) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if r.compress {
+		var err error
+		if gz, err = gzip.NewWriterLevel(&buf, r.compressionLevel); err != nil {
+			return nil, err
+		}
+		w = gz
+	}
+
+	var err error
+	if r.format == FormatHAR {
+		err = encodeHAR(w, version, b)
+	} else {
+		err = encodeNDJSON(w, version, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeNDJSON writes a header record followed by the entry and page records, like ndjson.Receiver.
+func encodeNDJSON(w io.Writer, version *receiver.Version, b *batch) error {
+	versionBytes, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, `{"type":"header","version":%s}`+"\n", versionBytes); err != nil {
+		return err
+	}
+
+	for _, entry := range b.entries {
+		if _, err := fmt.Fprintf(w, `{"type":"entry","entry":%s}`+"\n", entry); err != nil {
+			return err
+		}
+	}
+
+	for _, page := range b.pages {
+		if _, err := fmt.Fprintf(w, `{"type":"page","page":%s}`+"\n", page); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeHAR writes a HAR document containing the entries and pages.
+func encodeHAR(w io.Writer, version *receiver.Version, b *batch) error {
+	creatorBytes, err := json.Marshal(&har.Agent{Name: version.Creator, Version: version.Version})
+	if err != nil {
+		return err
+	}
+
+	versionBytes, err := json.Marshal(version.HARVersion)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, `{"log":{"version":%s,"creator":%s,"entries":[`, versionBytes, creatorBytes); err != nil {
+		return err
+	}
+
+	writeArray := func(values [][]byte) error {
+		for i, value := range values {
+			if i > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := writeArray(b.entries); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `],"pages":[`); err != nil {
+		return err
+	}
+	if err := writeArray(b.pages); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]}}\n")
+	return err
+}
+
+// send POSTs a batch to the collector, retrying with exponential backoff. Retries are given up once Close is called,
+// so Close doesn't wait out the backoff. A *permanentError is returned if the collector rejected the batch.
+func (r *Receiver) send(body []byte, contentType string, compressed bool) error {
+	backoff := r.backoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = r.post(body, contentType, compressed); err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt >= r.attempts {
+			return fmt.Errorf("failed to send batch to %q after %d attempts: %w", r.url, attempt, err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-r.stop:
+			timer.Stop()
+			return fmt.Errorf("failed to send batch to %q after %d attempts, stopped retrying on Close: %w",
+				r.url, attempt, err)
+		}
+		backoff *= 2
+	}
+}
+
+func (r *Receiver) post(body []byte, contentType string, compressed bool) error {
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	rsp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, rsp.Body)
+	_ = rsp.Body.Close()
+
+	switch {
+	case rsp.StatusCode >= 200 && rsp.StatusCode < 300:
+		return nil
+	case rsp.StatusCode == http.StatusRequestTimeout, rsp.StatusCode == http.StatusTooManyRequests,
+		rsp.StatusCode >= 500:
+		return fmt.Errorf("collector responded with status %d", rsp.StatusCode)
+	default:
+		return &permanentError{status: rsp.StatusCode}
+	}
+}
+
+// backlogName returns the name of a new file in the disk buffer. The name sorts after existing files, and records the
+// format of the batch so it can be sent after the configuration changed.
+func (r *Receiver) backlogName() string {
+	r.seq++
+
+	ext := ".ndjson"
+	if r.format == FormatHAR {
+		ext = ".json"
+	}
+	if r.compress {
+		ext += ".gz"
+	}
+
+	return fmt.Sprintf("batch-%020d-%06d%s", time.Now().UnixNano(), r.seq, ext)
+}
+
+// loadBacklog returns the names of the batches in the disk buffer, oldest first.
+func (r *Receiver) loadBacklog() ([]string, error) {
+	dirEntries, err := os.ReadDir(r.bufferDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.IsDir() {
+			continue
+		}
+
+		if strings.HasPrefix(name, ".batch-") && strings.Contains(name, ".tmp") {
+			// Left over from a crash while writing the batch (see: atomicfile).
+			_ = os.Remove(filepath.Join(r.bufferDir, name))
+			continue
+		}
+
+		if !strings.HasPrefix(name, "batch-") {
+			continue
+		}
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// spill adds a batch to the disk buffer, dropping the oldest batches if the buffer is full. The batch is written
+// atomically, so a partially written batch is never sent, even after a power loss.
+func (r *Receiver) spill(body []byte) error {
+	name := r.backlogName()
+	if err := atomicfile.WriteFile(filepath.Join(r.bufferDir, name), body, 0o644); err != nil {
+		return err
+	}
+
+	r.backlog = append(r.backlog, name)
+
+	return r.trimBacklog()
+}
+
+// trimBacklog removes the oldest batches until the disk buffer is within its size limit, if it has one.
+func (r *Receiver) trimBacklog() error {
+	if r.bufferMaxBytes <= 0 {
+		return nil
+	}
+
+	sizes := make([]int64, len(r.backlog))
+	var total int64
+	for i, name := range r.backlog {
+		if info, err := os.Stat(filepath.Join(r.bufferDir, name)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+
+	var errs []error
+	for len(r.backlog) > 0 && total > r.bufferMaxBytes {
+		if err := os.Remove(filepath.Join(r.bufferDir, r.backlog[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		errs = append(errs, fmt.Errorf("disk buffer is full, dropped batch %q", r.backlog[0]))
+
+		total -= sizes[0]
+		sizes = sizes[1:]
+		r.backlog = r.backlog[1:]
+	}
+
+	return errors.Join(errs...)
+}
+
+// drainBacklog sends the batches in the disk buffer, oldest first, stopping at the first one that can't be sent. Each
+// batch is attempted once, the buffer is retried later.
+func (r *Receiver) drainBacklog() {
+	for len(r.backlog) > 0 {
+		name := r.backlog[0]
+		fileName := filepath.Join(r.bufferDir, name)
+
+		body, err := os.ReadFile(fileName)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				r.report(err)
+			}
+			r.backlog = r.backlog[1:]
+			continue
+		}
+
+		contentType := contentTypeNDJSON
+		trimmed := strings.TrimSuffix(name, ".gz")
+		if strings.HasSuffix(trimmed, ".json") {
+			contentType = contentTypeHAR
+		}
+
+		err = r.post(body, contentType, strings.HasSuffix(name, ".gz"))
+
+		var permanent *permanentError
+		if err != nil && !errors.As(err, &permanent) {
+			return
+		}
+		if err != nil {
+			r.report(fmt.Errorf("dropped buffered batch %q: %w", name, err))
+		}
+
+		if err := os.Remove(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			r.report(err)
+		}
+		r.backlog = r.backlog[1:]
+	}
+}
@@ -0,0 +1,86 @@
+package receiver
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+// Query selects entries from the receivers that can be read back, see checkpoint.Manifest and store.Store. Zero fields
+// match everything.
+type Query struct {
+	// From and To select entries that were in progress at any point between them.
+	From, To time.Time
+	// PageID selects entries belonging to a page.
+	PageID string
+	// Host selects entries with requests to a host name.
+	Host string
+	// StatusCode selects entries with a response status code.
+	StatusCode int
+}
+
+// EntrySummary contains the fields of an entry a Query matches on. Receivers can keep summaries in their indexes to
+// match entries without reading them.
+type EntrySummary struct {
+	// Start is the start time of the entry, End is the start time plus the total time.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Host is the host name of the request.
+	Host string `json:"host,omitempty"`
+	// Status is the response status code, 0 if there was no response.
+	Status int `json:"status,omitempty"`
+	// PageRef is the page the entry belongs to.
+	PageRef string `json:"pageRef,omitempty"`
+}
+
+// SummarizeEntry returns the fields of an entry a Query matches on.
+func SummarizeEntry(entry *har.Entry) EntrySummary {
+	summary := EntrySummary{
+		Start:   entry.StartedDateTime,
+		End:     entry.StartedDateTime.Add(time.Duration(entry.Time)),
+		PageRef: entry.PageRef,
+	}
+
+	if entry.Request != nil {
+		if u, err := url.Parse(entry.Request.URL); err == nil {
+			summary.Host = u.Hostname()
+		}
+	}
+
+	if entry.Response != nil {
+		summary.Status = entry.Response.Status
+	}
+
+	return summary
+}
+
+// Overlaps returns whether entries in progress between start and end may match the time range of the query.
+func (q *Query) Overlaps(start, end time.Time) bool {
+	if !q.From.IsZero() && end.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && start.After(q.To) {
+		return false
+	}
+
+	return true
+}
+
+// Matches returns whether an entry with the given summary matches the query.
+func (q *Query) Matches(summary *EntrySummary) bool {
+	if !q.Overlaps(summary.Start, summary.End) {
+		return false
+	}
+	if q.PageID != "" && summary.PageRef != q.PageID {
+		return false
+	}
+	if q.Host != "" && summary.Host != q.Host {
+		return false
+	}
+	if q.StatusCode != 0 && summary.Status != q.StatusCode {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,47 @@
+package receiver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+func TestQueryMatches(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	summary := receiver.SummarizeEntry(&har.Entry{
+		PageRef:         "page_1",
+		StartedDateTime: start,
+		Time:            har.DurationMS(time.Minute),
+		Request:         &har.Request{URL: "https://example.com:8443/path"},
+		Response:        &har.Response{Status: 404},
+	})
+
+	for _, tc := range []struct {
+		name  string
+		query receiver.Query
+		want  bool
+	}{
+		{name: "all", want: true},
+		{name: "in progress", query: receiver.Query{From: start.Add(30 * time.Second), To: start.Add(time.Hour)}, want: true},
+		{name: "ended before", query: receiver.Query{From: start.Add(2 * time.Minute)}},
+		{name: "started after", query: receiver.Query{To: start.Add(-time.Second)}},
+		{name: "page", query: receiver.Query{PageID: "page_1"}, want: true},
+		{name: "other page", query: receiver.Query{PageID: "page_2"}},
+		{name: "host without port", query: receiver.Query{Host: "example.com"}, want: true},
+		{name: "other host", query: receiver.Query{Host: "example.org"}},
+		{name: "status", query: receiver.Query{StatusCode: 404, Host: "example.com"}, want: true},
+		{name: "other status", query: receiver.Query{StatusCode: 200}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tc.query.Matches(&summary); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
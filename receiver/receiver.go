@@ -5,11 +5,11 @@ import "github.com/swedishborgie/daytripper/har"
 // Version is a message sent to Receiver.Start so that a Receiver can be aware of the version context of the recorder.
 type Version struct {
 	// The version of the HAR spec to adhere to.
-	HARVersion string
+	HARVersion string `json:"harVersion"`
 	// Version is the version of the creating utility.
-	Version string
+	Version string `json:"version"`
 	// Creator is the name of the creating utility.
-	Creator string
+	Creator string `json:"creator"`
 }
 
 // Receiver is an interface capable of accepting page and entry information from the recorder.
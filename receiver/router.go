@@ -0,0 +1,337 @@
+package receiver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+)
+
+// RouteKeyFunc returns the key of the child receiver an entry should be routed to (see: RouteByHost and RouteByPage).
+type RouteKeyFunc func(entry *har.Entry) string
+
+// ReceiverFactory creates a new child receiver for a key. The Router starts the receiver before using it. A key may be
+// seen again after its receiver was closed for being idle, so the factory should not overwrite earlier output (e.g.
+// by including a timestamp in the file name).
+type ReceiverFactory func(key string) (Receiver, error)
+
+// Router is a receiver that sends each entry to a child receiver chosen by a key function, such as one HAR file per
+// host or per tenant. Child receivers are created lazily by a factory the first time their key is seen.
+//
+// Pages are sent to every child that has received an entry referencing the page. Pages that arrive before any of
+// their entries are dropped.
+//
+// Every child has a lock of its own, a child that is slow to create, write to or close doesn't hold up the others.
+type Router struct {
+	keyFunc     RouteKeyFunc
+	factory     ReceiverFactory
+	idleTimeout time.Duration
+
+	mutex    sync.Mutex
+	version  *Version
+	children map[string]*routedChild
+	closed   bool
+	idleErrs []error
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type routedChild struct {
+	// lastUsed and pageRefs are guarded by the mutex of the Router.
+	lastUsed time.Time
+	pageRefs map[string]struct{}
+
+	// ready is closed once the receiver was created and started, or err is set.
+	ready chan struct{}
+	err   error
+
+	mutex  sync.Mutex
+	recv   Receiver
+	closed bool
+}
+
+// use calls fn with the receiver of the child while holding its lock. It returns false without calling fn if the
+// child couldn't be created or was closed.
+func (c *routedChild) use(fn func(recv Receiver)) bool {
+	<-c.ready
+	if c.err != nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	fn(c.recv)
+
+	return true
+}
+
+// close closes the receiver of the child, if it was created.
+func (c *routedChild) close() error {
+	<-c.ready
+	if c.err != nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	return c.recv.Close()
+}
+
+// NewRouter creates a new Router. If idleTimeout is greater than zero, child receivers that haven't received anything
+// for that long are closed.
+func NewRouter(keyFunc RouteKeyFunc, factory ReceiverFactory, idleTimeout time.Duration) *Router {
+	return &Router{
+		keyFunc:     keyFunc,
+		factory:     factory,
+		idleTimeout: idleTimeout,
+		children:    make(map[string]*routedChild),
+		stop:        make(chan struct{}),
+	}
+}
+
+// RouteByHost routes entries by the host name of the request URL.
+func RouteByHost() RouteKeyFunc {
+	return func(entry *har.Entry) string {
+		if entry.Request == nil {
+			return ""
+		}
+
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return ""
+		}
+
+		return u.Hostname()
+	}
+}
+
+// RouteByPage routes entries by the page they belong to.
+func RouteByPage() RouteKeyFunc {
+	return func(entry *har.Entry) string {
+		return entry.PageRef
+	}
+}
+
+// Start stores the version to start child receivers with, and starts closing idle children if configured.
+func (r *Router) Start(version *Version) error {
+	r.mutex.Lock()
+	r.version = version
+	r.mutex.Unlock()
+
+	if r.idleTimeout > 0 {
+		r.wg.Add(1)
+		go r.closeIdle()
+	}
+
+	return nil
+}
+
+// Entry routes the entry to its child receiver, creating the child if needed. It returns os.ErrClosed once the Router
+// was closed.
+func (r *Router) Entry(entry *har.Entry) error {
+	key := r.keyFunc(entry)
+
+	for {
+		child, err := r.child(key, entry.PageRef)
+		if err != nil {
+			return err
+		}
+
+		if child.use(func(recv Receiver) { err = recv.Entry(entry) }) {
+			return err
+		}
+
+		if child.err != nil {
+			return child.err
+		}
+
+		// The child was closed for being idle in the meantime, a new one is created.
+		r.remove(key, child)
+	}
+}
+
+// child returns the child for key, creating it if there is none. The child is created and started without holding
+// the mutex of the Router, other children can be used meanwhile. It returns os.ErrClosed once the Router was closed, so
+// no children are created that would never be closed.
+func (r *Router) child(key, pageRef string) (*routedChild, error) {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return nil, os.ErrClosed
+	}
+
+	child := r.children[key]
+	created := child == nil
+	if created {
+		child = &routedChild{pageRefs: make(map[string]struct{}), ready: make(chan struct{})}
+		r.children[key] = child
+	}
+	child.lastUsed = time.Now()
+	if pageRef != "" {
+		child.pageRefs[pageRef] = struct{}{}
+	}
+	version := r.version
+	r.mutex.Unlock()
+
+	if !created {
+		return child, nil
+	}
+
+	recv, err := r.factory(key)
+	if err != nil {
+		child.err = fmt.Errorf("failed to create receiver for %q: %w", key, err)
+	} else if err := recv.Start(version); err != nil {
+		child.err = fmt.Errorf("failed to start receiver for %q: %w", key, err)
+	}
+	child.recv = recv
+	close(child.ready)
+
+	if child.err != nil {
+		r.remove(key, child)
+	}
+
+	return child, nil
+}
+
+// remove removes the child for key, unless it was replaced already.
+func (r *Router) remove(key string, child *routedChild) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.children[key] == child {
+		delete(r.children, key)
+	}
+}
+
+// snapshot returns the current children.
+func (r *Router) snapshot() []*routedChild {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	children := make([]*routedChild, 0, len(r.children))
+	for _, child := range r.children {
+		children = append(children, child)
+	}
+
+	return children
+}
+
+// Page sends the page to every child that has received an entry referencing it.
+func (r *Router) Page(page *har.Page) {
+	var children []*routedChild
+
+	r.mutex.Lock()
+	for _, child := range r.children {
+		if _, ok := child.pageRefs[page.ID]; !ok {
+			continue
+		}
+
+		delete(child.pageRefs, page.ID)
+		child.lastUsed = time.Now()
+		children = append(children, child)
+	}
+	r.mutex.Unlock()
+
+	for _, child := range children {
+		child.use(func(recv Receiver) { recv.Page(page) })
+	}
+}
+
+// Flush flushes every child receiver.
+func (r *Router) Flush() error {
+	children := r.snapshot()
+
+	errs := make([]error, 0, len(children))
+	for _, child := range children {
+		child.use(func(recv Receiver) { errs = append(errs, recv.Flush()) })
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close closes every child receiver. The returned error includes any errors from closing idle children earlier.
+func (r *Router) Close() error {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	r.wg.Wait()
+
+	r.mutex.Lock()
+	r.closed = true
+	errs := r.idleErrs
+	r.idleErrs = nil
+	children := r.children
+	r.children = make(map[string]*routedChild)
+	r.mutex.Unlock()
+
+	for _, child := range children {
+		errs = append(errs, child.close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Keys returns the keys of the child receivers that are currently open.
+func (r *Router) Keys() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]string, 0, len(r.children))
+	for key := range r.children {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (r *Router) closeIdle() {
+	defer r.wg.Done()
+
+	// Very short timeouts are checked every millisecond, a ticker needs a positive interval.
+	ticker := time.NewTicker(max(r.idleTimeout/2, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			idle := make(map[string]*routedChild)
+			r.mutex.Lock()
+			for key, child := range r.children {
+				if now.Sub(child.lastUsed) >= r.idleTimeout {
+					idle[key] = child
+				}
+			}
+			r.mutex.Unlock()
+
+			// The children are removed once they're closed, entries for them wait for the close and then create a
+			// new child.
+			for key, child := range idle {
+				if err := child.close(); err != nil {
+					r.mutex.Lock()
+					r.idleErrs = append(r.idleErrs, fmt.Errorf("failed to close idle receiver for %q: %w", key, err))
+					r.mutex.Unlock()
+				}
+				r.remove(key, child)
+			}
+		}
+	}
+}
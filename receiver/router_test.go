@@ -0,0 +1,221 @@
+package receiver_test
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+type routerTest struct {
+	mutex   sync.Mutex
+	created map[string][]*failingReceiver
+}
+
+func (rt *routerTest) factory(key string) (receiver.Receiver, error) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	if key == "bad" {
+		return nil, errors.New("bad key")
+	}
+
+	recv := &failingReceiver{MemoryReceiver: receiver.NewMemoryReceiver()}
+	rt.created[key] = append(rt.created[key], recv)
+	return recv, nil
+}
+
+func (rt *routerTest) get(key string) []*failingReceiver {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	return rt.created[key]
+}
+
+func entryForURL(url, pageRef string) *har.Entry {
+	return &har.Entry{PageRef: pageRef, Request: &har.Request{URL: url}}
+}
+
+func TestRouterByHost(t *testing.T) {
+	t.Parallel()
+
+	rt := &routerTest{created: make(map[string][]*failingReceiver)}
+	router := receiver.NewRouter(receiver.RouteByHost(), rt.factory, 0)
+	if err := router.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []*har.Entry{
+		entryForURL("https://a.example.com/1", "page_1"),
+		entryForURL("https://b.example.com:8443/1", "page_1"),
+		entryForURL("https://a.example.com/2", "page_2"),
+	} {
+		if err := router.Entry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router.Page(&har.Page{ID: "page_1"})
+	router.Page(&har.Page{ID: "page_2"})
+	router.Page(&har.Page{ID: "page_3"})
+
+	keys := router.Keys()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"a.example.com", "b.example.com"}) {
+		t.Errorf("keys = %v, want a.example.com and b.example.com", keys)
+	}
+
+	if err := router.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := rt.get("a.example.com"), rt.get("b.example.com")
+	if len(a) != 1 || len(b) != 1 {
+		t.Fatalf("created %d and %d receivers, want 1 each", len(a), len(b))
+	}
+	if len(a[0].Entries) != 2 || len(a[0].Pages) != 2 {
+		t.Errorf("a.example.com got %d entries and %d pages, want 2 and 2", len(a[0].Entries), len(a[0].Pages))
+	}
+	if len(b[0].Entries) != 1 || len(b[0].Pages) != 1 {
+		t.Errorf("b.example.com got %d entries and %d pages, want 1 and 1", len(b[0].Entries), len(b[0].Pages))
+	}
+	if !a[0].closed || !b[0].closed {
+		t.Error("children weren't closed")
+	}
+}
+
+func TestRouterIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	rt := &routerTest{created: make(map[string][]*failingReceiver)}
+	router := receiver.NewRouter(receiver.RouteByPage(), rt.factory, 20*time.Millisecond)
+	if err := router.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close() //nolint:errcheck
+
+	if err := router.Entry(entryForURL("https://example.com/", "tenant")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(router.Keys()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle child wasn't closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The key is seen again, a new child is created.
+	if err := router.Entry(entryForURL("https://example.com/", "tenant")); err != nil {
+		t.Fatal(err)
+	}
+
+	created := rt.get("tenant")
+	if len(created) != 2 {
+		t.Fatalf("created %d receivers, want 2", len(created))
+	}
+	if !created[0].closed {
+		t.Error("idle child wasn't closed")
+	}
+}
+
+func TestRouterFactoryError(t *testing.T) {
+	t.Parallel()
+
+	rt := &routerTest{created: make(map[string][]*failingReceiver)}
+	router := receiver.NewRouter(func(*har.Entry) string { return "bad" }, rt.factory, 0)
+	if err := router.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := router.Entry(&har.Entry{}); err == nil {
+		t.Error("Entry: expected error, got nil")
+	}
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouterSlowChild(t *testing.T) {
+	t.Parallel()
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	factory := func(key string) (receiver.Receiver, error) {
+		if key == "slow" {
+			close(entered)
+			<-release
+		}
+		return receiver.NewMemoryReceiver(), nil
+	}
+
+	router := receiver.NewRouter(receiver.RouteByPage(), factory, 0)
+	if err := router.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+
+	slowDone := make(chan error, 1)
+	go func() {
+		slowDone <- router.Entry(entryForURL("https://example.com/", "slow"))
+	}()
+	<-entered
+
+	// The slow child is still being created, other children aren't held up by it.
+	fastDone := make(chan error, 1)
+	go func() {
+		fastDone <- router.Entry(entryForURL("https://example.com/", "fast"))
+	}()
+
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("entry for another child waited for the slow child")
+	}
+
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+
+	keys := router.Keys()
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"fast", "slow"}) {
+		t.Errorf("keys = %v", keys)
+	}
+
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouterClosed(t *testing.T) {
+	t.Parallel()
+
+	rt := &routerTest{created: make(map[string][]*failingReceiver)}
+	// A timeout this short rounds the check interval down to zero.
+	router := receiver.NewRouter(receiver.RouteByPage(), rt.factory, time.Nanosecond)
+	if err := router.Start(&receiver.Version{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// No child is created after Close, it would never be closed.
+	if err := router.Entry(entryForURL("https://example.com/", "tenant")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Entry after Close: got %v, want os.ErrClosed", err)
+	}
+	if created := rt.get("tenant"); len(created) != 0 {
+		t.Errorf("created %d receivers after Close", len(created))
+	}
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+const (
+	segmentPrefix    = "entries-"
+	segmentExtension = ".ndjson"
+	indexExtension   = ".idx"
+	pagesFile        = "pages.ndjson"
+	metaFile         = "meta.json"
+)
+
+// indexRecord describes a single entry in a segment. The index of a segment has one record per line, in the order the
+// entries were written.
+type indexRecord struct {
+	// Offset and Length locate the entry in the segment, the length doesn't include the trailing newline.
+	Offset int64 `json:"offset"`
+	Length int   `json:"length"`
+	// EntrySummary contains the fields queries match on.
+	receiver.EntrySummary
+}
+
+func newIndexRecord(entry *har.Entry, offset int64, length int) indexRecord {
+	return indexRecord{
+		Offset:       offset,
+		Length:       length,
+		EntrySummary: receiver.SummarizeEntry(entry),
+	}
+}
+
+// segment is a segment file along with its index.
+type segment struct {
+	number  int
+	records []indexRecord
+}
+
+func segmentName(dir string, number int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%08d%s", segmentPrefix, number, segmentExtension))
+}
+
+func indexName(dir string, number int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%08d%s", segmentPrefix, number, indexExtension))
+}
+
+// listSegments returns the numbers of the segments in a directory in ascending order.
+func listSegments(dir string) ([]int, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []int
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+
+		number, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentExtension))
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+
+	sort.Ints(numbers)
+
+	return numbers, nil
+}
+
+// readIndex reads the complete records of a segment index. It also returns the size of the complete records, anything
+// after it was cut short by a crash. A missing index is treated like an empty one.
+func readIndex(fileName string) ([]indexRecord, int64, error) {
+	fp, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fp.Close() //nolint:errcheck
+
+	var (
+		records []indexRecord
+		size    int64
+	)
+
+	br := bufio.NewReaderSize(fp, 64*1024)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Anything after the last newline is incomplete.
+			return records, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var rec indexRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, 0, fmt.Errorf("invalid index %q: %w", fileName, err)
+		}
+
+		records = append(records, rec)
+		size += int64(len(line))
+	}
+}
+
+// recoverSegment makes the index of a segment cover every complete entry in it. Entries are written to the segment
+// before their index records, so a crash can leave entries without index records and incomplete lines at the end of
+// either file. Missing index records are rebuilt from the segment and incomplete lines are truncated.
+func recoverSegment(dir string, number int) error {
+	idxName := indexName(dir, number)
+	records, idxSize, err := readIndex(idxName)
+	if err != nil {
+		return err
+	}
+
+	var covered int64
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		covered = last.Offset + int64(last.Length) + 1
+	}
+
+	segName := segmentName(dir, number)
+	seg, err := os.OpenFile(segName, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer seg.Close() //nolint:errcheck
+
+	info, err := seg.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == covered {
+		// Only the index might end with an incomplete record.
+		return truncateIfLarger(idxName, idxSize)
+	}
+
+	if _, err := seg.Seek(covered, io.SeekStart); err != nil {
+		return err
+	}
+
+	var rebuilt bytes.Buffer
+	offset := covered
+	br := bufio.NewReaderSize(seg, 64*1024)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := &har.Entry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return fmt.Errorf("invalid entry in %q at offset %d: %w", segName, offset, err)
+		}
+
+		data, err := json.Marshal(newIndexRecord(entry, offset, len(line)-1))
+		if err != nil {
+			return err
+		}
+		rebuilt.Write(data)
+		rebuilt.WriteByte('\n')
+
+		offset += int64(len(line))
+	}
+
+	if err := seg.Truncate(offset); err != nil {
+		return err
+	}
+	if err := seg.Sync(); err != nil {
+		return err
+	}
+
+	idx, err := os.OpenFile(idxName, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := idx.Truncate(idxSize); err != nil {
+		_ = idx.Close()
+		return err
+	}
+
+	if _, err := idx.WriteAt(rebuilt.Bytes(), idxSize); err != nil {
+		_ = idx.Close()
+		return err
+	}
+
+	return errors.Join(idx.Sync(), idx.Close())
+}
+
+func truncateIfLarger(fileName string, size int64) error {
+	info, err := os.Stat(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Size() <= size {
+		return nil
+	}
+
+	return os.Truncate(fileName, size)
+}
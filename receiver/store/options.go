@@ -0,0 +1,11 @@
+package store
+
+type Option func(r *Receiver)
+
+// WithMaxSegmentBytes sets the size at which a new segment is started. The default is 64 MiB. Entries are never split
+// across segments, so a segment may be larger than this by up to the size of one entry.
+func WithMaxSegmentBytes(maxBytes int64) Option {
+	return func(r *Receiver) {
+		r.maxSegmentBytes = maxBytes
+	}
+}
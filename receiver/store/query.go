@@ -0,0 +1,234 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// Query selects entries from a Store. Zero fields match everything.
+type Query = receiver.Query
+
+// Store reads a store directory written by a Receiver. It's a snapshot of the entries that were flushed when it was
+// opened, entries written afterward require opening the store again.
+type Store struct {
+	dir      string
+	version  *receiver.Version
+	segments []segment
+	pages    map[string]*har.Page
+}
+
+// Open reads the indexes and pages of a store directory. Only the indexes are kept in memory, entries are read from the
+// segments as they're queried.
+func Open(dir string) (*Store, error) {
+	s := &Store{
+		dir:     dir,
+		version: &receiver.Version{},
+		pages:   make(map[string]*har.Page),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, metaFile))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, s.version); err != nil {
+			return nil, fmt.Errorf("invalid store metadata in %q: %w", dir, err)
+		}
+	}
+
+	numbers, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, number := range numbers {
+		records, _, err := readIndex(indexName(dir, number))
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			s.segments = append(s.segments, segment{number: number, records: records})
+		}
+	}
+
+	if err := s.readPages(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Len returns the number of entries in the store.
+func (s *Store) Len() int {
+	n := 0
+	for _, seg := range s.segments {
+		n += len(seg.records)
+	}
+
+	return n
+}
+
+// Entries returns a Cursor over the entries matching the query, in the order they were written. The cursor must be
+// closed after use.
+func (s *Store) Entries(q Query) *Cursor {
+	return &Cursor{store: s, query: q}
+}
+
+// Export returns a HAR document with the entries matching the query and the pages they reference.
+func (s *Store) Export(q Query) (*har.HTTPArchive, error) {
+	log := &har.Log{
+		Version: s.version.HARVersion,
+		Creator: &har.Agent{
+			Name:    s.version.Creator,
+			Version: s.version.Version,
+		},
+		Pages:   make([]*har.Page, 0),
+		Entries: make([]*har.Entry, 0),
+	}
+
+	cursor := s.Entries(q)
+	defer cursor.Close() //nolint:errcheck
+
+	seenPages := make(map[string]struct{})
+	for {
+		entry, err := cursor.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		log.Entries = append(log.Entries, entry)
+
+		if _, seen := seenPages[entry.PageRef]; entry.PageRef == "" || seen {
+			continue
+		}
+		seenPages[entry.PageRef] = struct{}{}
+
+		if page, ok := s.pages[entry.PageRef]; ok {
+			log.Pages = append(log.Pages, page)
+		}
+	}
+
+	return &har.HTTPArchive{Log: log}, nil
+}
+
+// readPages reads the pages, an incomplete last line is ignored.
+func (s *Store) readPages() error {
+	fp, err := os.Open(filepath.Join(s.dir, pagesFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fp.Close() //nolint:errcheck
+
+	br := bufio.NewReaderSize(fp, 64*1024)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		page := &har.Page{}
+		if err := json.Unmarshal(line, page); err != nil {
+			return fmt.Errorf("invalid page in %q: %w", fp.Name(), err)
+		}
+		s.pages[page.ID] = page
+	}
+}
+
+// Cursor streams the entries matching a query, see Store.Entries.
+type Cursor struct {
+	store  *Store
+	query  Query
+	segIdx int
+	recIdx int
+	fp     *os.File
+	buf    []byte
+}
+
+// Next returns the next matching entry. It returns io.EOF once all matching entries have been read.
+func (c *Cursor) Next() (*har.Entry, error) {
+	for c.segIdx < len(c.store.segments) {
+		seg := &c.store.segments[c.segIdx]
+
+		for c.recIdx < len(seg.records) {
+			rec := &seg.records[c.recIdx]
+			c.recIdx++
+
+			if !c.query.Matches(&rec.EntrySummary) {
+				continue
+			}
+
+			return c.read(seg, rec)
+		}
+
+		c.segIdx++
+		c.recIdx = 0
+		if err := c.closeSegment(); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, io.EOF
+}
+
+// Close closes the segment the cursor is reading from.
+func (c *Cursor) Close() error {
+	c.segIdx = len(c.store.segments)
+
+	return c.closeSegment()
+}
+
+func (c *Cursor) read(seg *segment, rec *indexRecord) (*har.Entry, error) {
+	if c.fp == nil {
+		fp, err := os.Open(segmentName(c.store.dir, seg.number))
+		if err != nil {
+			return nil, err
+		}
+		c.fp = fp
+	}
+
+	if cap(c.buf) < rec.Length {
+		c.buf = make([]byte, rec.Length)
+	}
+	c.buf = c.buf[:rec.Length]
+
+	if _, err := c.fp.ReadAt(c.buf, rec.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read entry at offset %d of %q: %w", rec.Offset, c.fp.Name(), err)
+	}
+
+	entry := &har.Entry{}
+	if err := json.Unmarshal(c.buf, entry); err != nil {
+		return nil, fmt.Errorf("invalid entry at offset %d of %q: %w", rec.Offset, c.fp.Name(), err)
+	}
+
+	return entry, nil
+}
+
+func (c *Cursor) closeSegment() error {
+	if c.fp == nil {
+		return nil
+	}
+
+	err := c.fp.Close()
+	c.fp = nil
+
+	return err
+}
@@ -0,0 +1,296 @@
+// Package store provides a receiver that writes entries to an append-only log on disk, along with a query API to read
+// them back out.
+//
+// HAR documents have to be rewritten or loaded as a whole, which makes them a poor fit for capturing over days or
+// weeks. A store is a directory containing:
+//
+//   - segments ("entries-00000001.ndjson"), with one JSON encoded entry per line. A new segment is started for every
+//     Start and whenever a segment grows past the configured size (see: WithMaxSegmentBytes).
+//   - an index per segment ("entries-00000001.idx"), with one line per entry containing its location in the segment,
+//     its time range, host, status code and page.
+//   - the pages ("pages.ndjson"), with one JSON encoded page per line.
+//   - the version information of the last session ("meta.json").
+//
+// Entries are written to a segment before their index records. If the writing process crashes, the next Start
+// rebuilds the missing index records from the segment and drops incomplete lines. Readers (see: Open) only use entries
+// covered by the index.
+//
+// Only one Receiver may write to a directory at a time, any number of readers may read it concurrently.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/internal/atomicfile"
+	"github.com/swedishborgie/daytripper/receiver"
+)
+
+// Receiver writes entries and pages to a store directory.
+type Receiver struct {
+	dir             string
+	maxSegmentBytes int64
+
+	mutex     sync.Mutex
+	segNumber int
+	seg       *os.File
+	segBW     *bufio.Writer
+	segSize   int64
+	idx       *os.File
+	pending   []indexRecord // index records of entries that haven't been flushed to the segment yet
+	pages     *os.File
+	pagesBW   *bufio.Writer
+}
+
+// New creates a new Receiver writing to the given directory. The directory is created when Receiver.Start is called
+// if it doesn't exist yet. Existing stores are added to.
+func New(dir string, opts ...Option) *Receiver {
+	r := &Receiver{
+		dir:             dir,
+		maxSegmentBytes: 64 * 1024 * 1024,
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+// Start creates the directory, recovers from an earlier crash if needed and starts a new segment.
+func (r *Receiver) Start(version *receiver.Version) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+
+	if err := r.writeMeta(version); err != nil {
+		return err
+	}
+
+	numbers, err := listSegments(r.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, number := range numbers {
+		if err := recoverSegment(r.dir, number); err != nil {
+			return err
+		}
+	}
+
+	if len(numbers) > 0 {
+		r.segNumber = numbers[len(numbers)-1]
+	}
+
+	if err := dropIncompleteLine(filepath.Join(r.dir, pagesFile)); err != nil {
+		return err
+	}
+
+	pages, err := os.OpenFile(filepath.Join(r.dir, pagesFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	r.pages = pages
+	r.pagesBW = bufio.NewWriterSize(pages, 64*1024)
+
+	return r.openSegment()
+}
+
+// Entry appends an entry to the current segment, starting a new segment first if the current one is full.
+func (r *Receiver) Entry(entry *har.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.seg == nil {
+		return os.ErrClosed
+	}
+
+	if r.segSize > 0 && r.segSize+int64(len(data))+1 > r.maxSegmentBytes {
+		if err := r.closeSegment(); err != nil {
+			return err
+		}
+		if err := r.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.segBW.Write(data); err != nil {
+		return err
+	}
+	if err := r.segBW.WriteByte('\n'); err != nil {
+		return err
+	}
+
+	r.pending = append(r.pending, newIndexRecord(entry, r.segSize, len(data)))
+	r.segSize += int64(len(data)) + 1
+
+	return nil
+}
+
+// Page appends a page. If a page is received more than once, readers use the last one.
+func (r *Receiver) Page(page *har.Page) {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.pagesBW == nil {
+		return
+	}
+
+	_, _ = r.pagesBW.Write(data)
+	_ = r.pagesBW.WriteByte('\n')
+}
+
+// Flush writes and syncs the buffered entries, their index records and the buffered pages. Every entry received
+// before Flush is visible to readers opened afterward.
+func (r *Receiver) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.flush()
+}
+
+// Close flushes and closes all files. Calling Close more than once is safe; subsequent calls are no-ops.
+func (r *Receiver) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.seg == nil {
+		return nil
+	}
+
+	err := r.closeSegment()
+	err = errors.Join(err, r.pagesBW.Flush(), r.pages.Sync(), r.pages.Close())
+
+	r.pages = nil
+	r.pagesBW = nil
+
+	return err
+}
+
+// flush writes the buffered entries before their index records, so the index never references entries that aren't on
+// disk. The caller must hold the mutex.
+func (r *Receiver) flush() error {
+	if r.seg == nil {
+		return os.ErrClosed
+	}
+
+	if err := r.segBW.Flush(); err != nil {
+		return err
+	}
+	if err := r.seg.Sync(); err != nil {
+		return err
+	}
+
+	if len(r.pending) > 0 {
+		var buf bytes.Buffer
+		for _, rec := range r.pending {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			buf.Write(data)
+			buf.WriteByte('\n')
+		}
+
+		if _, err := r.idx.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if err := r.idx.Sync(); err != nil {
+			return err
+		}
+		r.pending = r.pending[:0]
+	}
+
+	if err := r.pagesBW.Flush(); err != nil {
+		return err
+	}
+
+	return r.pages.Sync()
+}
+
+// openSegment creates the next segment and its index. The caller must hold the mutex.
+func (r *Receiver) openSegment() error {
+	number := r.segNumber + 1
+
+	seg, err := os.OpenFile(segmentName(r.dir, number), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	idx, err := os.OpenFile(indexName(r.dir, number), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		_ = seg.Close()
+		return err
+	}
+
+	atomicfile.SyncDir(r.dir)
+
+	r.segNumber = number
+	r.seg = seg
+	r.segBW = bufio.NewWriterSize(seg, 64*1024)
+	r.segSize = 0
+	r.idx = idx
+
+	return nil
+}
+
+// closeSegment flushes and closes the current segment and its index. The caller must hold the mutex.
+func (r *Receiver) closeSegment() error {
+	err := r.flush()
+	err = errors.Join(err, r.seg.Close(), r.idx.Close())
+
+	r.seg = nil
+	r.segBW = nil
+	r.idx = nil
+
+	return err
+}
+
+// writeMeta atomically replaces the version information. The caller must hold the mutex.
+func (r *Receiver) writeMeta(version *receiver.Version) error {
+	data, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(filepath.Join(r.dir, metaFile), data, 0o644)
+}
+
+// dropIncompleteLine truncates a file after its last newline, so lines appended afterward aren't joined to a line that
+// was cut short by a crash.
+func dropIncompleteLine(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete == len(data) {
+		return nil
+	}
+
+	return os.Truncate(fileName, int64(complete))
+}
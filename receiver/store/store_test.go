@@ -0,0 +1,255 @@
+package store_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swedishborgie/daytripper/har"
+	"github.com/swedishborgie/daytripper/receiver"
+	"github.com/swedishborgie/daytripper/receiver/store"
+)
+
+var (
+	testVersion = &receiver.Version{HARVersion: "1.2", Creator: "test", Version: "0.1"}
+	baseTime    = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+)
+
+func testEntry(i int) *har.Entry {
+	hosts := []string{"a.example.com", "b.example.com"}
+	statuses := []int{200, 404, 500}
+
+	return &har.Entry{
+		StartedDateTime: baseTime.Add(time.Duration(i) * time.Minute),
+		Time:            har.DurationMS(time.Second),
+		PageRef:         fmt.Sprintf("page_%d", i%2),
+		Comment:         fmt.Sprintf("entry %d", i),
+		Request:         &har.Request{Method: "GET", URL: fmt.Sprintf("https://%s/%d", hosts[i%2], i)},
+		Response:        &har.Response{Status: statuses[i%3]},
+	}
+}
+
+func writeStore(t *testing.T, dir string, from, to int, opts ...store.Option) {
+	t.Helper()
+
+	recv := store.New(dir, opts...)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := from; i < to; i++ {
+		if err := recv.Entry(testEntry(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv.Page(&har.Page{ID: "page_0", Title: "zero", PageTimings: &har.PageTimings{}})
+	recv.Page(&har.Page{ID: "page_1", Title: "one", PageTimings: &har.PageTimings{}})
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func queryComments(t *testing.T, s *store.Store, q store.Query) []string {
+	t.Helper()
+
+	cursor := s.Entries(q)
+	defer cursor.Close() //nolint:errcheck
+
+	var comments []string
+	for {
+		entry, err := cursor.Next()
+		if errors.Is(err, io.EOF) {
+			return comments
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		comments = append(comments, entry.Comment)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "store")
+	writeStore(t, dir, 0, 12, store.WithMaxSegmentBytes(500))
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "entries-*.ndjson"))
+	if len(segments) < 2 {
+		t.Fatalf("got %d segments, want the entries split across several", len(segments))
+	}
+
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 12 {
+		t.Fatalf("Len = %d, want 12", s.Len())
+	}
+
+	tests := []struct {
+		name  string
+		query store.Query
+		want  []string
+	}{
+		{"all", store.Query{}, []string{
+			"entry 0", "entry 1", "entry 2", "entry 3", "entry 4", "entry 5",
+			"entry 6", "entry 7", "entry 8", "entry 9", "entry 10", "entry 11",
+		}},
+		{"time", store.Query{From: baseTime.Add(3 * time.Minute), To: baseTime.Add(5 * time.Minute)}, []string{
+			"entry 3", "entry 4", "entry 5",
+		}},
+		{"host", store.Query{Host: "b.example.com", To: baseTime.Add(5 * time.Minute)}, []string{
+			"entry 1", "entry 3", "entry 5",
+		}},
+		{"status", store.Query{StatusCode: 500}, []string{"entry 2", "entry 5", "entry 8", "entry 11"}},
+		{"page", store.Query{PageID: "page_0", StatusCode: 200}, []string{"entry 0", "entry 6"}},
+		{"none", store.Query{Host: "c.example.com"}, nil},
+	}
+	for _, tt := range tests {
+		got := queryComments(t, s, tt.query)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeStore(t, dir, 0, 6)
+
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := s.Export(store.Query{From: baseTime.Add(2 * time.Minute), To: baseTime.Add(2 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if archive.Log.Version != "1.2" || archive.Log.Creator.Name != "test" {
+		t.Errorf("log = %+v, want the version of the writer", archive.Log)
+	}
+	if len(archive.Log.Entries) != 1 || archive.Log.Entries[0].Comment != "entry 2" {
+		t.Fatalf("got %d entries, want entry 2", len(archive.Log.Entries))
+	}
+	if len(archive.Log.Pages) != 1 || archive.Log.Pages[0].Title != "zero" {
+		t.Errorf("got pages %+v, want only the referenced page", archive.Log.Pages)
+	}
+}
+
+func TestAppendSessions(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeStore(t, dir, 0, 3)
+	writeStore(t, dir, 3, 5)
+
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := queryComments(t, s, store.Query{})
+	if fmt.Sprint(got) != "[entry 0 entry 1 entry 2 entry 3 entry 4]" {
+		t.Errorf("got %v, want the entries of both sessions", got)
+	}
+}
+
+func TestFlushVisibility(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	recv := store.New(dir)
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	defer recv.Close() //nolint:errcheck
+
+	if err := recv.Entry(testEntry(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len before Flush = %d, want 0", s.Len())
+	}
+
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = store.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len after Flush = %d, want 1", s.Len())
+	}
+}
+
+func TestRecoverAfterCrash(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeStore(t, dir, 0, 4)
+
+	// Simulate a crash after the entries were written but before their index records were complete: keep only the
+	// first index record plus half of the second, and leave half an entry at the end of the segment.
+	idxName := filepath.Join(dir, "entries-00000001.idx")
+	idx, err := os.ReadFile(idxName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstLine := 0
+	for idx[firstLine] != '\n' {
+		firstLine++
+	}
+	if err := os.WriteFile(idxName, idx[:firstLine+10], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	segName := filepath.Join(dir, "entries-00000001.ndjson")
+	seg, err := os.OpenFile(segName, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seg.WriteString(`{"startedDateTime":"2024-01-0`); err != nil {
+		t.Fatal(err)
+	}
+	if err := seg.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len before recovery = %d, want only the indexed entry", s.Len())
+	}
+
+	writeStore(t, dir, 4, 5)
+
+	if s, err = store.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	got := queryComments(t, s, store.Query{})
+	if fmt.Sprint(got) != "[entry 0 entry 1 entry 2 entry 3 entry 4]" {
+		t.Errorf("got %v, want every complete entry", got)
+	}
+
+	if got := queryComments(t, s, store.Query{StatusCode: 404, Host: "b.example.com"}); fmt.Sprint(got) != "[entry 1]" {
+		t.Errorf("got %v, want the rebuilt index to be queryable", got)
+	}
+}
@@ -2,11 +2,13 @@ package streaming
 
 import (
 	"bufio"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"sync"
 
+	"github.com/swedishborgie/daytripper/encrypted"
 	"github.com/swedishborgie/daytripper/har"
 	"github.com/swedishborgie/daytripper/receiver"
 )
@@ -16,10 +18,10 @@ import (
 //
 // The output is not valid JSON until Close() is called, which writes the pages array and closes the JSON structure.
 // Flush() pushes the internal write buffer to the underlying writer for durability, but does not produce parseable
-// output on its own.
+// output on its own, unless WithConsistentFlush is used with a seekable writer.
 //
-// Unlike receiver.HARFileReceiver, this implementation writes the "entries" array before the "pages" array in the
-// JSON output. This ordering is valid per the JSON specification (object field order is not mandated) and is handled
+// Like receiver.HARFileReceiver, this implementation writes the "entries" array before the "pages" array in the JSON
+// output. This ordering is valid per the JSON specification (object field order is not mandated) and is handled
 // correctly by HAR viewers.
 //
 // The caller is responsible for closing the underlying writer after Close() returns.
@@ -27,18 +29,79 @@ type Receiver struct {
 	mutex      sync.Mutex
 	pages      []*har.Page
 	version    *receiver.Version
-	bw         *bufio.Writer // 64 KiB buffer over the provided writer
-	entryCount int           // tracks comma-prefix logic
-	closed     bool          // idempotent close guard
+	w          io.Writer
+	bw         *bufio.Writer     // 64 KiB buffer over the provided writer
+	gz         *gzip.Writer      // set when compressing, sits between bw and w
+	enc        *encrypted.Writer // set when encrypting, sits between gz (or bw) and w
+	entryCount int               // tracks comma-prefix logic
+	closed     bool              // idempotent close guard
+
+	compress         bool
+	compressionLevel int
+	consistent       bool
+	keys             encrypted.KeyProvider
+
+	ws         seekWriter // set when flushes produce valid JSON
+	tailOffset int64      // offset of the end of the document written by the last flush
+	dirty      bool       // data was written since the last flush
+}
+
+// seekWriter is implemented by writers that support consistent flushes, such as *os.File.
+type seekWriter interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+}
+
+// syncer is implemented by writers that can commit written data to stable storage, such as *os.File.
+type syncer interface {
+	Sync() error
+}
+
+type Option func(r *Receiver)
+
+// WithConsistentFlush makes the output valid JSON after every Flush() if the writer can seek and truncate (e.g. an
+// *os.File). Flush writes the end of the document after the last entry, and the next entry overwrites it again, so a
+// crash never loses more than the entries since the last flush. The file is synced to disk if the writer supports it.
+// When compressed, every flush ends a gzip member, the output is a series of members which decompress to the complete
+// document. Writers that can't seek and truncate keep the default behavior.
+func WithConsistentFlush() Option {
+	return func(r *Receiver) {
+		r.consistent = true
+	}
+}
+
+// WithCompression gzip compresses the output with the given compression level (e.g. gzip.DefaultCompression). Flush
+// still pushes everything written so far to the underlying writer.
+func WithCompression(level int) Option {
+	return func(r *Receiver) {
+		r.compress = true
+		r.compressionLevel = level
+	}
+}
+
+// WithEncryption encrypts the output with keys from the given provider (see: package encrypted). Flush seals
+// everything written so far into a chunk of the encrypted stream, Close writes the final chunk. Use encrypted.Reader or
+// the "daytripper decrypt" command to read the output.
+func WithEncryption(keys encrypted.KeyProvider) Option {
+	return func(r *Receiver) {
+		r.keys = keys
+	}
 }
 
 // New creates a new Receiver that writes HAR output to w. Start must be called before any entries or pages are
 // accepted. The caller retains ownership of w and is responsible for closing it after Close() returns.
-func New(w io.Writer) *Receiver {
-	return &Receiver{
+func New(w io.Writer, opts ...Option) *Receiver {
+	r := &Receiver{
+		w:     w,
 		bw:    bufio.NewWriterSize(w, 64*1024),
 		pages: make([]*har.Page, 0),
 	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
 }
 
 // Start writes the HAR JSON prologue to the underlying writer.
@@ -48,6 +111,30 @@ func (r *Receiver) Start(version *receiver.Version) error {
 
 	r.version = version
 
+	var w io.Writer = r.w
+	if r.keys != nil {
+		enc, err := encrypted.NewWriter(r.w, r.keys)
+		if err != nil {
+			return err
+		}
+		r.enc = enc
+		w = enc
+	}
+
+	if r.compress {
+		gz, err := gzip.NewWriterLevel(w, r.compressionLevel)
+		if err != nil {
+			return err
+		}
+		r.gz = gz
+		w = gz
+	}
+	r.bw.Reset(w)
+
+	if ws, ok := r.w.(seekWriter); ok && r.consistent {
+		r.ws = ws
+	}
+
 	creatorBytes, err := json.Marshal(&har.Agent{
 		Name:    version.Creator,
 		Version: version.Version,
@@ -64,8 +151,9 @@ func (r *Receiver) Start(version *receiver.Version) error {
 	if _, err = fmt.Fprintf(r.bw, `{"log":{"version":%s,"creator":%s,"entries":[`, versionBytes, creatorBytes); err != nil {
 		return err
 	}
+	r.dirty = true
 
-	return r.bw.Flush()
+	return r.flush()
 }
 
 // Entry writes a single HTTP request/response pair to the underlying writer.
@@ -89,6 +177,7 @@ func (r *Receiver) Entry(entry *har.Entry) error {
 	}
 
 	r.entryCount++
+	r.dirty = true
 	return nil
 }
 
@@ -100,13 +189,163 @@ func (r *Receiver) Page(page *har.Page) {
 	r.pages = append(r.pages, page)
 }
 
-// Flush pushes the internal write buffer to the underlying writer. The output is not valid JSON after this call;
-// use Close() to produce a parseable HAR document.
+// Flush pushes the internal write buffer to the underlying writer. The output is not valid JSON after this call unless
+// WithConsistentFlush is used with a seekable writer; use Close() to produce a parseable HAR document.
 func (r *Receiver) Flush() error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	return r.bw.Flush()
+	return r.flush()
+}
+
+// flush pushes the internal write buffer, and the compressor if there is one, to the underlying writer. The caller
+// must hold the mutex.
+func (r *Receiver) flush() error {
+	if r.ws != nil {
+		return r.checkpoint()
+	}
+
+	if err := r.bw.Flush(); err != nil {
+		return err
+	}
+
+	if r.gz != nil {
+		if err := r.gz.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if r.enc != nil {
+		return r.enc.Flush()
+	}
+
+	return nil
+}
+
+// checkpoint writes the end of the document after the last entry, truncates anything after it and moves the write
+// position back to the start of it, so the next entry overwrites it. The caller must hold the mutex.
+func (r *Receiver) checkpoint() error {
+	if r.dirty {
+		if err := r.endMember(false); err != nil {
+			return err
+		}
+
+		offset, err := r.ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		r.tailOffset = offset
+		r.dirty = false
+	}
+
+	r.startMember(r.tailOffset)
+	if err := r.writeTail(); err != nil {
+		return err
+	}
+
+	if err := r.endMember(true); err != nil {
+		return err
+	}
+
+	if err := r.truncate(); err != nil {
+		return err
+	}
+
+	if s, ok := r.w.(syncer); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.ws.Seek(r.tailOffset, io.SeekStart); err != nil {
+		return err
+	}
+	r.startMember(r.tailOffset)
+
+	return nil
+}
+
+// startMember starts a new gzip member at the current write position, which must be offset, when compressing, and
+// continues the encrypted stream at offset when encrypting. The internal write buffer must be empty.
+func (r *Receiver) startMember(offset int64) {
+	if r.gz == nil && r.enc == nil {
+		return
+	}
+
+	var w io.Writer = r.w
+	if r.enc != nil {
+		r.enc.Reset(r.w, offset)
+		w = r.enc
+	}
+
+	if r.gz != nil {
+		r.gz.Reset(w)
+		w = r.gz
+	}
+	r.bw.Reset(w)
+}
+
+// endMember pushes everything written to the underlying writer, ending the current gzip member when compressing. When
+// encrypting, the data is sealed into the final chunk of the stream if final is set.
+func (r *Receiver) endMember(final bool) error {
+	if err := r.bw.Flush(); err != nil {
+		return err
+	}
+
+	if r.gz != nil {
+		if err := r.gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	if r.enc == nil {
+		return nil
+	}
+
+	if final {
+		return r.enc.Close()
+	}
+
+	return r.enc.Flush()
+}
+
+// truncate removes anything after the current write position, left over from the end of the document written by an
+// earlier flush.
+func (r *Receiver) truncate() error {
+	end, err := r.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	return r.ws.Truncate(end)
+}
+
+// writeTail closes the entries array, writes the pages array and closes the log and root objects.
+func (r *Receiver) writeTail() error {
+	if _, err := r.bw.WriteString(`],"pages":[`); err != nil {
+		return err
+	}
+
+	for i, page := range r.pages {
+		if i > 0 {
+			if _, err := r.bw.WriteString(",\n"); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(page)
+		if err != nil {
+			return err
+		}
+
+		if _, err := r.bw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	// Close pages array, log object, and root object. Trailing newline matches json.Encoder behaviour.
+	_, err := r.bw.WriteString("]}}\n")
+	return err
 }
 
 // Close finalizes the HAR JSON by writing the pages array and closing the JSON structure, then flushes the internal
@@ -129,28 +368,13 @@ func (r *Receiver) Close() error {
 		}
 	}
 
-	// Close the entries array and open the pages array.
-	_, err := r.bw.WriteString(`],"pages":[`)
-	capture(err)
+	capture(r.writeTail())
+	capture(r.endMember(true))
 
-	for i, page := range r.pages {
-		if i > 0 {
-			_, err = r.bw.WriteString(",\n")
-			capture(err)
-		}
-
-		data, err := json.Marshal(page)
-		capture(err)
-
-		_, err = r.bw.Write(data)
-		capture(err)
+	if r.ws != nil {
+		// Remove what's left of the end of the document written by the last flush.
+		capture(r.truncate())
 	}
 
-	// Close pages array, log object, and root object. Trailing newline matches json.Encoder behaviour.
-	_, err = r.bw.WriteString("]}}\n")
-	capture(err)
-
-	capture(r.bw.Flush())
-
 	return retErr
 }
@@ -2,11 +2,17 @@ package streaming_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
+	"github.com/swedishborgie/daytripper/encrypted"
 	"github.com/swedishborgie/daytripper/har"
 	"github.com/swedishborgie/daytripper/receiver"
 	"github.com/swedishborgie/daytripper/receiver/streaming"
@@ -18,6 +24,8 @@ var testVersion = &receiver.Version{
 	Version:    "1.2.3",
 }
 
+var testKeys = encrypted.StaticKey("test", bytes.Repeat([]byte{1}, 32))
+
 func newRecv(t *testing.T) (*streaming.Receiver, *bytes.Buffer) {
 	t.Helper()
 	var buf bytes.Buffer
@@ -292,3 +300,232 @@ type errWriter struct{}
 func (e *errWriter) Write(_ []byte) (int, error) {
 	return 0, fmt.Errorf("write error")
 }
+
+// TestReceiver_Compression checks that compressed output is durable after Flush and a valid HAR after Close.
+func TestReceiver_Compression(t *testing.T) {
+	var buf bytes.Buffer
+	recv := streaming.New(&buf, streaming.WithCompression(gzip.BestCompression))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if err := recv.Entry(&har.Entry{Comment: "flushed entry"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything written before Flush can be decompressed, even though the gzip stream isn't finished.
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, _ := io.ReadAll(gz)
+	if !bytes.Contains(partial, []byte("flushed entry")) {
+		t.Errorf("flushed output doesn't contain the entry: %q", partial)
+	}
+
+	recv.Page(&har.Page{Comment: "test page"})
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err = gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := &har.HTTPArchive{}
+	if err := json.NewDecoder(gz).Decode(archive); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(archive.Log.Entries) != 1 || len(archive.Log.Pages) != 1 {
+		t.Errorf("got %d entries and %d pages, want 1 and 1", len(archive.Log.Entries), len(archive.Log.Pages))
+	}
+}
+
+// TestReceiver_Encryption checks that encrypted output is authenticated up to the last Flush and complete after Close.
+func TestReceiver_Encryption(t *testing.T) {
+	var buf bytes.Buffer
+	recv := streaming.New(&buf, streaming.WithEncryption(testKeys))
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if err := recv.Entry(&har.Entry{Comment: "flushed entry"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("flushed entry")) {
+		t.Fatal("output contains plaintext")
+	}
+
+	// Everything written before Flush can be decrypted, even though the stream isn't finished.
+	partial, err := io.ReadAll(encrypted.NewReader(bytes.NewReader(buf.Bytes()), testKeys))
+	if !errors.Is(err, encrypted.ErrTruncated) {
+		t.Errorf("got %v, want %v before Close", err, encrypted.ErrTruncated)
+	}
+	if !bytes.Contains(partial, []byte("flushed entry")) {
+		t.Errorf("flushed output doesn't contain the entry: %q", partial)
+	}
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &har.HTTPArchive{}
+	if err := json.NewDecoder(encrypted.NewReader(&buf, testKeys)).Decode(archive); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(archive.Log.Entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(archive.Log.Entries))
+	}
+}
+
+// TestReceiver_InvalidCompressionLevel checks that an invalid compression level is reported by Start.
+func TestReceiver_InvalidCompressionLevel(t *testing.T) {
+	recv := streaming.New(io.Discard, streaming.WithCompression(42))
+	if err := recv.Start(testVersion); err == nil {
+		t.Fatal("Start: expected error, got nil")
+	}
+}
+
+func TestReceiver_ConsistentFlush(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		compressed bool
+		encrypted  bool
+	}{
+		{name: "plain"},
+		{name: "compressed", compressed: true},
+		{name: "encrypted", encrypted: true},
+		{name: "compressed and encrypted", compressed: true, encrypted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fp, err := os.Create(filepath.Join(t.TempDir(), "test.har"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close() //nolint:errcheck
+
+			opts := []streaming.Option{streaming.WithConsistentFlush()}
+			if tc.compressed {
+				opts = append(opts, streaming.WithCompression(gzip.BestSpeed))
+			}
+			if tc.encrypted {
+				opts = append(opts, streaming.WithEncryption(testKeys))
+			}
+
+			recv := streaming.New(fp, opts...)
+			if err := recv.Start(testVersion); err != nil {
+				t.Fatal(err)
+			}
+
+			readBack := func() *har.HTTPArchive {
+				t.Helper()
+
+				data, err := os.ReadFile(fp.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var r io.Reader = bytes.NewReader(data)
+				if tc.encrypted {
+					plain, err := io.ReadAll(encrypted.NewReader(r, testKeys))
+					if err != nil {
+						t.Fatal(err)
+					}
+					r = bytes.NewReader(plain)
+				}
+				if tc.compressed {
+					gz, err := gzip.NewReader(r)
+					if err != nil {
+						t.Fatal(err)
+					}
+					r = gz
+				}
+
+				archive := &har.HTTPArchive{}
+				dec := json.NewDecoder(r)
+				if err := dec.Decode(archive); err != nil {
+					t.Fatalf("output isn't valid JSON: %v", err)
+				}
+				if dec.More() {
+					t.Fatal("output has trailing data")
+				}
+				return archive
+			}
+
+			if got := len(readBack().Log.Entries); got != 0 {
+				t.Fatalf("got %d entries after Start, want 0", got)
+			}
+
+			for i := range 3 {
+				if err := recv.Entry(&har.Entry{Comment: fmt.Sprintf("entry %d", i)}); err != nil {
+					t.Fatal(err)
+				}
+				recv.Page(&har.Page{ID: fmt.Sprintf("page_%d", i)})
+
+				// Flushing twice must not duplicate the end of the document.
+				for range 2 {
+					if err := recv.Flush(); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				archive := readBack()
+				if len(archive.Log.Entries) != i+1 || len(archive.Log.Pages) != i+1 {
+					t.Fatalf("flush %d: got %d entries and %d pages, want %d", i, len(archive.Log.Entries),
+						len(archive.Log.Pages), i+1)
+				}
+			}
+
+			if err := recv.Entry(&har.Entry{Comment: "last"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := recv.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			archive := readBack()
+			if len(archive.Log.Entries) != 4 || archive.Log.Entries[3].Comment != "last" {
+				t.Errorf("unexpected entries after Close: %+v", archive.Log.Entries)
+			}
+		})
+	}
+}
+
+func TestReceiver_ConsistentFlushNotSeekable(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	recv := streaming.New(&buf, streaming.WithConsistentFlush())
+	if err := recv.Start(testVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Entry(&har.Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recv.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without seeking the output stays a prefix of the document until Close.
+	if json.Valid(buf.Bytes()) {
+		t.Error("expected the output to be incomplete before Close")
+	}
+
+	if err := recv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Error("expected valid JSON after Close")
+	}
+}
@@ -25,6 +25,8 @@ type tripReport struct {
 	rspBody *streamCopier
 	rspErr  error
 	entry   *har.Entry
+	// maxBodySize is the body size limit in effect when the request was started.
+	maxBodySize int64
 }
 
 func (d *DayTripper) recordTrip(report *tripReport) error {
@@ -33,7 +35,7 @@ func (d *DayTripper) recordTrip(report *tripReport) error {
 	d.recordRequest(report)
 	d.recordResponse(report)
 
-	if err := d.sendEntry(report.entry); err != nil {
+	if err := d.emitEntry(report.entry); err != nil {
 		return err
 	}
 
@@ -93,7 +95,7 @@ func (d *DayTripper) recordRequest(report *tripReport) {
 		}
 
 		if truncated {
-			pd.Comment = fmt.Sprintf("body truncated at %d bytes", d.maxBodySize)
+			pd.Comment = fmt.Sprintf("body truncated at %d bytes", report.maxBodySize)
 		}
 		report.entry.Request.PostData = pd
 	}
@@ -153,11 +155,11 @@ func (d *DayTripper) recordResponse(report *tripReport) {
 
 		if enc := report.rsp.Header.Get("Content-Encoding"); enc != "" {
 			var buf bytes.Buffer
-			if err := d.bodyDecoder(enc, bytes.NewReader(bodyBytes), &buf, d.maxBodySize); err == nil {
+			if err := d.bodyDecoder(enc, bytes.NewReader(bodyBytes), &buf, report.maxBodySize); err == nil {
 				decoded := buf.Bytes()
-				if d.maxBodySize > 0 && int64(len(decoded)) > d.maxBodySize {
+				if report.maxBodySize > 0 && int64(len(decoded)) > report.maxBodySize {
 					truncated = true
-					decoded = decoded[:d.maxBodySize]
+					decoded = decoded[:report.maxBodySize]
 				}
 				bodyBytes = decoded
 			}
@@ -178,7 +180,7 @@ func (d *DayTripper) recordResponse(report *tripReport) {
 		}
 
 		if truncated {
-			report.entry.Response.Content.Comment = fmt.Sprintf("body truncated at %d bytes", d.maxBodySize)
+			report.entry.Response.Content.Comment = fmt.Sprintf("body truncated at %d bytes", report.maxBodySize)
 		}
 	}
 